@@ -0,0 +1,381 @@
+package cryptobill
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Rate units for AdapterConfig.Unit.
+const (
+	FiatPerCoin = "fiat-per-coin"
+	CoinPerFiat = "coin-per-fiat"
+)
+
+// AdapterConfig describes a quote-only service by where its rates are and how to read them. They
+// are read from adapters.yaml or adapters.json in the config directory.
+//
+// The rates are either an object keyed by pair, e.g. {"AUD_BTC": 9000}, matched with Key, or a
+// list of objects with the pair and rate in fields, e.g. [{"coin": "BTC", "price": 9000}], read
+// with CryptoPath, FiatPath and RatePath. Paths are dot separated keys or list indexes, e.g.
+// "data.rates" or "result.0.last".
+type AdapterConfig struct {
+	ShortName string   `json:"short_name" yaml:"short_name"`
+	Name      string   `json:"name" yaml:"name"`
+	Website   string   `json:"website" yaml:"website"`
+	Aliases   []string `json:"aliases" yaml:"aliases"`
+
+	// Rate endpoint. {fiat} is replaced with the fiat being quoted.
+	URL     string            `json:"url" yaml:"url"`
+	Headers map[string]string `json:"headers" yaml:"headers"`
+
+	// Path to the rates in the response. Empty for the whole response.
+	Rates string `json:"rates" yaml:"rates"`
+
+	// How each key of a rates object names the pair, e.g. "{fiat}_{crypto}" or "{crypto}Rate".
+	Key string `json:"key" yaml:"key"`
+
+	// Paths within each item of a rates list.
+	CryptoPath string `json:"crypto_path" yaml:"crypto_path"`
+	FiatPath   string `json:"fiat_path" yaml:"fiat_path"`
+
+	// Path to the rate within each item or value of a rates object. Empty for the value itself.
+	RatePath string `json:"rate_path" yaml:"rate_path"`
+
+	// The fiat rates are in when the key or item doesn't say.
+	Fiat Currency `json:"fiat" yaml:"fiat"`
+
+	// Fiats the service has rates in. Quotes in others are converted from the first. Defaults to
+	// fiat, or to asking for whatever fiat is quoted if the url or rates name it.
+	Fiats []Currency `json:"fiats" yaml:"fiats"`
+
+	// The service's symbols for currencies that cryptobill names differently, e.g. XBT: BTC.
+	Symbols map[string]Currency `json:"symbols" yaml:"symbols"`
+
+	// FiatPerCoin (the default) or CoinPerFiat.
+	Unit string `json:"unit" yaml:"unit"`
+}
+
+// ConfigAdapter quotes a service described by an AdapterConfig. It can't pay.
+type ConfigAdapter struct {
+	config AdapterConfig
+	pairs  *PairFormat
+}
+
+// NewConfigAdapter checks the config and makes a service from it.
+func NewConfigAdapter(config AdapterConfig) (*ConfigAdapter, error) {
+	a := &ConfigAdapter{config: config}
+
+	if config.ShortName == "" {
+		return nil, errors.New("no short_name")
+	}
+	if config.URL == "" {
+		return nil, errors.New("no url")
+	}
+	switch config.Unit {
+	case "", FiatPerCoin, CoinPerFiat:
+	default:
+		return nil, errors.Errorf("unit %q isn't %v or %v", config.Unit, FiatPerCoin, CoinPerFiat)
+	}
+
+	// Items of a list name the crypto on its own.
+	key := "{crypto}"
+	if config.Key != "" {
+		if config.CryptoPath != "" || config.FiatPath != "" {
+			return nil, errors.New("key is for a rates object, crypto_path and fiat_path are for a list")
+		}
+		key = config.Key
+	} else if config.CryptoPath == "" {
+		return nil, errors.New("either key or crypto_path is needed")
+	}
+
+	var err error
+	a.pairs, err = NewPairFormat(key, config.Fiat, config.Symbols)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Fiat == "" && !a.pairs.HasFiat() && config.FiatPath == "" {
+		return nil, errors.New("fiat is needed when the key or item doesn't give it")
+	}
+
+	return a, nil
+}
+
+// LoadAdapters reads adapters.yaml, adapters.yml or adapters.json from dir, if there is one.
+func LoadAdapters(dir string) ([]AdapterConfig, error) {
+	for _, name := range []string{"adapters.yaml", "adapters.yml", "adapters.json"} {
+		path := filepath.Join(dir, name)
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "read "+path)
+		}
+
+		var configs []AdapterConfig
+		if filepath.Ext(name) == ".json" {
+			err = json.Unmarshal(data, &configs)
+		} else {
+			err = yaml.UnmarshalStrict(data, &configs)
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "decode "+path+", expecting a list of adapters")
+		}
+		return configs, nil
+	}
+
+	return nil, nil
+}
+
+// LoadAdapters registers the services described in the config directory. One with the short name
+// of a built in service replaces it.
+func (cb *CryptoBill) LoadAdapters() error {
+	dir, err := cb.configDir()
+	if err != nil {
+		return err
+	}
+
+	configs, err := LoadAdapters(dir)
+	if err != nil {
+		return errors.Wrap(err, "load adapters")
+	}
+
+	for i, config := range configs {
+		a, err := NewConfigAdapter(config)
+		if err != nil {
+			return errors.Wrapf(err, "adapter %v %v", i+1, config.ShortName)
+		}
+
+		err = cb.Register(a, config.Aliases...)
+		if err != nil {
+			return errors.Wrap(err, "adapter "+config.ShortName)
+		}
+	}
+	return nil
+}
+
+func (a *ConfigAdapter) Name() string {
+	if a.config.Name == "" {
+		return a.config.ShortName
+	}
+	return a.config.Name
+}
+
+func (a *ConfigAdapter) ShortName() string {
+	return a.config.ShortName
+}
+
+func (a *ConfigAdapter) Website() string {
+	return a.config.Website
+}
+
+// Fiats is fiats from the config. Otherwise it's just fiat, unless the url or rates can name
+// others, in which case any fiat is asked for.
+func (a *ConfigAdapter) Fiats() []Currency {
+	if len(a.config.Fiats) > 0 {
+		return a.config.Fiats
+	}
+	if strings.Contains(a.config.URL, "{fiat}") || a.pairs.HasFiat() || a.config.FiatPath != "" {
+		return nil
+	}
+	return []Currency{a.config.Fiat}
+}
+
+func (a *ConfigAdapter) Quote(cb *CryptoBill, info *FiatInfo) ([]QuoteResult, error) {
+	rates, err := a.fetch(cb, info.Fiat)
+	if err != nil {
+		return nil, errors.Wrap(err, a.config.ShortName)
+	}
+
+	var results []QuoteResult
+	reader := newPairReader(a.config.ShortName, a.pairs, info.Fiat)
+	defer reader.Log(cb.log())
+	add := func(pair Pair, value interface{}, where string) error {
+		rate, err := a.rate(value)
+		if err != nil {
+			return errors.Wrap(err, where)
+		}
+		if !reader.Rate(where, rate) {
+			return nil
+		}
+
+		results = append(results, QuoteResult{
+			Service:    a,
+			Pair:       pair,
+			Conversion: Conversion{info.Amount, a.convert(info.Amount, rate)},
+		})
+		return nil
+	}
+
+	if a.config.Key != "" {
+		object, ok := rates.(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("%v: rates are a %T, not an object", a.config.ShortName, rates)
+		}
+
+		for key, value := range object {
+			pair, ok := reader.Parse(key)
+			if !ok {
+				continue
+			}
+
+			err := add(pair, value, key)
+			if err != nil {
+				return nil, errors.Wrap(err, a.config.ShortName)
+			}
+		}
+		return reader.Quotes(results)
+	}
+
+	list, ok := rates.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("%v: rates are a %T, not a list", a.config.ShortName, rates)
+	}
+
+	for i, item := range list {
+		where := fmt.Sprintf("item %v", i)
+
+		crypto, err := lookupString(item, a.config.CryptoPath)
+		if err != nil {
+			return nil, errors.Wrap(err, a.config.ShortName+" "+where)
+		}
+
+		var fiat string
+		if a.config.FiatPath != "" {
+			fiat, err = lookupString(item, a.config.FiatPath)
+			if err != nil {
+				return nil, errors.Wrap(err, a.config.ShortName+" "+where)
+			}
+		}
+
+		pair, ok := reader.Pair(where, fiat, crypto)
+		if !ok {
+			continue
+		}
+
+		err = add(pair, item, where)
+		if err != nil {
+			return nil, errors.Wrap(err, a.config.ShortName)
+		}
+	}
+	return reader.Quotes(results)
+}
+
+func (a *ConfigAdapter) PayBPAY(cb *CryptoBill, bpay *PayBPAY) (*PayResult, error) {
+	return nil, newProviderError(Unsupported, a.config.ShortName, "", "can only quote")
+}
+
+func (a *ConfigAdapter) PayEFT(cb *CryptoBill, eft *PayEFT) (*PayResult, error) {
+	return nil, newProviderError(Unsupported, a.config.ShortName, "", "can only quote")
+}
+
+// fetch requests the rate endpoint and returns what's at the rates path.
+func (a *ConfigAdapter) fetch(cb *CryptoBill, fiat Currency) (interface{}, error) {
+	url := strings.Replace(a.config.URL, "{fiat}", string(fiat), -1)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "request builder")
+	}
+	for k, v := range a.config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := cb.HttpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "request failed")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("%v returned %v", url, resp.Status)
+	}
+
+	var body interface{}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't decode "+url)
+	}
+
+	rates, err := lookup(body, a.config.Rates)
+	if err != nil {
+		return nil, errors.Wrap(err, "rates")
+	}
+	return rates, nil
+}
+
+func (a *ConfigAdapter) rate(value interface{}) (float64, error) {
+	value, err := lookup(value, a.config.RatePath)
+	if err != nil {
+		return 0, err
+	}
+
+	var rate float64
+	switch v := value.(type) {
+	case float64:
+		rate = v
+	case string:
+		rate, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, errors.Errorf("rate %q isn't a number", v)
+		}
+	default:
+		return 0, errors.Errorf("rate is a %T, not a number", value)
+	}
+	return rate, nil
+}
+
+func (a *ConfigAdapter) convert(amount Amount, rate float64) Amount {
+	if a.config.Unit == CoinPerFiat {
+		return amount * Amount(rate)
+	}
+	return amount / Amount(rate)
+}
+
+// lookup follows a dot separated path of object keys and list indexes.
+func lookup(value interface{}, path string) (interface{}, error) {
+	if path == "" {
+		return value, nil
+	}
+
+	for _, part := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			next, ok := v[part]
+			if !ok {
+				return nil, errors.Errorf("no %q in %v", part, path)
+			}
+			value = next
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, errors.Errorf("no item %q in %v", part, path)
+			}
+			value = v[i]
+		default:
+			return nil, errors.Errorf("can't find %q of a %T in %v", part, value, path)
+		}
+	}
+	return value, nil
+}
+
+func lookupString(value interface{}, path string) (string, error) {
+	value, err := lookup(value, path)
+	if err != nil {
+		return "", err
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return "", errors.Errorf("%v is a %T, not a string", path, value)
+	}
+	return s, nil
+}
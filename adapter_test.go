@@ -0,0 +1,192 @@
+package cryptobill
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// serveJSON answers every request with body, and records the last request.
+func serveJSON(t *testing.T, body string) (*httptest.Server, *http.Request) {
+	t.Helper()
+
+	last := &http.Request{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*last = *r
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server, last
+}
+
+func TestConfigAdapterObject(t *testing.T) {
+	server, _ := serveJSON(t, `{"AUD_BTC": 9104.11, "AUD_ETH": "296.02", "USD_BTC": 6500, "AUD_NOPE": 1, "updated": "today"}`)
+	a, err := NewConfigAdapter(AdapterConfig{ShortName: "OBJ", URL: server.URL, Key: "{fiat}_{crypto}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := FiatInfo{Amount: 100, Fiat: "AUD"}
+	results, err := a.Quote(testCryptoBill(t), &info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Other fiats, unknown coins and keys that aren't pairs are left out.
+	amounts := quoteAmounts(t, results, "OBJ", info)
+	if len(amounts) != 2 {
+		t.Fatalf("got quotes for %v, want BTC and ETH", amounts)
+	}
+	assertAmount(t, "BTC", amounts["BTC"], 100/9104.11)
+	assertAmount(t, "ETH", amounts["ETH"], 100/296.02)
+}
+
+func TestConfigAdapterList(t *testing.T) {
+	server, last := serveJSON(t, `{"data": {"prices": [
+		{"coin": "XBT", "quote": {"per_aud": 0.0001}},
+		{"coin": "LTC", "quote": {"per_aud": "0.0125"}}
+	]}}`)
+	a, err := NewConfigAdapter(AdapterConfig{
+		ShortName:  "LIST",
+		URL:        server.URL + "/rates?fiat={fiat}",
+		Headers:    map[string]string{"X-Api-Key": "key"},
+		Rates:      "data.prices",
+		CryptoPath: "coin",
+		RatePath:   "quote.per_aud",
+		Fiat:       "AUD",
+		Symbols:    map[string]Currency{"XBT": "BTC"},
+		Unit:       CoinPerFiat,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := FiatInfo{Amount: 200, Fiat: "AUD"}
+	results, err := a.Quote(testCryptoBill(t), &info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.URL.RawQuery != "fiat=AUD" || last.Header.Get("X-Api-Key") != "key" {
+		t.Errorf("requested %v with %v", last.URL, last.Header)
+	}
+
+	amounts := quoteAmounts(t, results, "LIST", info)
+	if len(amounts) != 2 {
+		t.Fatalf("got quotes for %v, want BTC and LTC", amounts)
+	}
+	assertAmount(t, "BTC", amounts["BTC"], 200*0.0001)
+	assertAmount(t, "LTC", amounts["LTC"], 200*0.0125)
+
+	// The rates are only in AUD.
+	_, err = a.Quote(testCryptoBill(t), &FiatInfo{Amount: 200, Fiat: "USD"})
+	assertKind(t, err, Unsupported, "fiat")
+}
+
+func TestConfigAdapterBadRate(t *testing.T) {
+	server, _ := serveJSON(t, `{"BTCRate": "soon"}`)
+	a, err := NewConfigAdapter(AdapterConfig{ShortName: "BAD", URL: server.URL, Key: "{crypto}Rate", Fiat: "AUD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = a.Quote(testCryptoBill(t), &FiatInfo{Amount: 100, Fiat: "AUD"})
+	if err == nil {
+		t.Fatal("expected an error for a rate that isn't a number")
+	}
+}
+
+func TestConfigAdapterZeroRate(t *testing.T) {
+	server, _ := serveJSON(t, `{"BTCRate": 9000, "ETHRate": 0, "LTCRate": "-1"}`)
+	a, err := NewConfigAdapter(AdapterConfig{ShortName: "ZERO", URL: server.URL, Key: "{crypto}Rate", Fiat: "AUD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cb := testCryptoBill(t)
+	var logs bytes.Buffer
+	cb.Logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	info := FiatInfo{Amount: 100, Fiat: "AUD"}
+
+	// Rates that aren't positive are skipped, leaving the rest quoted.
+	results, err := a.Quote(cb, &info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	amounts := quoteAmounts(t, results, "ZERO", info)
+	if len(amounts) != 1 {
+		t.Fatalf("got quotes for %v, want BTC", amounts)
+	}
+	assertAmount(t, "BTC", amounts["BTC"], 100.0/9000)
+	for _, key := range []string{"ETHRate", "LTCRate"} {
+		if !strings.Contains(logs.String(), `"key":"`+key+`","reason":"bad_rate"`) {
+			t.Errorf("skipped %v wasn't logged: %s", key, logs.String())
+		}
+	}
+}
+
+func TestNewConfigAdapterInvalid(t *testing.T) {
+	for name, config := range map[string]AdapterConfig{
+		"no url":       {ShortName: "X", Key: "{fiat}_{crypto}"},
+		"no crypto":    {ShortName: "X", URL: "http://x", Key: "{fiat}"},
+		"no fiat":      {ShortName: "X", URL: "http://x", Key: "{crypto}Rate"},
+		"no key":       {ShortName: "X", URL: "http://x", Fiat: "AUD"},
+		"both shapes":  {ShortName: "X", URL: "http://x", Key: "{crypto}", CryptoPath: "coin", Fiat: "AUD"},
+		"unknown unit": {ShortName: "X", URL: "http://x", Key: "{fiat}_{crypto}", Unit: "coins"},
+	} {
+		if _, err := NewConfigAdapter(config); err == nil {
+			t.Errorf("%v: expected an error", name)
+		}
+	}
+}
+
+func TestLoadAdapters(t *testing.T) {
+	dir := t.TempDir()
+	config := `
+- short_name: NEW
+  url: https://example.com/rates
+  key: "{crypto}Rate"
+  fiat: AUD
+- short_name: B2B
+  name: Bit2Bill via config
+  url: https://www.bit2bill.com.au/api/rate
+  key: "{crypto}Rate"
+  fiat: AUD
+  aliases: [b2]
+`
+	err := ioutil.WriteFile(filepath.Join(dir, "adapters.yaml"), []byte(config), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cb := testCryptoBill(t, WithConfigDir(dir))
+	err = cb.LoadAdapters()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// B2B is replaced where it was, and NEW comes last.
+	got := shortNames(cb.Services())
+	want := []string{"LROS", "PBC", "B2B", "NEW"}
+	if len(got) != len(want) {
+		t.Fatalf("services = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("services = %v, want %v", got, want)
+		}
+	}
+
+	s, err := cb.Service("b2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Name() != "Bit2Bill via config" {
+		t.Errorf("b2 is %v, want the configured adapter", s.Name())
+	}
+}
@@ -0,0 +1,172 @@
+package cryptobill
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The agent is a small process which keeps the vault key in memory for a limited time, so the
+// passphrase isn't needed for every command. It listens on a unix socket in a directory only the
+// current user can access, and answers "key" with the hex encoded key and "lock" by exiting.
+
+// AgentSocket returns the socket an agent for this vault listens on.
+func (cb *CryptoBill) AgentSocket() (string, error) {
+	path, err := cb.vaultPath()
+	if err != nil {
+		return "", err
+	}
+
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return "", errors.Wrap(err, "abs vault path")
+	}
+
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), fmt.Sprintf("cryptobill-%v", os.Getuid()))
+	} else {
+		dir = filepath.Join(dir, "cryptobill")
+	}
+
+	// Each vault gets its own agent. The name is kept short because socket paths are limited.
+	sum := sha256.Sum256([]byte(path))
+	return filepath.Join(dir, fmt.Sprintf("agent-%x.sock", sum[:6])), nil
+}
+
+// ServeAgent answers key requests on socket until timeout passes or it is asked to lock.
+func ServeAgent(socket string, key []byte, timeout time.Duration) error {
+	dir := filepath.Dir(socket)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return errors.Wrap(err, "mkdir "+dir)
+	}
+
+	// Fails if somebody else owns the directory.
+	err = os.Chmod(dir, 0700)
+	if err != nil {
+		return errors.Wrap(err, "chmod "+dir)
+	}
+
+	if _, err := agentKey(socket); err == nil {
+		return errors.New("an agent is already running")
+	}
+	os.Remove(socket)
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return errors.Wrap(err, "listen")
+	}
+	defer os.Remove(socket)
+
+	timer := time.AfterFunc(timeout, func() {
+		listener.Close()
+	})
+	defer timer.Stop()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			break
+		}
+
+		if !serveAgentConn(conn, key) {
+			listener.Close()
+		}
+	}
+
+	for i := range key {
+		key[i] = 0
+	}
+
+	return nil
+}
+
+// serveAgentConn returns false when the agent should stop.
+func serveAgentConn(conn net.Conn, key []byte) bool {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return true
+	}
+
+	switch strings.TrimSpace(line) {
+	case "key":
+		fmt.Fprintln(conn, hex.EncodeToString(key))
+	case "lock":
+		fmt.Fprintln(conn, "ok")
+		return false
+	}
+
+	return true
+}
+
+func agentRequest(socket, request string) (string, error) {
+	conn, err := net.DialTimeout("unix", socket, time.Second)
+	if err != nil {
+		return "", errors.Wrap(err, "dial agent")
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = fmt.Fprintln(conn, request)
+	if err != nil {
+		return "", errors.Wrap(err, "write to agent")
+	}
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", errors.Wrap(err, "read from agent")
+	}
+
+	return strings.TrimSpace(line), nil
+}
+
+func agentKey(socket string) ([]byte, error) {
+	resp, err := agentRequest(socket, "key")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := hex.DecodeString(resp)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode key from agent")
+	}
+
+	return key, nil
+}
+
+// AgentRunning reports whether an agent is holding the key for this vault.
+func (cb *CryptoBill) AgentRunning() bool {
+	socket, err := cb.AgentSocket()
+	if err != nil {
+		return false
+	}
+
+	_, err = agentKey(socket)
+	return err == nil
+}
+
+// LockVault stops the agent, if there is one, so the passphrase is needed again.
+func (cb *CryptoBill) LockVault() error {
+	socket, err := cb.AgentSocket()
+	if err != nil {
+		return err
+	}
+
+	if !cb.AgentRunning() {
+		return nil
+	}
+
+	_, err = agentRequest(socket, "lock")
+	return err
+}
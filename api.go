@@ -0,0 +1,433 @@
+package cryptobill
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// OpenAPI describes the API served by APIServer.
+//
+//go:embed openapi.json
+var OpenAPI []byte
+
+// APIServer is an http.Handler serving a JSON API over CryptoBill under /api/v1.
+type APIServer struct {
+	cb *CryptoBill
+
+	// Clients send this as "Authorization: Bearer <token>".
+	Token string
+
+	mux *http.ServeMux
+}
+
+func NewAPIServer(cb *CryptoBill, token string) *APIServer {
+	s := &APIServer{cb: cb, Token: token, mux: http.NewServeMux()}
+
+	s.mux.HandleFunc("GET /api/v1/openapi.json", s.openAPI)
+	s.handle("GET /api/v1/quotes", s.quotes)
+	s.handle("GET /api/v1/quotes/stream", s.quoteStream)
+	s.handle("GET /api/v1/bills", s.listBills)
+	s.handle("POST /api/v1/bills", s.createBill)
+	s.handle("GET /api/v1/bills/{name}", s.getBill)
+	s.handle("PUT /api/v1/bills/{name}", s.updateBill)
+	s.handle("DELETE /api/v1/bills/{name}", s.deleteBill)
+	s.handle("GET /api/v1/payments", s.listPayments)
+	s.handle("POST /api/v1/payments", s.createPayment)
+	s.handle("GET /api/v1/payments/{id}", s.getPayment)
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "no such endpoint: "+r.Method+" "+r.URL.Path)
+	})
+
+	return s
+}
+
+func (s *APIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Handle adds an endpoint behind the token check.
+func (s *APIServer) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, s.authorized(handler))
+}
+
+// handle adds an endpoint whose errors are written as JSON.
+func (s *APIServer) handle(pattern string, fn func(w http.ResponseWriter, r *http.Request) error) {
+	s.Handle(pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := fn(w, r)
+		if err != nil {
+			writeError(w, err)
+		}
+	}))
+}
+
+func (s *APIServer) authorized(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if s.Token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(s.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cryptobill"`)
+			writeAPIError(w, http.StatusUnauthorized, "unauthorized", "missing or wrong token")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// APIError is the body of every error response.
+type APIError struct {
+	Error APIErrorBody `json:"error"`
+
+	// A payment the service made an order for, when recording it failed.
+	Payment *Payment `json:"payment,omitempty"`
+}
+
+type APIErrorBody struct {
+	Code    string   `json:"code"`
+	Message string   `json:"message"`
+	Details []string `json:"details,omitempty"`
+
+	// For a service's error, which service and what it didn't like.
+	Service string `json:"service,omitempty"`
+	Field   string `json:"field,omitempty"`
+}
+
+// apiStatus is an error with a particular HTTP status and error code.
+type apiStatus struct {
+	status int
+	code   string
+	err    error
+}
+
+func (e *apiStatus) Error() string {
+	return e.err.Error()
+}
+
+func badRequest(err error) error {
+	return &apiStatus{http.StatusBadRequest, "bad_request", err}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	body := APIErrorBody{Code: "internal", Message: err.Error()}
+	status := http.StatusInternalServerError
+
+	if s, ok := err.(*apiStatus); ok {
+		status, body.Code = s.status, s.code
+		err = s.err
+	}
+
+	switch errors.Cause(err) {
+	case ErrNoSuchBill, ErrNoSuchPayment:
+		status, body.Code = http.StatusNotFound, "not_found"
+	case ErrBillExists:
+		status, body.Code = http.StatusConflict, "conflict"
+	case ErrVaultLocked:
+		status, body.Code = http.StatusLocked, "vault_locked"
+	}
+
+	if perr, ok := errors.Cause(err).(*PolicyError); ok {
+		status, body.Code = http.StatusForbidden, "policy"
+		body.Message = "against policy"
+		body.Details = perr.Violations
+	}
+
+	if perr, ok := AsProviderError(err); ok {
+		status, body.Code = http.StatusUnprocessableEntity, string(perr.Kind)
+		body.Service, body.Field = perr.Service, perr.Field
+		switch perr.Kind {
+		case Unavailable:
+			status = http.StatusBadGateway
+		case RateExpired:
+			status = http.StatusConflict
+		}
+	}
+
+	if merr, ok := errors.Cause(err).(*multierror.Error); ok {
+		for _, e := range merr.Errors {
+			body.Details = append(body.Details, e.Error())
+		}
+		body.Message = strings.SplitN(body.Message, ":", 2)[0]
+	}
+
+	writeJSON(w, status, &APIError{Error: body})
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, &APIError{Error: APIErrorBody{Code: code, Message: message}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func readJSON(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(v)
+	if err != nil {
+		return badRequest(errors.Wrap(err, "decode json"))
+	}
+	return nil
+}
+
+func (s *APIServer) openAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(OpenAPI)
+}
+
+// QuotesResponse is the body of GET /quotes. Services that failed are listed in Errors.
+type QuotesResponse struct {
+	Quotes []QuoteRecord `json:"quotes"`
+	Errors []string      `json:"errors,omitempty"`
+}
+
+func (s *APIServer) quotes(w http.ResponseWriter, r *http.Request) error {
+	info, err := fiatInfoFromQuery(r)
+	if err != nil {
+		return badRequest(err)
+	}
+
+	results, quoteErr := s.cb.Quote(info)
+	resp := QuotesResponse{Quotes: []QuoteRecord{}}
+	if merr, ok := quoteErr.(*multierror.Error); ok {
+		for _, e := range merr.Errors {
+			resp.Errors = append(resp.Errors, e.Error())
+		}
+	}
+
+	crypto := r.URL.Query().Get("crypto")
+	service := r.URL.Query().Get("service")
+	for _, q := range results {
+		if crypto != "" && !strings.EqualFold(crypto, string(q.Pair.Crypto)) {
+			continue
+		}
+		if service != "" && !strings.EqualFold(service, q.Service.ShortName()) {
+			continue
+		}
+
+		resp.Quotes = append(resp.Quotes, quoteRecord(q))
+	}
+
+	status := http.StatusOK
+	if len(results) == 0 && quoteErr != nil {
+		status = http.StatusBadGateway
+	}
+	writeJSON(w, status, &resp)
+	return nil
+}
+
+func fiatInfoFromQuery(r *http.Request) (*FiatInfo, error) {
+	query := r.URL.Query()
+
+	amount, err := strconv.ParseFloat(query.Get("amount"), 64)
+	if err != nil || amount <= 0 {
+		return nil, errors.New("amount should be a positive number")
+	}
+
+	fiat := query.Get("fiat")
+	if fiat == "" {
+		fiat = "AUD"
+	}
+	currency, err := NewCurrencyFromString(fiat)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FiatInfo{Amount: Amount(amount), Fiat: currency}, nil
+}
+
+func quoteRecord(q QuoteResult) QuoteRecord {
+	return QuoteRecord{
+		Service:      q.Service.ShortName(),
+		Crypto:       q.Pair.Crypto,
+		Fiat:         q.Pair.Fiat,
+		FiatAmount:   q.Conversion.Fiat,
+		CryptoAmount: q.Conversion.Crypto,
+	}
+}
+
+func (s *APIServer) listBills(w http.ResponseWriter, r *http.Request) error {
+	bills, err := s.cb.LoadBills()
+	if err != nil {
+		return errors.Wrap(err, "load bills")
+	}
+
+	list := []*Bill{}
+	for _, name := range bills.Names() {
+		if bills[name] != nil {
+			list = append(list, bills[name])
+		}
+	}
+
+	writeJSON(w, http.StatusOK, list)
+	return nil
+}
+
+func (s *APIServer) getBill(w http.ResponseWriter, r *http.Request) error {
+	bill, err := s.cb.GetBill(r.PathValue("name"))
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, bill)
+	return nil
+}
+
+func (s *APIServer) createBill(w http.ResponseWriter, r *http.Request) error {
+	bill := &Bill{}
+	err := readJSON(r, bill)
+	if err != nil {
+		return err
+	}
+
+	err = bill.Validate(false)
+	if err != nil {
+		return badRequest(errors.Wrap(err, "invalid bill"))
+	}
+
+	err = s.cb.AddBill(bill, false)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusCreated, bill)
+	return nil
+}
+
+// updateBill replaces a bill. Renaming isn't supported, so the name in the body has to match.
+func (s *APIServer) updateBill(w http.ResponseWriter, r *http.Request) error {
+	name := r.PathValue("name")
+	bill := &Bill{}
+	err := readJSON(r, bill)
+	if err != nil {
+		return err
+	}
+
+	if bill.Name == "" {
+		bill.Name = name
+	}
+	if bill.Name != name {
+		return badRequest(errors.New("name in the body doesn't match the path"))
+	}
+
+	err = bill.Validate(false)
+	if err != nil {
+		return badRequest(errors.Wrap(err, "invalid bill"))
+	}
+
+	err = s.cb.EditBill(name, func(existing *Bill) error {
+		*existing = *bill
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, bill)
+	return nil
+}
+
+func (s *APIServer) deleteBill(w http.ResponseWriter, r *http.Request) error {
+	err := s.cb.RemoveBill(r.PathValue("name"))
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// PaymentRequest is the body of POST /payments.
+type PaymentRequest struct {
+	Bill    string   `json:"bill"`
+	Amount  Amount   `json:"amount"`
+	Fiat    Currency `json:"fiat"`
+	Crypto  Currency `json:"crypto"`
+	Service string   `json:"service"`
+
+	// Taken from the vault if not given.
+	Auth string `json:"auth,omitempty"`
+}
+
+func (s *APIServer) createPayment(w http.ResponseWriter, r *http.Request) error {
+	req := &PaymentRequest{}
+	err := readJSON(r, req)
+	if err != nil {
+		return err
+	}
+
+	if req.Bill == "" || req.Amount <= 0 || req.Crypto == "" || req.Service == "" {
+		return badRequest(errors.New("bill, amount, crypto and service are required"))
+	}
+	if req.Fiat == "" {
+		req.Fiat = "AUD"
+	}
+
+	info := &PayInfoService{
+		PayInfo: PayInfo{FiatInfo: FiatInfo{Amount: req.Amount, Fiat: req.Fiat}, Crypto: req.Crypto},
+		Service: req.Service,
+		Auth:    req.Auth,
+	}
+
+	payment, err := s.cb.Pay(req.Bill, info)
+	if payment != nil {
+		// Failing to notify is no reason to hide the payment from the client.
+		s.cb.Notify(NewPaymentEvent(payment))
+	}
+	if err != nil && payment == nil {
+		return err
+	}
+	if err != nil && payment.Status != PaymentFailed {
+		// The order was made, so the client still needs to know where to send the crypto.
+		writeJSON(w, http.StatusInternalServerError, &APIError{
+			Error:   APIErrorBody{Code: "internal", Message: err.Error()},
+			Payment: payment,
+		})
+		return nil
+	}
+	switch payment.Status {
+	case PaymentFailed:
+		writeJSON(w, http.StatusBadGateway, payment)
+		return nil
+	case PaymentHeld:
+		writeJSON(w, http.StatusAccepted, payment)
+		return nil
+	}
+
+	writeJSON(w, http.StatusCreated, payment)
+	return nil
+}
+
+func (s *APIServer) listPayments(w http.ResponseWriter, r *http.Request) error {
+	payments, err := s.cb.Payments()
+	if err != nil {
+		return err
+	}
+	if payments == nil {
+		payments = []*Payment{}
+	}
+
+	writeJSON(w, http.StatusOK, payments)
+	return nil
+}
+
+func (s *APIServer) getPayment(w http.ResponseWriter, r *http.Request) error {
+	payment, err := s.cb.TrackPayment(r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, payment)
+	return nil
+}
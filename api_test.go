@@ -0,0 +1,222 @@
+package cryptobill
+
+import (
+	"encoding/json"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testToken = "test-token"
+
+func apiServer(t *testing.T, opts ...Option) *APIServer {
+	t.Helper()
+
+	opts = append([]Option{
+		WithServices(fakeServices(t, "FAKE")...),
+		WithOracle(&FixedOracle{Fiat: "AUD", Prices: map[Currency]Amount{"BTC": 10000}}),
+		WithConfigDir(t.TempDir()),
+	}, opts...)
+	return NewAPIServer(testCryptoBill(t, opts...), testToken)
+}
+
+// apiRequest sends body as JSON, if it isn't empty, with the given token.
+func apiRequest(t *testing.T, s *APIServer, method, path, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, "/api/v1"+path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+// assertAPIError checks the status and that the body is an APIError with the code.
+func assertAPIError(t *testing.T, w *httptest.ResponseRecorder, status int, code string) *APIError {
+	t.Helper()
+
+	if w.Code != status {
+		t.Errorf("status = %v, want %v: %s", w.Code, status, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("content type = %q", ct)
+	}
+
+	apiErr := &APIError{}
+	err := json.Unmarshal(w.Body.Bytes(), apiErr)
+	if err != nil {
+		t.Fatalf("%v: %s", err, w.Body)
+	}
+	if apiErr.Error.Code != code || apiErr.Error.Message == "" {
+		t.Errorf("got error %+v, want code %v", apiErr.Error, code)
+	}
+	return apiErr
+}
+
+func TestAPIAuth(t *testing.T) {
+	s := apiServer(t)
+
+	w := apiRequest(t, s, "GET", "/bills", "", "")
+	assertAPIError(t, w, http.StatusUnauthorized, "unauthorized")
+	if w.Header().Get("WWW-Authenticate") == "" {
+		t.Error("missing WWW-Authenticate")
+	}
+
+	assertAPIError(t, apiRequest(t, s, "GET", "/bills", "wrong", ""), http.StatusUnauthorized, "unauthorized")
+
+	if w := apiRequest(t, s, "GET", "/bills", testToken, ""); w.Code != http.StatusOK {
+		t.Errorf("status = %v with the right token: %s", w.Code, w.Body)
+	}
+
+	// The description of the API is public.
+	if w := apiRequest(t, s, "GET", "/openapi.json", "", ""); w.Code != http.StatusOK {
+		t.Errorf("openapi.json status = %v", w.Code)
+	}
+
+	// Without a token set, nothing gets in.
+	s.Token = ""
+	assertAPIError(t, apiRequest(t, s, "GET", "/bills", "", ""), http.StatusUnauthorized, "unauthorized")
+}
+
+func TestAPIBills(t *testing.T) {
+	s := apiServer(t)
+	rent := `{"Name": "rent", "BPAY": {"Code": 23796, "Account": "1234567897"}}`
+
+	w := apiRequest(t, s, "POST", "/bills", testToken, rent)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create status = %v: %s", w.Code, w.Body)
+	}
+	assertAPIError(t, apiRequest(t, s, "POST", "/bills", testToken, rent), http.StatusConflict, "conflict")
+
+	// Every problem with the bill is listed.
+	apiErr := assertAPIError(t, apiRequest(t, s, "POST", "/bills", testToken, `{"Name": "bad", "BPAY": {"Code": 1, "Account": "x"}}`), http.StatusBadRequest, "bad_request")
+	if len(apiErr.Error.Details) != 2 {
+		t.Errorf("got details %v, want the code and reference", apiErr.Error.Details)
+	}
+	assertAPIError(t, apiRequest(t, s, "POST", "/bills", testToken, `{"Nmae": "typo"}`), http.StatusBadRequest, "bad_request")
+
+	w = apiRequest(t, s, "GET", "/bills/rent", testToken, "")
+	bill := &Bill{}
+	if err := json.Unmarshal(w.Body.Bytes(), bill); err != nil || w.Code != http.StatusOK || bill.BPAY.Code != 23796 {
+		t.Errorf("get: %v %s %v", w.Code, w.Body, err)
+	}
+
+	assertAPIError(t, apiRequest(t, s, "PUT", "/bills/rent", testToken, `{"Name": "other", "BPAY": {"Code": 23796, "Account": "1234567897"}}`), http.StatusBadRequest, "bad_request")
+	w = apiRequest(t, s, "PUT", "/bills/rent", testToken, `{"EFT": {"BSB": "062-000", "AccountNumber": "12345678", "AccountName": "J Smith"}}`)
+	if w.Code != http.StatusOK {
+		t.Errorf("update status = %v: %s", w.Code, w.Body)
+	}
+	w = apiRequest(t, s, "GET", "/bills", testToken, "")
+	var bills []*Bill
+	if err := json.Unmarshal(w.Body.Bytes(), &bills); err != nil || len(bills) != 1 || bills[0].Type() != "EFT" {
+		t.Errorf("list after update: %s %v", w.Body, err)
+	}
+
+	if w := apiRequest(t, s, "DELETE", "/bills/rent", testToken, ""); w.Code != http.StatusNoContent {
+		t.Errorf("delete status = %v: %s", w.Code, w.Body)
+	}
+	assertAPIError(t, apiRequest(t, s, "GET", "/bills/rent", testToken, ""), http.StatusNotFound, "not_found")
+	assertAPIError(t, apiRequest(t, s, "DELETE", "/bills/rent", testToken, ""), http.StatusNotFound, "not_found")
+	assertAPIError(t, apiRequest(t, s, "GET", "/nope", testToken, ""), http.StatusNotFound, "not_found")
+}
+
+func TestAPIPayments(t *testing.T) {
+	s := apiServer(t)
+	apiRequest(t, s, "POST", "/bills", testToken, `{"Name": "rent", "BPAY": {"Code": 23796, "Account": "1234567897"}}`)
+
+	assertAPIError(t, apiRequest(t, s, "POST", "/payments", testToken, `{"bill": "rent"}`), http.StatusBadRequest, "bad_request")
+	assertAPIError(t, apiRequest(t, s, "POST", "/payments", testToken, `{"bill": "nope", "amount": 100, "crypto": "BTC", "service": "FAKE", "auth": "me@example.com"}`), http.StatusNotFound, "not_found")
+
+	w := apiRequest(t, s, "POST", "/payments", testToken, `{"bill": "rent", "amount": 100, "crypto": "BTC", "service": "FAKE", "auth": "me@example.com"}`)
+	payment := &Payment{}
+	if err := json.Unmarshal(w.Body.Bytes(), payment); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("pay: %v %s %v", w.Code, w.Body, err)
+	}
+	if payment.Status != PaymentPrepared || payment.Address == "" {
+		t.Errorf("got payment %+v", payment)
+	}
+
+	w = apiRequest(t, s, "GET", "/payments/"+payment.ID, testToken, "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), payment.Address) {
+		t.Errorf("get payment: %v %s", w.Code, w.Body)
+	}
+	assertAPIError(t, apiRequest(t, s, "GET", "/payments/nope", testToken, ""), http.StatusNotFound, "not_found")
+}
+
+// failingStore fails every save after the first few.
+type failingStore struct {
+	MemoryStore
+	saves int
+}
+
+func (s *failingStore) WithPayments(save bool, fn func([]*Payment) ([]*Payment, error)) error {
+	if save {
+		s.saves--
+		if s.saves < 0 {
+			return errors.New("disk full")
+		}
+	}
+	return s.MemoryStore.WithPayments(save, fn)
+}
+
+func TestAPIPaymentNotSaved(t *testing.T) {
+	// The payment is recorded as submitting, then fails to be saved once the order is made.
+	s := apiServer(t, WithStore(&failingStore{saves: 1}))
+	apiRequest(t, s, "POST", "/bills", testToken, `{"Name": "rent", "BPAY": {"Code": 23796, "Account": "1234567897"}}`)
+
+	w := apiRequest(t, s, "POST", "/payments", testToken, `{"bill": "rent", "amount": 100, "crypto": "BTC", "service": "FAKE", "auth": "me@example.com"}`)
+	apiErr := assertAPIError(t, w, http.StatusInternalServerError, "internal")
+	if !strings.Contains(apiErr.Error.Message, "disk full") {
+		t.Errorf("message = %q, want the save error", apiErr.Error.Message)
+	}
+	if apiErr.Payment == nil || apiErr.Payment.Address == "" {
+		t.Errorf("got payment %+v, want the order's address", apiErr.Payment)
+	}
+}
+
+func TestAPIErrorStatus(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{errors.New("boom"), http.StatusInternalServerError, "internal"},
+		{badRequest(errors.New("bad")), http.StatusBadRequest, "bad_request"},
+		{errors.Wrap(ErrNoSuchPayment, "x"), http.StatusNotFound, "not_found"},
+		{errors.Wrap(ErrBillExists, "x"), http.StatusConflict, "conflict"},
+		{errors.Wrap(ErrVaultLocked, "load"), http.StatusLocked, "vault_locked"},
+		{&PolicyError{Violations: []string{"too much"}}, http.StatusForbidden, "policy"},
+		{newProviderError(Unavailable, "PBC", "", "down"), http.StatusBadGateway, "unavailable"},
+		{newProviderError(RateExpired, "PBC", "", "expired"), http.StatusConflict, "rate_expired"},
+		{newProviderError(RejectedInput, "PBC", "code", "bad biller"), http.StatusUnprocessableEntity, "rejected_input"},
+	} {
+		w := httptest.NewRecorder()
+		writeError(w, tc.err)
+		assertAPIError(t, w, tc.status, tc.code)
+	}
+
+	w := httptest.NewRecorder()
+	writeError(w, newProviderError(RejectedInput, "PBC", "code", "bad biller"))
+	if body := assertAPIError(t, w, http.StatusUnprocessableEntity, "rejected_input"); body.Error.Service != "PBC" || body.Error.Field != "code" {
+		t.Errorf("got %+v, want the service and field", body.Error)
+	}
+
+	w = httptest.NewRecorder()
+	writeError(w, &PolicyError{Violations: []string{"over the daily limit", "not on a weekend"}})
+	if body := assertAPIError(t, w, http.StatusForbidden, "policy"); len(body.Error.Details) != 2 {
+		t.Errorf("got details %v, want each violation", body.Error.Details)
+	}
+
+	var merr error
+	merr = multierror.Append(merr, errors.New("one"), errors.New("two"))
+	w = httptest.NewRecorder()
+	writeError(w, errors.Wrap(merr, "import"))
+	if body := assertAPIError(t, w, http.StatusInternalServerError, "internal"); body.Error.Message != "import" || len(body.Error.Details) != 2 {
+		t.Errorf("got %+v, want the message and each error", body.Error)
+	}
+}
@@ -3,9 +3,10 @@ package cryptobill
 import (
 	"encoding/json"
 	"fmt"
-	"github.com/alecthomas/repr"
 	"github.com/pkg/errors"
+	"io/ioutil"
 	"os"
+	"sort"
 	"text/tabwriter"
 )
 
@@ -13,81 +14,254 @@ type Bill struct {
 	Name string `arg`
 	BPAY BPAY   `cmd`
 	EFT  EFT    `cmd`
+
+	Schedule *Schedule `json:",omitempty"`
 }
 
 type Bills map[string]*Bill
 
-var billPath = "bills.json"
-
+// LoadBills reads the bills, from the vault if there is one. A missing bills file is treated as
+// having no bills.
 func (cb *CryptoBill) LoadBills() (Bills, error) {
-	if _, err := os.Stat(billPath); os.IsNotExist(err) {
-		err = cb.SaveBills(Bills{})
-		if err != nil {
-			return nil, errors.Wrap(err, "save bills")
-		}
+	var entries Bills
+	err := cb.withBills(false, func(loaded Bills) (Bills, error) {
+		entries = loaded
+		return loaded, nil
+	})
+
+	return entries, err
+}
+
+// SaveBills replaces the bills with entries, keeping a backup of the previous version.
+func (cb *CryptoBill) SaveBills(entries Bills) error {
+	return cb.withBills(true, func(Bills) (Bills, error) {
+		return entries, nil
+	})
+}
+
+// updateBills loads the bills, lets fn modify them and saves the result, holding the lock
+// throughout so that parallel runs can't lose each other's changes.
+func (cb *CryptoBill) updateBills(fn func(Bills) error) error {
+	return cb.withBills(true, func(entries Bills) (Bills, error) {
+		return entries, fn(entries)
+	})
+}
+
+// withBills loads the bills under lock and passes them to fn. If save is set, the bills fn returns
+// are written back before the lock is released. They are also written back if loading them ran a
+// migration, so that it only happens once.
+func (cb *CryptoBill) withBills(save bool, fn func(Bills) (Bills, error)) error {
+	if cb.Store != nil {
+		return cb.Store.WithBills(save, fn)
+	}
+
+	vault, err := cb.HasVault()
+	if err != nil {
+		return err
+	}
+
+	if vault {
+		return cb.withVault(save, func(data *VaultData) error {
+			data.Bills, err = fn(data.Bills)
+			return err
+		})
+	}
+
+	path, err := cb.billsPath()
+	if err != nil {
+		return errors.Wrap(err, "bills path")
 	}
 
-	fp, err := os.Open(billPath)
+	unlock, err := lockPath(path)
 	if err != nil {
-		return nil, errors.Wrap(err, "open bills.json")
+		return errors.Wrap(err, "lock bills")
 	}
+	defer unlock()
 
-	entries := Bills{}
-	err = json.NewDecoder(fp).Decode(&entries)
+	entries, migrated, err := loadBills(path)
 	if err != nil {
-		return nil, errors.Wrap(err, "decode json from bills.json")
+		return errors.Wrap(err, "load bills")
+	}
+
+	entries, err = fn(entries)
+	if err != nil || !save && !migrated {
+		return err
 	}
 
-	return entries, nil
+	// The backup taken when saving keeps the file as it was before the migration.
+	return saveBills(path, entries)
 }
 
-func (cb *CryptoBill) SaveBills(entries Bills) error {
-	fp, err := os.Create(billPath)
+// loadBills reads the bills file, upgrading it in memory if it was written by an older version.
+// The returned bool is set if that happened, in which case it should be saved.
+func loadBills(path string) (Bills, bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Bills{}, false, nil
+	}
 	if err != nil {
-		return errors.Wrap(err, "can't create bills.json")
+		return nil, false, errors.Wrap(err, "read "+path)
 	}
 
-	encoder := json.NewEncoder(fp)
-	encoder.SetIndent("", "  ")
-	err = encoder.Encode(entries)
+	doc, version, err := migrateBills(data)
 	if err != nil {
-		return errors.Wrap(err, "can't encode bills.json")
+		return nil, false, errors.Wrap(err, path)
 	}
 
-	return nil
+	return doc.Bills, version != BillsVersion, nil
 }
 
-func (cb *CryptoBill) AddBill(entry *Bill) error {
-	entries, err := cb.LoadBills()
+func saveBills(path string, entries Bills) error {
+	data, err := json.MarshalIndent(billsDocument{Version: BillsVersion, Bills: entries}, "", "  ")
 	if err != nil {
-		return errors.Wrap(err, "load bills")
+		return errors.Wrap(err, "can't encode bills")
 	}
 
-	entries[entry.Name] = entry
+	err = backupFile(path)
+	if err != nil {
+		return errors.Wrap(err, "backup bills")
+	}
 
-	err = cb.SaveBills(entries)
+	err = writeFileAtomic(path, append(data, '\n'), 0600)
 	if err != nil {
-		return errors.Wrap(err, "save bills")
+		return errors.Wrap(err, "can't write "+path)
 	}
 
 	return nil
 }
 
+// ErrBillExists is returned when adding or renaming a bill would replace another one.
+var ErrBillExists = errors.New("bill already exists")
+
+// ErrNoSuchBill is returned when a bill name isn't known.
+var ErrNoSuchBill = errors.New("no such bill")
+
+// Type returns "BPAY" or "EFT", or an empty string if neither is filled in.
+func (b *Bill) Type() string {
+	if b.BPAY != (BPAY{}) {
+		return "BPAY"
+	}
+	if b.EFT != (EFT{}) {
+		return "EFT"
+	}
+	return ""
+}
+
+// Summary describes where the bill is paid to on a single line.
+func (b *Bill) Summary() string {
+	switch b.Type() {
+	case "BPAY":
+		return fmt.Sprintf("biller %v, ref %v", b.BPAY.Code, b.BPAY.Account)
+	case "EFT":
+		summary := fmt.Sprintf("BSB %v, account %v (%v)", b.EFT.BSB, b.EFT.AccountNumber, b.EFT.AccountName)
+		if b.EFT.Remitter != "" {
+			summary += ", remitter " + b.EFT.Remitter
+		}
+		return summary
+	}
+	return ""
+}
+
+// AddBill stores a new bill. Unless overwrite is set, ErrBillExists is returned if there is already
+// a bill with the same name.
+func (cb *CryptoBill) AddBill(entry *Bill, overwrite bool) error {
+	if entry.Name == "" {
+		return errors.New("bill needs a name")
+	}
+
+	return cb.updateBills(func(entries Bills) error {
+		if _, exists := entries[entry.Name]; exists && !overwrite {
+			return errors.Wrap(ErrBillExists, entry.Name)
+		}
+
+		entries[entry.Name] = entry
+		return nil
+	})
+}
+
+// EditBill lets fn change the named bill in place. The name can't be changed this way, use
+// RenameBill instead.
+func (cb *CryptoBill) EditBill(name string, fn func(*Bill) error) error {
+	return cb.updateBills(func(entries Bills) error {
+		bill, ok := entries[name]
+		if !ok {
+			return errors.Wrap(ErrNoSuchBill, name)
+		}
+
+		err := fn(bill)
+		if err != nil {
+			return err
+		}
+
+		bill.Name = name
+		return nil
+	})
+}
+
+func (cb *CryptoBill) RenameBill(name, newName string) error {
+	if newName == "" {
+		return errors.New("bill needs a name")
+	}
+
+	return cb.updateBills(func(entries Bills) error {
+		bill, ok := entries[name]
+		if !ok {
+			return errors.Wrap(ErrNoSuchBill, name)
+		}
+
+		if _, exists := entries[newName]; exists {
+			return errors.Wrap(ErrBillExists, newName)
+		}
+
+		delete(entries, name)
+		bill.Name = newName
+		entries[newName] = bill
+		return nil
+	})
+}
+
+func (cb *CryptoBill) RemoveBill(name string) error {
+	return cb.updateBills(func(entries Bills) error {
+		if _, ok := entries[name]; !ok {
+			return errors.Wrap(ErrNoSuchBill, name)
+		}
+
+		delete(entries, name)
+		return nil
+	})
+}
+
+// Names returns the bill names in alphabetical order.
+func (bills Bills) Names() []string {
+	var names []string
+	for name := range bills {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (cb *CryptoBill) ListBills() error {
 	bills, err := cb.LoadBills()
 	if err != nil {
 		return errors.Wrap(err, "load bill")
 	}
 
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.AlignRight|tabwriter.Debug)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	_, err = fmt.Fprintf(w, "NAME\tTYPE\tDETAILS\n")
+	if err != nil {
+		return errors.Wrap(err, "fprint")
+	}
+
+	for _, name := range bills.Names() {
+		bill := bills[name]
+		if bill == nil {
+			// Reported by "bills doctor".
+			continue
+		}
 
-	for _, bill := range bills {
-		_, err = fmt.Fprintf(
-			w, "%v\t%v\t%v\t\n",
-			bill.Name,
-			repr.String(bill.EFT),
-			repr.String(bill.BPAY),
-		)
+		_, err = fmt.Fprintf(w, "%v\t%v\t%v\n", bill.Name, bill.Type(), bill.Summary())
 		if err != nil {
 			return errors.Wrap(err, "fprint")
 		}
@@ -111,6 +285,6 @@ func (cb *CryptoBill) GetBill(name string) (*Bill, error) {
 	if ok {
 		return bill, nil
 	} else {
-		return nil, errors.New("no such bill")
+		return nil, errors.Wrap(ErrNoSuchBill, name)
 	}
 }
@@ -0,0 +1,349 @@
+package cryptobill
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Bills are imported and exported as flat records with these fields, as CSV columns or as keys of
+// JSON and YAML objects. Names looked up from the services, like the biller name, aren't included.
+var BillColumns = []string{"name", "type", "code", "account", "bsb", "account_number", "account_name", "remitter"}
+
+var BillFormats = []string{"csv", "json", "yaml"}
+
+// FormatFromPath guesses the file format from its extension.
+func FormatFromPath(path string) (string, error) {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	switch ext {
+	case "csv", "json", "yaml":
+		return ext, nil
+	case "yml":
+		return "yaml", nil
+	}
+
+	return "", errors.New("can't tell the format of " + path + ", use one of " + strings.Join(BillFormats, ", "))
+}
+
+type ImportOptions struct {
+	Format string
+
+	// Renames columns in the file to BillColumns, e.g. "Biller Code" to "code". Columns which
+	// aren't in BillColumns after renaming are ignored.
+	Columns map[string]string
+
+	// Some billers don't use the usual check digit on their references.
+	SkipCRNCheck bool
+}
+
+// ReadBills parses and validates every record, returning all the problems found rather than
+// stopping at the first one.
+func ReadBills(r io.Reader, opts ImportOptions) (Bills, error) {
+	records, err := readRecords(r, opts.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	bills := Bills{}
+	var errs error
+	for i, record := range records {
+		row := fmt.Sprintf("record %v:", i+1)
+
+		bill, err := billFromRecord(mapColumns(record, opts.Columns))
+		if err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, row))
+			continue
+		}
+
+		err = bill.Validate(!opts.SkipCRNCheck)
+		if err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, row+" "+bill.Name+":"))
+			continue
+		}
+
+		if _, exists := bills[bill.Name]; exists {
+			errs = multierror.Append(errs, errors.Errorf("%v %v appears more than once", row, bill.Name))
+			continue
+		}
+
+		bills[bill.Name] = bill
+	}
+
+	if errs != nil {
+		return nil, errs
+	}
+
+	return bills, nil
+}
+
+// WriteBills writes the bills in name order.
+func WriteBills(w io.Writer, bills Bills, format string) error {
+	var records []map[string]string
+	for _, name := range bills.Names() {
+		records = append(records, billRecord(bills[name]))
+	}
+
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		err := cw.Write(BillColumns)
+		if err != nil {
+			return errors.Wrap(err, "write csv")
+		}
+
+		for _, record := range records {
+			var row []string
+			for _, column := range BillColumns {
+				row = append(row, record[column])
+			}
+
+			err = cw.Write(row)
+			if err != nil {
+				return errors.Wrap(err, "write csv")
+			}
+		}
+
+		cw.Flush()
+		return errors.Wrap(cw.Error(), "write csv")
+
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return errors.Wrap(enc.Encode(records), "write json")
+
+	case "yaml":
+		data, err := yaml.Marshal(records)
+		if err != nil {
+			return errors.Wrap(err, "encode yaml")
+		}
+
+		_, err = w.Write(data)
+		return errors.Wrap(err, "write yaml")
+	}
+
+	return errors.New("unknown format: " + format)
+}
+
+func readRecords(r io.Reader, format string) ([]map[string]string, error) {
+	switch format {
+	case "csv":
+		rows, err := csv.NewReader(r).ReadAll()
+		if err != nil {
+			return nil, errors.Wrap(err, "read csv")
+		}
+		if len(rows) == 0 {
+			return nil, nil
+		}
+
+		header := rows[0]
+		var records []map[string]string
+		for _, row := range rows[1:] {
+			record := map[string]string{}
+			for i, value := range row {
+				record[header[i]] = value
+			}
+			records = append(records, record)
+		}
+		return records, nil
+
+	case "json":
+		var raw []map[string]interface{}
+		err := json.NewDecoder(r).Decode(&raw)
+		if err != nil {
+			return nil, errors.Wrap(err, "decode json, expecting a list of objects")
+		}
+
+		var records []map[string]string
+		for _, item := range raw {
+			records = append(records, stringValues(item))
+		}
+		return records, nil
+
+	case "yaml":
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "read yaml")
+		}
+
+		// Decoded as strings, yaml keeps the text as written, so 062000 isn't read as an octal number.
+		var records []map[string]string
+		err = yaml.Unmarshal(data, &records)
+		if err != nil {
+			return nil, errors.Wrap(err, "decode yaml, expecting a list of mappings")
+		}
+		return records, nil
+	}
+
+	return nil, errors.New("unknown format: " + format)
+}
+
+// stringValues flattens decoded JSON values, so that a biller code can be written as a number or a
+// string.
+func stringValues(item map[string]interface{}) map[string]string {
+	record := map[string]string{}
+	for k, v := range item {
+		switch v := v.(type) {
+		case nil:
+		case float64:
+			record[k] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			record[k] = fmt.Sprint(v)
+		}
+	}
+	return record
+}
+
+// mapColumns renames columns and normalises them, so "Account Number" matches "account_number".
+func mapColumns(record, columns map[string]string) map[string]string {
+	mapped := map[string]string{}
+	for k, v := range record {
+		if renamed, ok := columns[k]; ok {
+			k = renamed
+		}
+		k = strings.Replace(strings.ToLower(strings.TrimSpace(k)), " ", "_", -1)
+		mapped[k] = strings.TrimSpace(v)
+	}
+	return mapped
+}
+
+func billRecord(b *Bill) map[string]string {
+	record := map[string]string{
+		"name": b.Name,
+		"type": b.Type(),
+	}
+
+	switch b.Type() {
+	case "BPAY":
+		record["code"] = strconv.Itoa(b.BPAY.Code)
+		record["account"] = b.BPAY.Account
+	case "EFT":
+		record["bsb"] = b.EFT.BSB
+		record["account_number"] = b.EFT.AccountNumber
+		record["account_name"] = b.EFT.AccountName
+		record["remitter"] = b.EFT.Remitter
+	}
+
+	return record
+}
+
+func billFromRecord(record map[string]string) (*Bill, error) {
+	bill := &Bill{Name: record["name"]}
+
+	kind := strings.ToUpper(record["type"])
+	if kind == "" {
+		// Work it out from which fields are filled in.
+		if record["code"] != "" {
+			kind = "BPAY"
+		} else if record["bsb"] != "" {
+			kind = "EFT"
+		}
+	}
+
+	switch kind {
+	case "BPAY":
+		code, err := strconv.Atoi(record["code"])
+		if err != nil {
+			return nil, errors.Errorf("biller code %q isn't a number", record["code"])
+		}
+		bill.BPAY = BPAY{Code: code, Account: record["account"]}
+	case "EFT":
+		bill.EFT = EFT{
+			BSB:           record["bsb"],
+			AccountNumber: record["account_number"],
+			AccountName:   record["account_name"],
+			Remitter:      record["remitter"],
+		}
+	default:
+		return nil, errors.Errorf("unknown type %q, expecting BPAY or EFT", record["type"])
+	}
+
+	return bill, nil
+}
+
+// BillChange describes how importing a bill would change the stored bills. Old is nil when the bill
+// is new.
+type BillChange struct {
+	Name     string
+	Old, New *Bill
+}
+
+// Fields lists the columns that differ as "column: old -> new".
+func (c BillChange) Fields() []string {
+	if c.Old == nil {
+		return nil
+	}
+
+	old := billRecord(c.Old)
+	updated := billRecord(c.New)
+
+	var fields []string
+	for _, column := range BillColumns {
+		if old[column] != updated[column] {
+			fields = append(fields, fmt.Sprintf("%v: %q -> %q", column, old[column], updated[column]))
+		}
+	}
+	return fields
+}
+
+// DiffBills returns the changes importing imported on top of existing would make, in name order.
+// Bills which would stay the same are left out.
+func DiffBills(existing, imported Bills) []BillChange {
+	var changes []BillChange
+	for _, name := range imported.Names() {
+		change := BillChange{Name: name, Old: existing[name], New: imported[name]}
+		if change.Old != nil && len(change.Fields()) == 0 {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// CheckChanges refuses changes to existing bills unless overwrite is set, as ImportBills does.
+func CheckChanges(changes []BillChange, overwrite bool) error {
+	var errs error
+	for _, change := range changes {
+		if change.Old != nil && !overwrite {
+			errs = multierror.Append(errs, errors.Wrap(ErrBillExists, change.Name))
+		}
+	}
+	return errors.Wrap(errs, "use --overwrite to change existing bills")
+}
+
+// ImportBills adds the imported bills. Changing an existing bill is refused unless overwrite is
+// set. Bills which aren't in imported are left alone.
+func (cb *CryptoBill) ImportBills(imported Bills, overwrite bool) ([]BillChange, error) {
+	var changes []BillChange
+	err := cb.updateBills(func(entries Bills) error {
+		changes = DiffBills(entries, imported)
+		err := CheckChanges(changes, overwrite)
+		if err != nil {
+			return err
+		}
+
+		for _, change := range changes {
+			if change.Old != nil {
+				// Keep the names looked up from the services, unless what they describe changed.
+				if change.New.BPAY.Code == change.Old.BPAY.Code {
+					change.New.BPAY.Name = change.Old.BPAY.Name
+				}
+				if change.New.EFT.BSB == change.Old.EFT.BSB {
+					change.New.EFT.BSBName = change.Old.EFT.BSBName
+				}
+			}
+			entries[change.Name] = change.New
+		}
+
+		return nil
+	})
+
+	return changes, err
+}
@@ -0,0 +1,102 @@
+package cryptobill
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadBillsYAMLKeepsLeadingZeros(t *testing.T) {
+	yml := `
+- name: rent
+  bsb: 062000
+  account_number: 00123456
+  account_name: Landlord
+- name: power
+  code: 23796
+  account: 0079927398713
+`
+	bills, err := ReadBills(strings.NewReader(yml), ImportOptions{Format: "yaml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bills["rent"].EFT.BSB != "062000" || bills["rent"].EFT.AccountNumber != "00123456" {
+		t.Errorf("rent = %+v", bills["rent"].EFT)
+	}
+	if bills["power"].BPAY.Code != 23796 || bills["power"].BPAY.Account != "0079927398713" {
+		t.Errorf("power = %+v", bills["power"].BPAY)
+	}
+}
+
+func TestCheckChanges(t *testing.T) {
+	existing := Bills{"power": {Name: "power", BPAY: BPAY{Code: 23796, Account: "79927398713"}}}
+	imported := Bills{
+		"power": {Name: "power", BPAY: BPAY{Code: 23796, Account: "1234567897"}},
+		"water": {Name: "water", BPAY: BPAY{Code: 1234, Account: "79927398713"}},
+	}
+
+	changes := DiffBills(existing, imported)
+	if len(changes) != 2 {
+		t.Fatalf("got %v changes", len(changes))
+	}
+
+	err := CheckChanges(changes, false)
+	if err == nil || !strings.Contains(err.Error(), "power") || strings.Contains(err.Error(), "water") {
+		t.Errorf("got %v, want power refused", err)
+	}
+	err = CheckChanges(changes, true)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBillValidate(t *testing.T) {
+	for _, tc := range []struct {
+		bill Bill
+		want string
+	}{
+		{Bill{Name: "power", BPAY: BPAY{Code: 23796, Account: "79927398713"}}, ""},
+		{Bill{Name: "power", BPAY: BPAY{Code: 23796, Account: "79927398710"}}, "bad check digit"},
+		{Bill{Name: "power", BPAY: BPAY{Code: 12, Account: "79927398713"}}, "biller code 12"},
+		{Bill{Name: "power", BPAY: BPAY{Code: 23796, Account: "1"}}, "2 to 20 digits"},
+		{Bill{Name: "rent", EFT: EFT{BSB: "062-000", AccountNumber: "12345678", AccountName: "Landlord"}}, ""},
+		{Bill{Name: "rent", EFT: EFT{BSB: "062000", AccountNumber: "1", AccountName: "Landlord"}}, ""},
+		{Bill{Name: "rent", EFT: EFT{BSB: "06200", AccountNumber: "1", AccountName: "Landlord"}}, "BSB"},
+		{Bill{Name: "rent", EFT: EFT{BSB: "062-0000", AccountNumber: "1", AccountName: "Landlord"}}, "BSB"},
+		{Bill{Name: "rent", EFT: EFT{BSB: "062000", AccountNumber: "1234567890", AccountName: "Landlord"}}, "1 to 9 digits"},
+		{Bill{Name: "rent", EFT: EFT{BSB: "062000", AccountNumber: "1"}}, "missing account name"},
+		{Bill{Name: " "}, "neither BPAY nor EFT"},
+	} {
+		err := tc.bill.Validate(true)
+		if tc.want == "" {
+			if err != nil {
+				t.Errorf("%+v: %v", tc.bill, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%+v = %v, want %q", tc.bill, err, tc.want)
+		}
+	}
+
+	// Some billers don't use a check digit.
+	bill := Bill{Name: "power", BPAY: BPAY{Code: 23796, Account: "79927398710"}}
+	if err := bill.Validate(false); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLuhnValid(t *testing.T) {
+	for digits, want := range map[string]bool{
+		"79927398713": true,
+		"79927398710": false,
+		"1234567897":  true,
+		"1234567890":  false,
+		"00":          true,
+		"18":          true,
+	} {
+		if luhnValid(digits) != want {
+			t.Errorf("luhnValid(%q) = %v", digits, !want)
+		}
+	}
+}
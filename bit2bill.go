@@ -3,17 +3,20 @@ package cryptobill
 import (
 	"encoding/json"
 	"github.com/pkg/errors"
-	"strings"
+	"net/http"
 )
 
 type Bit2Bill struct{}
 
+// Rates are in AUD and keyed like "BTCRate".
+var bit2BillPairs = mustPairFormat("{crypto}Rate", "AUD", nil)
+
 func (bb *Bit2Bill) PayBPAY(cb *CryptoBill, bpay *PayBPAY) (*PayResult, error) {
-	panic("implement me")
+	return nil, newProviderError(Unsupported, bb.ShortName(), "", "paying BPAY isn't done yet")
 }
 
 func (bb *Bit2Bill) PayEFT(cb *CryptoBill, eft *PayEFT) (*PayResult, error) {
-	panic("implement me")
+	return nil, newProviderError(Unsupported, bb.ShortName(), "", "paying EFT isn't done yet")
 }
 
 func NewBit2Bill() Service {
@@ -29,15 +32,30 @@ func (*Bit2Bill) ShortName() string {
 }
 
 func (*Bit2Bill) Website() string {
-	panic("implement me")
+	return "https://www.bit2bill.com.au"
+}
+
+// Fiats is only AUD, which the rates are in.
+func (*Bit2Bill) Fiats() []Currency {
+	return []Currency{"AUD"}
 }
 
 func (bb *Bit2Bill) Quote(cb *CryptoBill, info *FiatInfo) ([]QuoteResult, error) {
+	if info.Fiat != "AUD" {
+		return nil, newProviderError(Unsupported, bb.ShortName(), "fiat", "only quotes in AUD, not %v", info.Fiat)
+	}
+
 	url := "https://www.bit2bill.com.au/api/rate"
-	resp, err := cb.HttpClient.Get(url)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "request builder")
+	}
+
+	resp, err := cb.HttpClient.Do(req)
 	if err != nil {
 		return nil, errors.Wrap(err, "request failed")
 	}
+	defer resp.Body.Close()
 
 	rates := map[string]float64{}
 	err = json.NewDecoder(resp.Body).Decode(&rates)
@@ -45,17 +63,19 @@ func (bb *Bit2Bill) Quote(cb *CryptoBill, info *FiatInfo) ([]QuoteResult, error)
 		return nil, errors.Wrap(err, "can't decode "+url)
 	}
 
+	reader := newPairReader(bb.ShortName(), bit2BillPairs, info.Fiat)
+	defer reader.Log(cb.log())
+
 	var results []QuoteResult
 	for k, v := range rates {
-		// We're expecting the keys to look like "BTCRate", etc.
-		crypto, err := NewCurrencyFromString(strings.TrimSuffix(k, "Rate"))
-		if err != nil {
-			return nil, err
+		pair, ok := reader.Parse(k)
+		if !ok || !reader.Rate(k, v) {
+			continue
 		}
 
 		result := QuoteResult{
 			Service:    bb,
-			Pair:       Pair{info.Fiat, crypto},
+			Pair:       pair,
 			Conversion: Conversion{info.Amount, info.Amount / Amount(v)},
 		}
 		results = append(results, result)
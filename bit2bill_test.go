@@ -0,0 +1,98 @@
+package cryptobill
+
+import (
+	"bytes"
+	"log/slog"
+	"math"
+	"strings"
+	"testing"
+)
+
+// quoteAmounts maps each quoted crypto to how much of it the fiat buys.
+func quoteAmounts(t *testing.T, results []QuoteResult, service string, fiat FiatInfo) map[Currency]Amount {
+	t.Helper()
+
+	amounts := map[Currency]Amount{}
+	for _, r := range results {
+		if r.Service.ShortName() != service {
+			t.Errorf("quote from %v, want %v", r.Service.ShortName(), service)
+		}
+		if r.Pair.Fiat != fiat.Fiat || r.Conversion.Fiat != fiat.Amount {
+			t.Errorf("%v quote is for %v %v, want %v %v", r.Pair.Crypto, r.Conversion.Fiat, r.Pair.Fiat, fiat.Amount, fiat.Fiat)
+		}
+		amounts[r.Pair.Crypto] = r.Conversion.Crypto
+	}
+	return amounts
+}
+
+func assertAmount(t *testing.T, what string, got, want Amount) {
+	t.Helper()
+
+	if math.Abs(float64(got-want)) > 1e-9 {
+		t.Errorf("%v = %v, want %v", what, got, want)
+	}
+}
+
+func TestBit2BillQuote(t *testing.T) {
+	cb, _ := replayCryptoBill(t, "bit2bill_quote")
+	info := FiatInfo{Amount: 250, Fiat: "AUD"}
+
+	results, err := NewBit2Bill().Quote(cb, &info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	amounts := quoteAmounts(t, results, "B2B", info)
+	if len(amounts) != 3 {
+		t.Fatalf("got quotes for %v, want BTC, ETH and LTC", amounts)
+	}
+	assertAmount(t, "BTC", amounts["BTC"], 250/9012.37)
+	assertAmount(t, "ETH", amounts["ETH"], 250/290.15)
+	assertAmount(t, "LTC", amounts["LTC"], 250/72.4)
+}
+
+func TestBit2BillQuoteUnknownCurrency(t *testing.T) {
+	cb, _ := replayCryptoBill(t, "bit2bill_quote_unknown")
+	var logs bytes.Buffer
+	cb.Logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	info := FiatInfo{Amount: 100, Fiat: "AUD"}
+
+	results, err := NewBit2Bill().Quote(cb, &info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The unknown currency is skipped, and says why.
+	amounts := quoteAmounts(t, results, "B2B", info)
+	if len(amounts) != 1 {
+		t.Fatalf("got quotes for %v, want BTC", amounts)
+	}
+	assertAmount(t, "BTC", amounts["BTC"], 100/9012.37)
+	if !strings.Contains(logs.String(), `"key":"NOPERate","reason":"unknown_currency","symbol":"NOPE"`) {
+		t.Errorf("skipped key wasn't logged: %s", logs.String())
+	}
+}
+
+func TestBit2BillQuoteZeroRate(t *testing.T) {
+	cb, _ := replayCryptoBill(t, "bit2bill_quote_zero")
+	var logs bytes.Buffer
+	cb.Logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	info := FiatInfo{Amount: 100, Fiat: "AUD"}
+
+	results, err := NewBit2Bill().Quote(cb, &info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rates that aren't positive are skipped rather than quoted as infinite.
+	amounts := quoteAmounts(t, results, "B2B", info)
+	if len(amounts) != 1 {
+		t.Fatalf("got quotes for %v, want BTC", amounts)
+	}
+	assertAmount(t, "BTC", amounts["BTC"], 100/9012.37)
+	for _, key := range []string{"ETHRate", "LTCRate"} {
+		if !strings.Contains(logs.String(), `"key":"`+key+`","reason":"bad_rate"`) {
+			t.Errorf("skipped %v wasn't logged: %s", key, logs.String())
+		}
+	}
+}
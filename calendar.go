@@ -0,0 +1,300 @@
+package cryptobill
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Calendar works out when a payment has to be made for it to reach the payee by a due date.
+type Calendar struct {
+	// Time zone the banks' business days and cut-offs are in.
+	Location *time.Location
+
+	// Payments made after this time of day are processed on the next business day.
+	CutOff time.Duration
+
+	// Days off besides weekends, keyed by date as DateLayout, with the holiday's name.
+	Holidays map[string]string
+
+	// How long each service takes to pay a bill, keyed by short name.
+	Processing map[string]Processing
+}
+
+// Processing is how long a service takes between receiving your crypto and paying the bill.
+type Processing struct {
+	// Business days between the service receiving the crypto and it making the bank payment.
+	Days int
+
+	// Crypto received after this time of day counts from the next business day.
+	CutOff time.Duration
+
+	// Allowance for the crypto transaction to be confirmed.
+	Buffer time.Duration
+}
+
+// Conservative guesses based on what the services advertise. Override them in calendar.json.
+var defaultProcessing = map[string]Processing{
+	"PBC":  {Days: 1, CutOff: 15 * time.Hour, Buffer: time.Hour},
+	"B2B":  {Days: 1, CutOff: 14 * time.Hour, Buffer: time.Hour},
+	"LROS": {Days: 2, CutOff: 12 * time.Hour, Buffer: time.Hour},
+}
+
+// NewCalendar returns a calendar for Sydney with the usual 5pm cut-off and the default service
+// processing times. Weekends are the only days off until holidays are added.
+func NewCalendar() (*Calendar, error) {
+	loc, err := time.LoadLocation("Australia/Sydney")
+	if err != nil {
+		return nil, errors.Wrap(err, "load Australia/Sydney")
+	}
+
+	processing := map[string]Processing{}
+	for k, v := range defaultProcessing {
+		processing[k] = v
+	}
+
+	return &Calendar{
+		Location:   loc,
+		CutOff:     17 * time.Hour,
+		Holidays:   map[string]string{},
+		Processing: processing,
+	}, nil
+}
+
+// CalendarConfig is the contents of calendar.json in the config directory. Every field is
+// optional.
+type CalendarConfig struct {
+	// e.g. "Australia/Perth".
+	TimeZone string
+
+	// Bank cut-off, e.g. "17:00".
+	CutOff string
+
+	// State whose holidays apply, e.g. "NSW". Used to filter CSV holiday files.
+	State string
+
+	// ICS or CSV files, relative to the config directory.
+	Holidays []string
+
+	Services map[string]ProcessingConfig
+}
+
+type ProcessingConfig struct {
+	// Unset keeps the default, which 0 would override.
+	Days *int
+
+	// e.g. "15:00".
+	CutOff string
+
+	// e.g. "1h".
+	Buffer string
+}
+
+// LoadCalendar builds the calendar from calendar.json in dir, or returns NewCalendar() if there
+// isn't one.
+func LoadCalendar(dir string) (*Calendar, error) {
+	cal, err := NewCalendar()
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, "calendar.json")
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cal, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read "+path)
+	}
+
+	config := CalendarConfig{}
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode json from "+path)
+	}
+
+	err = cal.apply(&config, dir)
+	if err != nil {
+		return nil, errors.Wrap(err, path)
+	}
+
+	return cal, nil
+}
+
+func (c *Calendar) apply(config *CalendarConfig, dir string) error {
+	if config.TimeZone != "" {
+		loc, err := time.LoadLocation(config.TimeZone)
+		if err != nil {
+			return errors.Wrap(err, "time zone")
+		}
+		c.Location = loc
+	}
+
+	if config.CutOff != "" {
+		cutOff, err := parseClock(config.CutOff)
+		if err != nil {
+			return errors.Wrap(err, "cut-off")
+		}
+		c.CutOff = cutOff
+	}
+
+	for _, file := range config.Holidays {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+
+		holidays, err := LoadHolidays(file, config.State)
+		if err != nil {
+			return errors.Wrap(err, file)
+		}
+
+		for _, h := range holidays {
+			c.Holidays[h.Date] = h.Name
+		}
+	}
+
+	for name, sc := range config.Services {
+		name = strings.ToUpper(name)
+		p := c.Processing[name]
+		if sc.Days != nil {
+			p.Days = *sc.Days
+		}
+
+		if sc.CutOff != "" {
+			cutOff, err := parseClock(sc.CutOff)
+			if err != nil {
+				return errors.Wrap(err, name+" cut-off")
+			}
+			p.CutOff = cutOff
+		}
+
+		if sc.Buffer != "" {
+			buffer, err := time.ParseDuration(sc.Buffer)
+			if err != nil {
+				return errors.Wrap(err, name+" buffer")
+			}
+			p.Buffer = buffer
+		}
+
+		c.Processing[name] = p
+	}
+
+	return nil
+}
+
+// parseClock turns "15:30" into the time since midnight.
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, errors.Errorf("%q should look like 15:04", s)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (cb *CryptoBill) calendar() (*Calendar, error) {
+	if cb.Calendar == nil {
+		dir, err := cb.configDir()
+		if err != nil {
+			return nil, err
+		}
+
+		cal, err := LoadCalendar(dir)
+		if err != nil {
+			return nil, errors.Wrap(err, "load calendar")
+		}
+		cb.Calendar = cal
+	}
+
+	return cb.Calendar, nil
+}
+
+// IsBusinessDay reports whether banks process payments on the day of t.
+func (c *Calendar) IsBusinessDay(t time.Time) bool {
+	t = t.In(c.Location)
+	switch t.Weekday() {
+	case time.Saturday, time.Sunday:
+		return false
+	}
+
+	_, holiday := c.Holidays[t.Format(DateLayout)]
+	return !holiday
+}
+
+// PreviousBusinessDay returns the midnight starting the last business day before the day of t.
+func (c *Calendar) PreviousBusinessDay(t time.Time) time.Time {
+	return c.BusinessDaysBefore(t, 1)
+}
+
+// BusinessDaysBefore returns the midnight starting the nth business day before the day of t. For
+// zero, that is the day of t if it's a business day, otherwise the one before it.
+func (c *Calendar) BusinessDaysBefore(t time.Time, n int) time.Time {
+	day := c.midnight(t)
+	if n == 0 {
+		for !c.IsBusinessDay(day) {
+			day = day.AddDate(0, 0, -1)
+		}
+		return day
+	}
+
+	for n > 0 {
+		day = day.AddDate(0, 0, -1)
+		if c.IsBusinessDay(day) {
+			n--
+		}
+	}
+	return day
+}
+
+// PayBy returns the latest time the bank payment can be made for it to arrive by the start of
+// due. BPAY and EFT both need to be made before the cut-off on the business day before.
+func (c *Calendar) PayBy(due time.Time) time.Time {
+	return c.at(c.PreviousBusinessDay(due), c.CutOff)
+}
+
+// ServicePayBy returns the latest time crypto can be sent to a service for it to pay the bill by
+// due. Services without known processing times are assumed to pay on the day they receive the
+// crypto.
+func (c *Calendar) ServicePayBy(due time.Time, service string) time.Time {
+	p, ok := c.Processing[strings.ToUpper(service)]
+	if !ok {
+		p = Processing{CutOff: c.CutOff}
+	}
+
+	// The service makes the bank payment Days business days after the day it gets the crypto.
+	day := c.BusinessDaysBefore(c.PayBy(due), p.Days)
+	cutOff := p.CutOff
+	if p.Days == 0 && cutOff > c.CutOff {
+		cutOff = c.CutOff
+	}
+
+	return c.at(day, cutOff).Add(-p.Buffer)
+}
+
+// at returns the time of day on day. Adding the duration to midnight would be an hour out on days
+// when daylight saving starts or ends.
+func (c *Calendar) at(day time.Time, clock time.Duration) time.Time {
+	day = day.In(c.Location)
+	hour := int(clock / time.Hour)
+	minute := int(clock % time.Hour / time.Minute)
+	return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, c.Location)
+}
+
+func (c *Calendar) midnight(t time.Time) time.Time {
+	t = t.In(c.Location)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, c.Location)
+}
+
+// ServicePayBy is Calendar.ServicePayBy with the calendar from the config directory.
+func (cb *CryptoBill) ServicePayBy(due time.Time, service string) (time.Time, error) {
+	cal, err := cb.calendar()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return cal.ServicePayBy(due, service), nil
+}
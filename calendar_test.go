@@ -0,0 +1,78 @@
+package cryptobill
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func loadTestCalendar(t *testing.T, config string) *Calendar {
+	t.Helper()
+
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "calendar.json"), []byte(config), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cal, err := LoadCalendar(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cal
+}
+
+func TestCalendarServiceOverrides(t *testing.T) {
+	cal := loadTestCalendar(t, `{"Services": {"pbc": {"CutOff": "13:00"}, "lros": {"Buffer": "2h"}, "b2b": {"Days": 0}}}`)
+
+	for name, want := range map[string]Processing{
+		// Setting only the cut-off or buffer keeps the default days.
+		"PBC":  {Days: 1, CutOff: 13 * time.Hour, Buffer: time.Hour},
+		"LROS": {Days: 2, CutOff: 12 * time.Hour, Buffer: 2 * time.Hour},
+		"B2B":  {Days: 0, CutOff: 14 * time.Hour, Buffer: time.Hour},
+	} {
+		if got := cal.Processing[name]; got != want {
+			t.Errorf("%v = %+v, want %+v", name, got, want)
+		}
+	}
+}
+
+func TestCalendarPayBy(t *testing.T) {
+	cal := loadTestCalendar(t, `{"TimeZone": "Australia/Sydney"}`)
+	cal.Holidays["2018-12-25"] = "Christmas Day"
+	cal.Holidays["2018-12-26"] = "Boxing Day"
+	at := func(s string) time.Time {
+		parsed, err := time.ParseInLocation("2006-01-02 15:04", s, cal.Location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	for _, tc := range []struct {
+		due, service, want string
+	}{
+		// Due Wednesday: the bank payment goes Tuesday, and PBC needs the crypto a day earlier.
+		{"2018-11-14 00:00", "", "2018-11-13 17:00"},
+		{"2018-11-14 00:00", "PBC", "2018-11-12 14:00"},
+		{"2018-11-14 00:00", "LROS", "2018-11-09 11:00"},
+		// Due Monday: Friday, then Thursday for PBC.
+		{"2018-11-19 00:00", "PBC", "2018-11-15 14:00"},
+		// Christmas and Boxing Day aren't business days.
+		{"2018-12-27 00:00", "", "2018-12-24 17:00"},
+		{"2018-12-27 00:00", "B2B", "2018-12-21 13:00"},
+		// Unknown services pay the day they get the crypto.
+		{"2018-11-14 00:00", "NEW", "2018-11-13 17:00"},
+	} {
+		var got time.Time
+		if tc.service == "" {
+			got = cal.PayBy(at(tc.due))
+		} else {
+			got = cal.ServicePayBy(at(tc.due), tc.service)
+		}
+		if !got.Equal(at(tc.want)) {
+			t.Errorf("%v %v = %v, want %v", tc.service, tc.due, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"github.com/gak/cryptobill"
+	"github.com/pkg/errors"
+)
+
+type BillCmd struct {
+	Show struct {
+		Name string `arg`
+	} `cmd help:"Show everything stored for a bill."`
+
+	Edit struct {
+		Name string `arg`
+
+		Code    int    `help:"BPAY biller code."`
+		Account string `help:"BPAY reference."`
+
+		BSB           string `help:"EFT BSB."`
+		AccountNumber string `help:"EFT account number."`
+		AccountName   string `help:"EFT account name."`
+		Remitter      string `help:"Shown on the receiving bank statement."`
+	} `cmd help:"Change the details of a bill. Only the given flags are changed."`
+
+	Rename struct {
+		Name    string `arg`
+		NewName string `arg`
+	} `cmd help:"Rename a bill."`
+
+	Remove struct {
+		Name string `arg`
+	} `cmd help:"Remove a bill."`
+
+	Schedule ScheduleCmd `cmd help:"Set when a bill is due, how often, and how much."`
+	Paid     PaidCmd     `cmd help:"Record that a scheduled bill has been paid."`
+}
+
+// billFields are the fields of list and bill show. The ones not shown in list tables are
+// summarised by details.
+var billFields = []Field{
+	{Name: "name"},
+	{Name: "type"},
+	{Name: "details"},
+	{Name: "biller_code", Extra: true},
+	{Name: "biller_name", Extra: true},
+	{Name: "reference", Extra: true},
+	{Name: "bsb", Extra: true},
+	{Name: "bsb_name", Extra: true},
+	{Name: "account_number", Extra: true},
+	{Name: "account_name", Extra: true},
+	{Name: "remitter", Extra: true},
+	{Name: "due", Extra: true},
+	{Name: "every", Extra: true},
+	{Name: "amount", Format: "%.2f", Extra: true},
+	{Name: "fiat", Extra: true},
+	{Name: "variable", Extra: true},
+	{Name: "pay_crypto", Extra: true},
+	{Name: "pay_service", Extra: true},
+	{Name: "lead_days", Extra: true},
+	{Name: "paid", Extra: true},
+}
+
+// billRow leaves fields that don't apply to the bill as nil.
+func billRow(bill *cryptobill.Bill) map[string]interface{} {
+	row := map[string]interface{}{
+		"name":    bill.Name,
+		"type":    bill.Type(),
+		"details": bill.Summary(),
+	}
+
+	switch bill.Type() {
+	case "BPAY":
+		row["biller_code"] = bill.BPAY.Code
+		row["reference"] = bill.BPAY.Account
+		if bill.BPAY.Name != "" {
+			row["biller_name"] = bill.BPAY.Name
+		}
+	case "EFT":
+		row["bsb"] = bill.EFT.BSB
+		row["account_number"] = bill.EFT.AccountNumber
+		row["account_name"] = bill.EFT.AccountName
+		if bill.EFT.BSBName != "" {
+			row["bsb_name"] = bill.EFT.BSBName
+		}
+		if bill.EFT.Remitter != "" {
+			row["remitter"] = bill.EFT.Remitter
+		}
+	}
+
+	if s := bill.Schedule; s != nil {
+		row["due"] = s.Due
+		row["lead_days"] = s.LeadDays
+		if s.Every != "" {
+			row["every"] = s.Every
+		}
+		if s.Amount != 0 {
+			row["amount"] = s.Amount
+			row["fiat"] = s.Fiat
+			row["variable"] = s.Variable
+		}
+		if s.Crypto != "" {
+			row["pay_crypto"] = s.Crypto
+		}
+		if s.Service != "" {
+			row["pay_service"] = s.Service
+		}
+		if s.Paid != "" {
+			row["paid"] = s.Paid
+		}
+	}
+
+	return row
+}
+
+func (m *Main) list() error {
+	bills, err := m.cb.LoadBills()
+	if err != nil {
+		return errors.Wrap(err, "load bills")
+	}
+
+	records := &Records{Fields: billFields}
+	for _, name := range bills.Names() {
+		if bills[name] == nil {
+			// Reported by "bills doctor".
+			continue
+		}
+		records.Add(billRow(bills[name]))
+	}
+
+	return m.write(records)
+}
+
+func (m *Main) billShow(name string) error {
+	bill, err := m.cb.GetBill(name)
+	if err != nil {
+		return errors.Wrap(err, "get bill")
+	}
+
+	// Every field is worth showing for a single bill, apart from the summary of them.
+	var fields []Field
+	for _, f := range billFields {
+		if f.Name != "details" {
+			f.Extra = false
+			fields = append(fields, f)
+		}
+	}
+
+	records := &Records{Fields: fields, Single: true}
+	records.Add(billRow(bill))
+	return m.write(records)
+}
+
+func (m *Main) billEdit() error {
+	edit := &m.cli.Bill.Edit
+	bpay := edit.Code != 0 || edit.Account != ""
+	eft := edit.BSB != "" || edit.AccountNumber != "" || edit.AccountName != "" || edit.Remitter != ""
+	if !bpay && !eft {
+		return errors.New("nothing to change, see --help")
+	}
+
+	return m.cb.EditBill(edit.Name, func(bill *cryptobill.Bill) error {
+		if bpay && bill.Type() != "BPAY" || eft && bill.Type() != "EFT" {
+			if bill.Type() == "" {
+				return errors.Errorf("%v has no payment details", edit.Name)
+			}
+			return errors.Errorf("%v is a %v bill", edit.Name, bill.Type())
+		}
+
+		if edit.Code != 0 {
+			bill.BPAY.Code = edit.Code
+			// Looked up again on the next payment.
+			bill.BPAY.Name = ""
+		}
+		if edit.Account != "" {
+			bill.BPAY.Account = edit.Account
+		}
+		if edit.BSB != "" {
+			bill.EFT.BSB = edit.BSB
+			bill.EFT.BSBName = ""
+		}
+		if edit.AccountNumber != "" {
+			bill.EFT.AccountNumber = edit.AccountNumber
+		}
+		if edit.AccountName != "" {
+			bill.EFT.AccountName = edit.AccountName
+		}
+		if edit.Remitter != "" {
+			bill.EFT.Remitter = edit.Remitter
+		}
+
+		return nil
+	})
+}
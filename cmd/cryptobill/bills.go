@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gak/cryptobill"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"strings"
+)
+
+type BillsCmd struct {
+	Import struct {
+		File         string   `arg help:"CSV, JSON or YAML file to read, or - for stdin."`
+		Format       string   `help:"csv, json or yaml. Guessed from the file extension if not given."`
+		Column       []string `help:"Rename a column of the file, e.g. --column=\"Biller Code=code\". Known columns are name, type, code, account, bsb, account_number, account_name and remitter."`
+		DryRun       bool     `help:"Show what would change without saving anything."`
+		Overwrite    bool     `help:"Allow changing existing bills with the same name."`
+		SkipCRNCheck bool     `help:"Don't check BPAY references have a valid check digit."`
+	} `cmd help:"Add bills from a CSV, JSON or YAML file."`
+
+	Export struct {
+		File   string `arg optional help:"File to write. Defaults to stdout."`
+		Format string `help:"csv, json or yaml. Guessed from the file extension, otherwise csv."`
+	} `cmd help:"Write your bills to a CSV, JSON or YAML file."`
+
+	Doctor struct {
+		Fix bool `help:"Repair the problems that can be repaired automatically."`
+	} `cmd help:"Check your bills for problems. Also upgrades bills saved by older versions."`
+}
+
+func (m *Main) billsImport() error {
+	opts := &m.cli.Bills.Import
+
+	format := opts.Format
+	if format == "" {
+		var err error
+		format, err = cryptobill.FormatFromPath(opts.File)
+		if err != nil {
+			return err
+		}
+	}
+
+	columns := map[string]string{}
+	for _, column := range opts.Column {
+		bits := strings.SplitN(column, "=", 2)
+		if len(bits) != 2 {
+			return errors.New("--column should look like \"Biller Code=code\", not " + column)
+		}
+		columns[bits[0]] = bits[1]
+	}
+
+	var r io.Reader = os.Stdin
+	if opts.File != "-" {
+		fp, err := os.Open(opts.File)
+		if err != nil {
+			return errors.Wrap(err, "open")
+		}
+		defer fp.Close()
+		r = fp
+	}
+
+	imported, err := cryptobill.ReadBills(r, cryptobill.ImportOptions{
+		Format:       format,
+		Columns:      columns,
+		SkipCRNCheck: opts.SkipCRNCheck,
+	})
+	if err != nil {
+		return errors.Wrap(err, "nothing was imported")
+	}
+
+	var changes []cryptobill.BillChange
+	if opts.DryRun {
+		existing, err := m.cb.LoadBills()
+		if err != nil {
+			return errors.Wrap(err, "load bills")
+		}
+		changes = cryptobill.DiffBills(existing, imported)
+		err = cryptobill.CheckChanges(changes, opts.Overwrite)
+		if err != nil {
+			return errors.Wrap(err, "nothing would be imported")
+		}
+	} else {
+		changes, err = m.cb.ImportBills(imported, opts.Overwrite)
+		if err != nil {
+			return errors.Wrap(err, "import")
+		}
+	}
+
+	if m.machineOutput() {
+		return m.write(importRecords(changes, opts.DryRun))
+	}
+
+	for _, change := range changes {
+		if change.Old == nil {
+			fmt.Printf("+ %v: %v %v\n", change.Name, change.New.Type(), change.New.Summary())
+			continue
+		}
+
+		fmt.Printf("~ %v\n", change.Name)
+		for _, field := range change.Fields() {
+			fmt.Printf("    %v\n", field)
+		}
+	}
+
+	verb := "Imported"
+	if opts.DryRun {
+		verb = "Would import"
+	}
+	fmt.Printf("%v %v of %v bills, the rest are unchanged.\n", verb, len(changes), len(imported))
+
+	return nil
+}
+
+func importRecords(changes []cryptobill.BillChange, dryRun bool) *Records {
+	records := &Records{
+		Fields: []Field{
+			{Name: "name"},
+			{Name: "change"},
+			{Name: "type"},
+			{Name: "fields"},
+			{Name: "saved"},
+		},
+	}
+
+	for _, change := range changes {
+		row := map[string]interface{}{
+			"name":  change.Name,
+			"type":  change.New.Type(),
+			"saved": !dryRun,
+		}
+		if change.Old == nil {
+			row["change"] = "add"
+		} else {
+			row["change"] = "update"
+			row["fields"] = strings.Join(change.Fields(), "; ")
+		}
+		records.Add(row)
+	}
+
+	return records
+}
+
+func (m *Main) billsExport() error {
+	opts := &m.cli.Bills.Export
+
+	format := opts.Format
+	if format == "" {
+		format = "csv"
+		if opts.File != "" {
+			var err error
+			format, err = cryptobill.FormatFromPath(opts.File)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	bills, err := m.cb.LoadBills()
+	if err != nil {
+		return errors.Wrap(err, "load bills")
+	}
+
+	if opts.File == "" {
+		return cryptobill.WriteBills(os.Stdout, bills, format)
+	}
+
+	fp, err := os.OpenFile(opts.File, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrap(err, "create")
+	}
+
+	err = cryptobill.WriteBills(fp, bills, format)
+	if err != nil {
+		fp.Close()
+		return err
+	}
+
+	return fp.Close()
+}
+
+func (m *Main) billsDoctor(fix bool) error {
+	problems, err := m.cb.Doctor(fix)
+	if err != nil {
+		return errors.Wrap(err, "doctor")
+	}
+
+	if m.machineOutput() {
+		records := &Records{
+			Fields: []Field{{Name: "key"}, {Name: "problem"}, {Name: "fixable"}, {Name: "fixed"}},
+		}
+		for _, problem := range problems {
+			records.Add(map[string]interface{}{
+				"key":     problem.Key,
+				"problem": problem.Problem,
+				"fixable": problem.Fixable,
+				"fixed":   problem.Fixable && fix,
+			})
+		}
+		return m.write(records)
+	}
+
+	if len(problems) == 0 {
+		fmt.Println("No problems found.")
+		return nil
+	}
+
+	for _, problem := range problems {
+		note := ""
+		if problem.Fixable && fix {
+			note = " (fixed)"
+		} else if problem.Fixable {
+			note = " (run with --fix to repair)"
+		}
+		fmt.Printf("%v: %v%v\n", problem.Key, problem.Problem, note)
+	}
+
+	return nil
+}
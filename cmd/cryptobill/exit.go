@@ -0,0 +1,60 @@
+package main
+
+import (
+	"github.com/gak/cryptobill"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+)
+
+// Exit codes, so scripts can tell what went wrong without reading the message.
+const (
+	exitError        = 1
+	exitUnavailable  = 3
+	exitRejected     = 4
+	exitAuthRequired = 5
+	exitRateExpired  = 6
+	exitUnsupported  = 7
+	exitAmount       = 8
+	exitPolicy       = 9
+	exitNotConfirmed = 10
+)
+
+var kindExitCodes = map[cryptobill.ErrorKind]int{
+	cryptobill.Unavailable:      exitUnavailable,
+	cryptobill.RejectedInput:    exitRejected,
+	cryptobill.AuthRequired:     exitAuthRequired,
+	cryptobill.RateExpired:      exitRateExpired,
+	cryptobill.Unsupported:      exitUnsupported,
+	cryptobill.AmountOutOfRange: exitAmount,
+}
+
+func exitCode(err error) int {
+	// Several services failing the same way, e.g. all unavailable, is still that kind of failure.
+	if merr, ok := errors.Cause(err).(*multierror.Error); ok {
+		code := 0
+		for _, e := range merr.Errors {
+			c := exitCode(e)
+			if code != 0 && c != code {
+				return exitError
+			}
+			code = c
+		}
+		if code != 0 {
+			return code
+		}
+	}
+
+	if perr, ok := cryptobill.AsProviderError(err); ok {
+		if code, ok := kindExitCodes[perr.Kind]; ok {
+			return code
+		}
+	}
+
+	if _, ok := errors.Cause(err).(*cryptobill.PolicyError); ok {
+		return exitPolicy
+	}
+	if errors.Cause(err) == cryptobill.ErrNotConfirmed {
+		return exitNotConfirmed
+	}
+	return exitError
+}
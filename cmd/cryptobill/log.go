@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gak/cryptobill"
+	"github.com/pkg/errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// logOptions sets up logging to stderr from --log-level and --log-format, and tracing for
+// --debug-http and --har.
+func (m *Main) logOptions() ([]cryptobill.Option, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(m.cli.LogLevel))
+	if err != nil {
+		return nil, errors.Errorf("unknown --log-level %q, use debug, info, warn or error", m.cli.LogLevel)
+	}
+
+	// The requests and responses are logged at debug level.
+	if m.cli.DebugHTTP {
+		level = slog.LevelDebug
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch strings.ToLower(m.cli.LogFormat) {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, handlerOpts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, handlerOpts)
+	default:
+		return nil, errors.Errorf("unknown --log-format %q, use text or json", m.cli.LogFormat)
+	}
+	logger := slog.New(handler)
+
+	opts := []cryptobill.Option{cryptobill.WithLogger(logger)}
+	if m.cli.DebugHTTP || m.cli.HAR != "" {
+		m.tracer = cryptobill.NewTracer(nil)
+		m.tracer.HAR = m.cli.HAR != ""
+		if m.cli.DebugHTTP {
+			m.tracer.Logger = logger
+		}
+		opts = append(opts, cryptobill.WithTracer(m.tracer))
+	}
+
+	return opts, nil
+}
+
+// saveHARWhenStopped writes the HAR file if a long-running command is stopped with Ctrl-C.
+func (m *Main) saveHARWhenStopped() {
+	if m.cli.HAR == "" {
+		return
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		m.saveHAR()
+		os.Exit(130)
+	}()
+}
+
+func (m *Main) saveHAR() {
+	if m.cli.HAR == "" {
+		return
+	}
+
+	err := m.tracer.SaveHAR(m.cli.HAR)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, errors.Wrap(err, "save har"))
+		return
+	}
+	fmt.Fprintf(os.Stderr, "HTTP requests saved to %v\n", m.cli.HAR)
+}
@@ -1,16 +1,13 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"github.com/alecthomas/repr"
 	"github.com/gak/cryptobill"
 	"github.com/pkg/errors"
-	"io/ioutil"
-	"net/http"
 	"os"
 	"sort"
-	"text/tabwriter"
+	"time"
+	_ "time/tzdata"
 
 	"github.com/alecthomas/kong"
 	"strings"
@@ -21,9 +18,13 @@ type Quote struct {
 	Filter              []string `help:"Filter by cryptocurrency, e.g. BTC,ETH"`
 	Services            []string `help:"Filter by service, e.g. BPC,LROS"`
 	NoConvertBack       bool
+	Bill                string `help:"Show when to pay each service by for the next due date of this scheduled bill."`
+	Record              bool   `help:"Keep the quotes in history.jsonl for \"stats\"."`
 }
 
 type Add struct {
+	Overwrite bool `help:"Replace an existing bill with the same name."`
+
 	BPAY struct {
 		Name string `arg`
 		cryptobill.BPAY
@@ -40,101 +41,241 @@ type List struct{}
 type Pay struct {
 	Name string `arg`
 	cryptobill.PayInfoService
+
+	Yes    bool `short:"y" help:"Create the order without asking first."`
+	DryRun bool `help:"Show what would be ordered, without creating the order."`
 }
 
 type CLI struct {
-	Quote Quote `cmd`
-	Add   Add   `cmd`
-	List  List  `cmd help:"List your different bills."`
-	Pay   Pay   `cmd help:"Prepare a payment and retrieve an address to send crypto to."`
+	ConfigDir string `help:"Directory for bills and backups. Defaults to $CRYPTOBILL_CONFIG_DIR, then ~/.config/cryptobill."`
+	BillsPath string `name:"bills" help:"Path to the bills file. Defaults to $CRYPTOBILL_BILLS, then bills.json in the config directory."`
+
+	Sandbox bool `env:"CRYPTOBILL_SANDBOX" help:"Use fake services and prices from sandbox.json. Payments and quote history are kept apart from the real ones."`
+
+	LogLevel  string `default:"warn" help:"Log to stderr at this level or above: debug, info, warn or error."`
+	LogFormat string `default:"text" help:"Log format: text or json."`
+	DebugHTTP bool   `name:"debug-http" help:"Log every request to the services and their responses in full, with secrets redacted."`
+	HAR       string `name:"har" help:"Save every request to the services to this HAR file, with secrets redacted, e.g. for a bug report."`
+
+	Output string   `default:"table" help:"Output format: table, json, csv or tsv."`
+	Fields []string `help:"Only output these fields, in this order, e.g. service,crypto,markup. See the README for each command's fields."`
+
+	Quote Quote    `cmd`
+	Add   Add      `cmd`
+	List  List     `cmd help:"List your different bills."`
+	Bill  BillCmd  `cmd help:"Show, edit, rename or remove a bill."`
+	Bills BillsCmd `cmd help:"Import or export all your bills."`
+	Pay   Pay      `cmd help:"Prepare a payment and retrieve an address to send crypto to."`
+
+	Approve  Approve  `cmd help:"Pay a payment that the policy held for approval."`
+	Payments Payments `cmd help:"List payments, newest first."`
+	Status   Status   `cmd help:"Check what has happened to a payment."`
+
+	Due       Due       `cmd help:"List scheduled bills that are due soon."`
+	Scheduler Scheduler `cmd help:"Keep running and prepare quotes ahead of each bill's due date."`
+	Watch     WatchCmd  `cmd help:"Keep quoting and alert when markups are low."`
+	Stats     Stats     `cmd help:"Show markups from recorded quotes."`
+	Serve     Serve     `cmd help:"Serve a JSON API for quotes, bills and payments."`
+
+	Vault  Vault     `cmd help:"Keep your bills and logins in an encrypted vault."`
+	Notify NotifyCmd `cmd help:"Check where notifications are sent."`
 }
 
 type Main struct {
 	cb  *cryptobill.CryptoBill
 	cli CLI
+
+	// Set for --debug-http and --har.
+	tracer *cryptobill.Tracer
+
+	// Whether a csv header has been written for a long-running command.
+	wroteHeader bool
+
+	// The cryptos from --filter, checked before anything is quoted.
+	filter []cryptobill.Currency
 }
 
 func main() {
 	var err error
 
-	m := Main{
-		cb: cryptobill.NewCryptoBill(),
+	m := Main{}
+	ctx := kong.Parse(&m.cli)
+
+	opts, err := m.logOptions()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	m.cb, err = cryptobill.NewCryptoBill(opts...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	m.saveHARWhenStopped()
+
+	m.cb.ConfigDir = m.cli.ConfigDir
+	m.cb.BillsPath = m.cli.BillsPath
+	m.cb.Passphrase = readPassphrase
+
+	err = m.checkOutput()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if m.cli.Sandbox {
+		err = m.cb.EnableSandbox()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Fprintln(os.Stderr, "Sandbox: no real quotes or payments.")
+	} else {
+		err = m.cb.LoadAdapters()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 	}
 
-	ctx := kong.Parse(&m.cli)
 	switch ctx.Command() {
 	case "quote <amount> <fiat>":
 		err = m.quote(&m.cli.Quote)
 	case "list":
-		err = m.cb.ListBills()
-	case "add bpay <name> <code> <account>":
-		err = m.cb.AddBill(entry(&m.cli.Add))
-	case "add eft <name> <bsb> <account-number> <account-name>":
-		err = m.cb.AddBill(entry(&m.cli.Add))
+		err = m.list()
+	case "add bpay <name> <code> <account>", "add eft <name> <bsb> <account-number> <account-name>":
+		err = m.add(&m.cli.Add)
+	case "bill show <name>":
+		err = m.billShow(m.cli.Bill.Show.Name)
+	case "bill edit <name>":
+		err = m.billEdit()
+	case "bill rename <name> <new-name>":
+		err = m.cb.RenameBill(m.cli.Bill.Rename.Name, m.cli.Bill.Rename.NewName)
+	case "bill remove <name>":
+		err = m.cb.RemoveBill(m.cli.Bill.Remove.Name)
 	case "pay <name> <amount> <fiat> <crypto> <service>":
 		err = m.pay(&m.cli.Pay)
+	case "approve <id>":
+		err = m.approve(&m.cli.Approve)
+	case "payments":
+		err = m.payments(m.cli.Payments.Held)
+	case "status <id>":
+		err = m.status(m.cli.Status.ID)
+	case "bill schedule <name>":
+		err = m.billSchedule()
+	case "bill paid <name>":
+		err = m.billPaid()
+	case "due":
+		err = m.due(m.cli.Due.Days)
+	case "scheduler":
+		err = m.scheduler(&m.cli.Scheduler)
+	case "watch":
+		err = m.watch(&m.cli.Watch)
+	case "stats":
+		err = m.stats(&m.cli.Stats)
+	case "serve":
+		err = m.serve(&m.cli.Serve)
+	case "bills import <file>":
+		err = m.billsImport()
+	case "bills export", "bills export <file>":
+		err = m.billsExport()
+	case "bills doctor":
+		err = m.billsDoctor(m.cli.Bills.Doctor.Fix)
+	case "notify test":
+		err = m.notifyTest(m.cli.Notify.Test.Event)
+	case "vault init":
+		err = m.vaultInit()
+	case "vault unlock":
+		err = m.vaultUnlock(m.cli.Vault.Unlock.Timeout)
+	case "vault lock":
+		err = m.cb.LockVault()
+	case "vault rekey":
+		err = m.vaultRekey()
+	case "vault auth <service> <auth>":
+		err = m.cb.SetCredential(m.cli.Vault.Auth.Service, m.cli.Vault.Auth.Auth)
+	case "vault agent":
+		err = m.vaultAgent(m.cli.Vault.Agent.Timeout)
 	default:
-		panic("unknown command: " + ctx.Command())
+		err = errors.Errorf("unknown command: %v", ctx.Command())
 	}
 
+	m.waitNotify()
+	m.saveHAR()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitCode(err))
+	}
+}
+
+func (m *Main) add(add *Add) error {
+	bill, err := entry(add)
 	if err != nil {
-		fmt.Printf("%+v\n", err)
-		panic(err)
+		return err
 	}
+	return m.cb.AddBill(bill, add.Overwrite)
 }
 
-func entry(add *Add) *cryptobill.Bill {
+func entry(add *Add) (*cryptobill.Bill, error) {
 	if add.BPAY.Name != "" {
 		return &cryptobill.Bill{
 			Name: add.BPAY.Name,
 			BPAY: add.BPAY.BPAY,
-		}
+		}, nil
 	} else if add.EFT.Name != "" {
 		return &cryptobill.Bill{
 			Name: add.EFT.Name,
 			EFT:  add.EFT.EFT,
-		}
+		}, nil
 	} else {
-		panic("unknown bill type")
+		return nil, errors.New("unknown bill type")
 	}
 }
 
 func (m *Main) pay(pay *Pay) error {
-	bill, err := m.cb.GetBill(pay.Name)
-	if err != nil {
-		return errors.Wrap(err, "get bill")
-	}
+	m.cb.Confirm = m.confirmPayment(pay)
 
-	if bill.BPAY != (cryptobill.BPAY{}) {
-		payBPAY := cryptobill.PayBPAY{
-			PayInfoService: pay.PayInfoService,
-			BPAY:           bill.BPAY,
-		}
-		result, err := m.cb.PayBPAY(&payBPAY)
+	// Pay would record a held payment without asking the service anything.
+	if pay.DryRun {
+		approval, err := m.cb.CheckPolicy(pay.Name, &pay.PayInfoService)
 		if err != nil {
-			return errors.Wrap(err, "pay bpay")
+			return err
 		}
-
-		repr.Println(result)
-	} else if bill.EFT != (cryptobill.EFT{}) {
-		payEFT := cryptobill.PayEFT{
-			PayInfoService: pay.PayInfoService,
-			EFT:           bill.EFT,
-		}
-		result, err := m.cb.PayEFT(&payEFT)
-		if err != nil {
-			return errors.Wrap(err, "pay bpay")
+		if approval {
+			fmt.Println("Would be held by the spending policy until approved.")
+			return nil
 		}
+	}
 
-		repr.Println(result)
-	} else {
-		return errors.New("bill error, could not find data")
+	payment, err := m.cb.Pay(pay.Name, &pay.PayInfoService)
+	if errors.Cause(err) == cryptobill.ErrDryRun {
+		return nil
 	}
+	if payment == nil {
+		return err
+	}
+
+	m.notify(cryptobill.NewPaymentEvent(payment))
 
-	return nil
+	// A failed payment is still written out, so scripts can see what was tried.
+	writeErr := m.write(paymentRecords(payment))
+	if err != nil {
+		return err
+	}
+	if payment.Status == cryptobill.PaymentHeld && !m.machineOutput() {
+		fmt.Printf("Held by the spending policy. Run \"cryptobill approve %v\" to pay it.\n", payment.ID)
+	}
+	return writeErr
 }
 
 func (m *Main) quote(q *Quote) error {
+	for _, s := range q.Filter {
+		filter, err := cryptobill.NewCurrencyFromString(s)
+		if err != nil {
+			return errors.Wrap(err, "filter")
+		}
+		m.filter = append(m.filter, filter)
+	}
+
 	result, err := m.cb.Quote(&q.FiatInfo)
 	if err != nil {
 		return errors.Wrap(err, "quote")
@@ -146,61 +287,101 @@ func (m *Main) quote(q *Quote) error {
 		sortByCryptoAndValue(result)
 
 	} else {
-		lookup, err = m.fetchExchange(result)
+		lookup = m.fetchExchange(result)
+		sortByFiatValue(result, lookup)
+	}
+
+	if q.Record {
+		err = m.cb.RecordQuotes(time.Now(), result, lookup)
 		if err != nil {
 			return errors.Wrap(err, "quote")
 		}
+	}
 
-		sortByFiatValue(result, lookup)
+	var due time.Time
+	if q.Bill != "" {
+		due, err = m.nextDue(q.Bill)
+		if err != nil {
+			return errors.Wrap(err, "quote")
+		}
 	}
 
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.AlignRight|tabwriter.Debug)
+	records := quoteRecords(!q.NoConvertBack, !due.IsZero())
 	for _, quote := range result {
-
 		if !m.showQuote(quote) {
 			continue
 		}
 
-		_, err := fmt.Fprintf(
-			w, "%v\t%v\t%5.5f\t",
-			quote.Service.ShortName(),
-			quote.Pair.Crypto,
-			quote.Conversion.Crypto,
-		)
-		if err != nil {
-			return errors.Wrap(err, "fprintf")
+		row := map[string]interface{}{
+			"service":       quote.Service.ShortName(),
+			"crypto":        quote.Pair.Crypto,
+			"crypto_amount": quote.Conversion.Crypto,
+			"fiat":          quote.Pair.Fiat,
+			"fiat_amount":   quote.Conversion.Fiat,
+		}
+		if quote.FX != nil {
+			row["priced_in"] = quote.FX.Fiat
 		}
 
-		if !q.NoConvertBack {
-			_, err = fmt.Fprintf(
-				w, "%5.5f\t%2.3f%%\t",
-				lookup[quote.Pair.Crypto]*quote.Conversion.Crypto,
-				lookup[quote.Pair.Crypto]*quote.Conversion.Crypto/quote.Conversion.Fiat*100-100,
-			)
+		if reference, ok := lookup[quote.Pair.Crypto]; ok && !q.NoConvertBack {
+			row["reference"] = reference
+			row["value"] = reference * quote.Conversion.Crypto
+			row["markup"] = cryptobill.NewMarkup(quote, reference).Percent
+		}
+
+		if !due.IsZero() {
+			row["pay_by"], err = m.cb.ServicePayBy(due, quote.Service.ShortName())
 			if err != nil {
-				return errors.Wrap(err, "fprintf")
+				return errors.Wrap(err, "pay by")
 			}
 		}
 
-		_, err = fmt.Fprintf(w, "\n")
-		if err != nil {
-			return errors.Wrap(err, "fprintf")
-		}
+		records.Add(row)
 	}
 
-	err = w.Flush()
-	if err != nil {
-		return errors.Wrap(err, "flush")
+	return m.write(records)
+}
+
+// quoteRecords describes the output of quote. value is what the crypto is worth at the reference
+// price, and markup is how much more than that the quote costs, in percent.
+func quoteRecords(reference, payBy bool) *Records {
+	records := &Records{Fields: []Field{
+		{Name: "service"},
+		{Name: "crypto"},
+		{Name: "crypto_amount", Format: "%.5f"},
+	}}
+
+	if reference {
+		records.Fields = append(records.Fields,
+			Field{Name: "value", Format: "%.5f"},
+			Field{Name: "markup", Format: "%.3f"},
+		)
+	}
+	if payBy {
+		records.Fields = append(records.Fields, Field{Name: "pay_by"})
+	}
+
+	records.Fields = append(records.Fields,
+		Field{Name: "fiat", Extra: true},
+		Field{Name: "fiat_amount", Format: "%.2f", Extra: true},
+	)
+	if reference {
+		records.Fields = append(records.Fields, Field{Name: "reference", Format: "%.2f", Extra: true})
 	}
+	records.Fields = append(records.Fields, Field{Name: "priced_in", Extra: true})
 
-	return nil
+	return records
 }
 
+// sortByFiatValue puts the quotes in order of value, with coins that have no reference price last.
 func sortByFiatValue(result []cryptobill.QuoteResult, lookup map[cryptobill.Currency]cryptobill.Amount) {
-	sort.Slice(result, func(i, j int) bool {
-		vi := result[i].Conversion.Crypto * lookup[result[i].Pair.Crypto]
-		vj := result[j].Conversion.Crypto * lookup[result[j].Pair.Crypto]
-		return vi < vj
+	sort.SliceStable(result, func(i, j int) bool {
+		ri, iok := lookup[result[i].Pair.Crypto]
+		rj, jok := lookup[result[j].Pair.Crypto]
+		if iok != jok {
+			return iok
+		}
+		return result[i].Conversion.Crypto*ri < result[j].Conversion.Crypto*rj
 	})
 }
 
@@ -227,16 +408,12 @@ func (m *Main) showQuote(quote cryptobill.QuoteResult) bool {
 		}
 	}
 
-	if len(m.cli.Quote.Filter) == 0 {
+	if len(m.filter) == 0 {
 		return true
 	}
 
 	showQuote := false
-	for _, filterStr := range m.cli.Quote.Filter {
-		filter, err := cryptobill.NewCurrencyFromString(filterStr)
-		if err != nil {
-			panic(err)
-		}
+	for _, filter := range m.filter {
 		if filter == quote.Pair.Crypto {
 			showQuote = true
 		}
@@ -261,57 +438,77 @@ var notSupported = []string{
 	"PIVX",
 }
 
-func (m *Main) fetchExchange(result []cryptobill.QuoteResult) (map[cryptobill.Currency]cryptobill.Amount, error) {
+// fetchExchange looks up the reference price of each coin shown. A coin that can't be priced is
+// logged and left out, so it doesn't stop the others being shown.
+func (m *Main) fetchExchange(result []cryptobill.QuoteResult) map[cryptobill.Currency]cryptobill.Amount {
 	lookup := map[cryptobill.Currency]cryptobill.Amount{}
+	tried := map[cryptobill.Currency]bool{}
 
 	for _, quote := range result {
 		if !m.showQuote(quote) {
 			continue
 		}
 
-		if _, ok := lookup[quote.Pair.Crypto]; ok {
+		if tried[quote.Pair.Crypto] {
 			continue
 		}
+		tried[quote.Pair.Crypto] = true
 
-		symbol := quote.Pair.Crypto + quote.Pair.Fiat
-		last, err := bitcoinAverage(m.cb, string(symbol))
+		last, err := m.cb.ReferencePrice(quote.Pair)
 		if err != nil {
-			return nil, err
+			m.cb.Logger.Warn("skipping reference price", "crypto", quote.Pair.Crypto, "fiat", quote.Pair.Fiat, "err", err)
+			continue
 		}
 
-		lookup[quote.Pair.Crypto] = cryptobill.Amount(last)
+		lookup[quote.Pair.Crypto] = last
 	}
 
-	return lookup, nil
+	return lookup
 }
 
-type BitcoinAverageResponse struct {
-	Last float64
+// paymentRecords describes the output of pay.
+// paymentFields describes the output of pay and payments.
+var paymentFields = []Field{
+	{Name: "id"},
+	{Name: "bill"},
+	{Name: "service"},
+	{Name: "status"},
+	{Name: "address"},
+	{Name: "crypto_amount"},
+	{Name: "crypto"},
+	{Name: "fiat_amount", Format: "%.2f"},
+	{Name: "fiat"},
+	{Name: "error"},
+	{Name: "error_kind"},
+	{Name: "created"},
 }
 
-func bitcoinAverage(cb *cryptobill.CryptoBill, symbol string) (float64, error) {
-	req, err := http.NewRequest("GET", "https://apiv2.bitcoinaverage.com/indices/global/ticker/"+symbol, nil)
+func paymentRecords(p *cryptobill.Payment) *Records {
+	records := &Records{Fields: paymentFields, Single: true}
+	records.Add(paymentRow(p))
+	return records
+}
 
-	if err != nil {
-		return 0, errors.Wrap(err, "request builder")
+func paymentRow(p *cryptobill.Payment) map[string]interface{} {
+	row := map[string]interface{}{
+		"id":          p.ID,
+		"bill":        p.Bill,
+		"service":     p.Service,
+		"status":      p.Status,
+		"crypto":      p.Crypto,
+		"fiat_amount": p.FiatAmount,
+		"fiat":        p.Fiat,
+		"created":     p.Created,
 	}
-
-	resp, err := cb.HttpClient.Do(req)
-	if err != nil {
-		return 0, errors.Wrap(err, "server request")
+	if p.Address != "" {
+		row["address"] = p.Address
+		row["crypto_amount"] = p.CryptoAmount
 	}
-
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return 0, errors.Wrap(err, "reading body")
+	if p.Error != "" {
+		row["error"] = p.Error
 	}
-
-	decoded := BitcoinAverageResponse{}
-	err = json.Unmarshal(body, &decoded)
-	if err != nil {
-		return 0, errors.Wrap(err, "decoding body to json: "+string(body))
+	if p.ErrorKind != "" {
+		row["error_kind"] = p.ErrorKind
 	}
-
-	return decoded.Last, nil
+	return row
 }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gak/cryptobill"
+	"github.com/pkg/errors"
+	"os"
+	"strings"
+	"time"
+)
+
+type NotifyCmd struct {
+	Test struct {
+		Event string `default:"alert" help:"Kind of event to send: alert, payment or due."`
+	} `cmd help:"Send a test event to the sinks configured for it in notify.json."`
+}
+
+// notify sends an event, only complaining if it can't. A broken sink shouldn't stop a payment
+// or a long-running watch.
+func (m *Main) notify(e *cryptobill.Event) {
+	err := m.cb.Notify(e)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "notify %v: %v\n", e.Kind, err)
+	}
+}
+
+// waitNotify lets retries still going finish before exiting.
+func (m *Main) waitNotify() {
+	err := m.cb.WaitNotify()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "notify: %v\n", err)
+	}
+}
+
+func (m *Main) notifyTest(kind string) error {
+	known := false
+	for _, k := range cryptobill.EventKinds {
+		known = known || k == kind
+	}
+	if !known {
+		return errors.Errorf("unknown event %q, use one of %v", kind, strings.Join(cryptobill.EventKinds, ", "))
+	}
+
+	e := &cryptobill.Event{
+		Kind:    kind,
+		Title:   "cryptobill test " + kind + " event",
+		Message: "This is a test " + kind + " event from cryptobill.",
+		Time:    time.Now(),
+		Data:    map[string]interface{}{"test": true},
+	}
+
+	err := m.cb.Notify(e)
+	if err == nil {
+		err = m.cb.WaitNotify()
+	}
+	if err != nil {
+		return errors.Wrap(err, "notify")
+	}
+
+	fmt.Println("Sent.")
+	return nil
+}
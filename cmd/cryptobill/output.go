@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var outputFormats = []string{"table", "json", "csv", "tsv"}
+
+// Field is a column of a command's output.
+type Field struct {
+	Name string
+
+	// fmt verb used for table, csv and tsv output. Defaults to %v.
+	Format string
+
+	// Left out of tables unless asked for with --fields. Always in json, csv and tsv.
+	Extra bool
+}
+
+// Records is a command's output, written in the format chosen with --output. Nil values are
+// null in json and empty elsewhere.
+type Records struct {
+	Fields []Field
+	Rows   []map[string]interface{}
+
+	// Written as one json object instead of an array.
+	Single bool
+
+	// One of several batches from a long-running command. Written as JSON Lines, with the csv
+	// header only before the first batch.
+	Stream bool
+}
+
+func (r *Records) Add(row map[string]interface{}) {
+	r.Rows = append(r.Rows, row)
+}
+
+func (m *Main) checkOutput() error {
+	for _, f := range outputFormats {
+		if m.cli.Output == f {
+			return nil
+		}
+	}
+	return errors.Errorf("--output should be one of %v", strings.Join(outputFormats, ", "))
+}
+
+// machineOutput is whether the output is for programs rather than people.
+func (m *Main) machineOutput() bool {
+	return m.cli.Output != "table"
+}
+
+// fields picks the fields to write, in order: those given with --fields, otherwise every field
+// except, in tables, the extra ones.
+func (m *Main) fields(r *Records) ([]Field, error) {
+	if len(m.cli.Fields) == 0 {
+		var fields []Field
+		for _, f := range r.Fields {
+			if !f.Extra || m.machineOutput() {
+				fields = append(fields, f)
+			}
+		}
+		return fields, nil
+	}
+
+	var fields []Field
+	for _, name := range m.cli.Fields {
+		found := false
+		for _, f := range r.Fields {
+			if strings.EqualFold(f.Name, strings.TrimSpace(name)) {
+				fields = append(fields, f)
+				found = true
+			}
+		}
+		if !found {
+			var names []string
+			for _, f := range r.Fields {
+				names = append(names, f.Name)
+			}
+			return nil, errors.Errorf("unknown field %q, use any of %v", name, strings.Join(names, ","))
+		}
+	}
+	return fields, nil
+}
+
+func (m *Main) write(r *Records) error {
+	return m.writeTo(os.Stdout, r)
+}
+
+func (m *Main) writeTo(w io.Writer, r *Records) error {
+	fields, err := m.fields(r)
+	if err != nil {
+		return err
+	}
+
+	switch m.cli.Output {
+	case "json":
+		return writeJSONRecords(w, fields, r)
+	case "csv", "tsv":
+		return m.writeCSVRecords(w, fields, r)
+	}
+	return writeTable(w, fields, r)
+}
+
+func writeTable(w io.Writer, fields []Field, r *Records) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if r.Single {
+		for _, row := range r.Rows {
+			for _, f := range fields {
+				cell := formatValue(f, row[f.Name], true)
+				if cell != "" {
+					label := strings.Replace(f.Name, "_", " ", -1)
+					fmt.Fprintf(tw, "%v:\t%v\n", strings.ToUpper(label[:1])+label[1:], cell)
+				}
+			}
+		}
+		return tw.Flush()
+	}
+
+	var headings []string
+	for _, f := range fields {
+		headings = append(headings, strings.ToUpper(strings.Replace(f.Name, "_", " ", -1)))
+	}
+	fmt.Fprintf(tw, "%v\t\n", strings.Join(headings, "\t"))
+
+	for _, row := range r.Rows {
+		var cells []string
+		for _, f := range fields {
+			cell := formatValue(f, row[f.Name], true)
+			if cell == "" {
+				cell = "-"
+			}
+			cells = append(cells, cell)
+		}
+		fmt.Fprintf(tw, "%v\t\n", strings.Join(cells, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+// Times in tables are shortened to the minute in their own time zone.
+const tableTimeLayout = "2006-01-02 15:04"
+
+func formatValue(f Field, v interface{}, table bool) string {
+	if v == nil {
+		return ""
+	}
+
+	switch t := v.(type) {
+	case time.Time:
+		if t.IsZero() {
+			return ""
+		}
+		if table {
+			return t.Format(tableTimeLayout)
+		}
+		return t.Format(time.RFC3339)
+	case *float64:
+		if t == nil {
+			return ""
+		}
+		v = *t
+	}
+
+	format := f.Format
+	if format == "" {
+		format = "%v"
+	}
+	return fmt.Sprintf(format, v)
+}
+
+// writeJSONRecords keeps the fields in order, which encoding a map wouldn't.
+func writeJSONRecords(w io.Writer, fields []Field, r *Records) error {
+	var objects [][]byte
+	for _, row := range r.Rows {
+		buf := &bytes.Buffer{}
+		buf.WriteString("{")
+		for i, f := range fields {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+
+			value := row[f.Name]
+			if t, ok := value.(time.Time); ok && t.IsZero() {
+				value = nil
+			}
+
+			name, _ := json.Marshal(f.Name)
+			data, err := json.Marshal(value)
+			if err != nil {
+				return errors.Wrap(err, "encode json")
+			}
+			fmt.Fprintf(buf, "%s:%s", name, data)
+		}
+		buf.WriteString("}")
+		objects = append(objects, buf.Bytes())
+	}
+
+	if r.Stream {
+		for _, object := range objects {
+			_, err := fmt.Fprintf(w, "%s\n", object)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	out := &bytes.Buffer{}
+	if r.Single {
+		if len(objects) > 0 {
+			out.Write(objects[0])
+		}
+	} else {
+		out.WriteString("[" + string(bytes.Join(objects, []byte(","))) + "]")
+	}
+
+	pretty := &bytes.Buffer{}
+	err := json.Indent(pretty, out.Bytes(), "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "indent json")
+	}
+	pretty.WriteString("\n")
+
+	_, err = w.Write(pretty.Bytes())
+	return err
+}
+
+func (m *Main) writeCSVRecords(w io.Writer, fields []Field, r *Records) error {
+	cw := csv.NewWriter(w)
+	if m.cli.Output == "tsv" {
+		cw.Comma = '\t'
+	}
+
+	if !r.Stream || !m.wroteHeader {
+		var header []string
+		for _, f := range fields {
+			header = append(header, f.Name)
+		}
+		err := cw.Write(header)
+		if err != nil {
+			return errors.Wrap(err, "write header")
+		}
+		m.wroteHeader = true
+	}
+
+	for _, row := range r.Rows {
+		var record []string
+		for _, f := range fields {
+			record = append(record, formatValue(f, row[f.Name], false))
+		}
+		err := cw.Write(record)
+		if err != nil {
+			return errors.Wrap(err, "write record")
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
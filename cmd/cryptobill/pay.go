@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gak/cryptobill"
+	"github.com/pkg/errors"
+	"golang.org/x/term"
+	"os"
+	"strings"
+)
+
+type Approve struct {
+	ID   string `arg help:"ID of the held payment, from pay or payments."`
+	Auth string `help:"For now only for your PBC email address. Taken from the vault if not given."`
+}
+
+type Payments struct {
+	Held bool `help:"Only show payments waiting for approval."`
+}
+
+type Status struct {
+	ID string `arg help:"ID of the payment, from pay or payments."`
+}
+
+func (m *Main) approve(opts *Approve) error {
+	payment, err := m.cb.Approve(opts.ID, opts.Auth)
+	if payment == nil {
+		return err
+	}
+
+	m.notify(cryptobill.NewPaymentEvent(payment))
+
+	writeErr := m.write(paymentRecords(payment))
+	if err != nil {
+		return err
+	}
+	return writeErr
+}
+
+func (m *Main) payments(held bool) error {
+	payments, err := m.cb.Payments()
+	if err != nil {
+		return err
+	}
+
+	// The error and address are long, so tables leave them out unless asked.
+	fields := make([]Field, len(paymentFields))
+	copy(fields, paymentFields)
+	for i := range fields {
+		switch fields[i].Name {
+		case "address", "error", "error_kind":
+			fields[i].Extra = true
+		}
+	}
+
+	records := &Records{Fields: fields}
+	for _, p := range payments {
+		if held && p.Status != cryptobill.PaymentHeld {
+			continue
+		}
+		records.Add(paymentRow(p))
+	}
+
+	return m.write(records)
+}
+
+func (m *Main) status(id string) error {
+	before, err := m.cb.GetPayment(id)
+	if err != nil {
+		return err
+	}
+
+	payment, err := m.cb.TrackPayment(id)
+	if err != nil {
+		return err
+	}
+	if payment.Status != before.Status {
+		m.notify(cryptobill.NewPaymentEvent(payment))
+	}
+
+	return m.write(paymentRecords(payment))
+}
+
+// confirmPayment shows what the service is about to order and asks whether to go ahead, unless
+// --yes or --dry-run was given.
+func (m *Main) confirmPayment(pay *Pay) func(*cryptobill.PayPreview) error {
+	return func(preview *cryptobill.PayPreview) error {
+		records := previewRecords(preview)
+
+		if pay.DryRun {
+			err := m.write(records)
+			if err != nil {
+				return err
+			}
+			return cryptobill.ErrDryRun
+		}
+		if pay.Yes {
+			return nil
+		}
+
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return errors.Wrap(cryptobill.ErrNotConfirmed, "not a terminal, use --yes to pay without asking")
+		}
+
+		// The preview goes to stderr in other formats so that stdout only has the payment.
+		w := os.Stdout
+		if m.machineOutput() {
+			w = os.Stderr
+		}
+		err := writeTable(w, records.Fields, records)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprint(os.Stderr, "Create this order? [y/N] ")
+		line, err := stdin.ReadString('\n')
+		if err != nil && line == "" {
+			return errors.Wrap(err, "read answer")
+		}
+
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			return nil
+		}
+		return cryptobill.ErrNotConfirmed
+	}
+}
+
+func previewRecords(p *cryptobill.PayPreview) *Records {
+	records := &Records{
+		Fields: []Field{
+			{Name: "service"},
+			{Name: "payee"},
+			{Name: "details"},
+			{Name: "fiat_amount", Format: "%.2f"},
+			{Name: "fiat"},
+			{Name: "crypto_amount", Format: "%.5f"},
+			{Name: "crypto"},
+			{Name: "rate", Format: "%.2f"},
+			{Name: "fee", Format: "%.2f"},
+			{Name: "fee_percent", Format: "%.3f"},
+			{Name: "markup", Format: "%.3f"},
+		},
+		Single: true,
+	}
+
+	row := map[string]interface{}{
+		"service":       p.Service,
+		"details":       p.Details,
+		"fiat_amount":   p.FiatAmount,
+		"fiat":          p.Fiat,
+		"crypto_amount": p.CryptoAmount,
+		"crypto":        p.Crypto,
+		"rate":          p.Rate,
+		"fee":           p.Fee,
+		"fee_percent":   p.FeePercent,
+		"markup":        p.Markup,
+	}
+	if p.Payee != "" {
+		row["payee"] = p.Payee
+	}
+
+	records.Add(row)
+	return records
+}
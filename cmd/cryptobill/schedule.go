@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gak/cryptobill"
+	"github.com/pkg/errors"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type ScheduleCmd struct {
+	Name string `arg`
+
+	Due      string  `help:"First due date, e.g. 2018-11-30."`
+	Every    string  `help:"How often it repeats: weekly, fortnightly, monthly, quarterly, yearly, or e.g. 10d, 2w, 6m. Leave out for a one-off bill."`
+	Amount   float64 `help:"Amount to pay. For variable bills, an estimate used for quotes."`
+	Fiat     string  `default:"AUD" help:"Fiat currency of the amount."`
+	Variable bool    `help:"The amount changes from bill to bill."`
+	Crypto   string  `help:"Only prepare quotes in this cryptocurrency."`
+	Service  string  `help:"Only prepare quotes with this service, e.g. PBC."`
+	LeadDays int     `default:"3" help:"Start preparing quotes this many days before the pay-by time."`
+	Clear    bool    `help:"Remove the schedule instead."`
+}
+
+type PaidCmd struct {
+	Name string `arg`
+	Due  string `help:"Due date of the occurrence that was paid. Defaults to the earliest unpaid one."`
+}
+
+type Due struct {
+	Days int `default:"30" help:"How many days ahead to look."`
+}
+
+type Scheduler struct {
+	Interval time.Duration `default:"1h" help:"How often to check for bills to prepare."`
+	Once     bool          `help:"Check once and exit."`
+}
+
+func (m *Main) billSchedule() error {
+	opts := &m.cli.Bill.Schedule
+	if opts.Clear {
+		return m.cb.SetSchedule(opts.Name, nil)
+	}
+
+	if opts.Due == "" {
+		return errors.New("--due is required")
+	}
+
+	schedule := &cryptobill.Schedule{
+		Due:      opts.Due,
+		Every:    opts.Every,
+		Amount:   cryptobill.Amount(opts.Amount),
+		Variable: opts.Variable,
+		Service:  strings.ToUpper(opts.Service),
+		LeadDays: opts.LeadDays,
+	}
+
+	var err error
+	schedule.Fiat, err = cryptobill.NewCurrencyFromString(opts.Fiat)
+	if err != nil {
+		return err
+	}
+
+	if opts.Crypto != "" {
+		schedule.Crypto, err = cryptobill.NewCurrencyFromString(opts.Crypto)
+		if err != nil {
+			return err
+		}
+	}
+
+	return m.cb.SetSchedule(opts.Name, schedule)
+}
+
+func (m *Main) billPaid() error {
+	opts := &m.cli.Bill.Paid
+
+	if opts.Due != "" {
+		due, err := time.Parse(cryptobill.DateLayout, opts.Due)
+		if err != nil {
+			return errors.Wrap(err, "--due")
+		}
+		return m.cb.MarkPaid(opts.Name, due)
+	}
+
+	upcoming, err := m.cb.Upcoming(time.Now(), prepareWindow)
+	if err != nil {
+		return err
+	}
+
+	for _, due := range upcoming {
+		if due.Bill.Name == opts.Name {
+			err = m.cb.MarkPaid(opts.Name, due.Due)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Marked %v due %v as paid.\n", opts.Name, due.Due.Format(cryptobill.DateLayout))
+			return nil
+		}
+	}
+
+	return errors.New("no unpaid occurrence of " + opts.Name)
+}
+
+// nextDue returns the due date of the earliest unpaid occurrence of a scheduled bill.
+func (m *Main) nextDue(name string) (time.Time, error) {
+	upcoming, err := m.cb.Upcoming(time.Now(), prepareWindow)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	for _, due := range upcoming {
+		if due.Bill.Name == name {
+			return due.Due, nil
+		}
+	}
+
+	return time.Time{}, errors.New("no unpaid occurrence of " + name)
+}
+
+// Looking a year ahead finds the next occurrence of any bill.
+var prepareWindow = 366 * 24 * time.Hour
+
+func (m *Main) due(days int) error {
+	now := time.Now()
+	upcoming, err := m.cb.Upcoming(now, time.Duration(days)*24*time.Hour)
+	if err != nil {
+		return err
+	}
+
+	records := &Records{Fields: []Field{
+		{Name: "name"},
+		{Name: "due"},
+		{Name: "pay_by"},
+		{Name: "late"},
+		{Name: "amount", Format: "%.2f"},
+		{Name: "fiat"},
+		{Name: "variable"},
+		{Name: "type"},
+	}}
+
+	for _, due := range upcoming {
+		schedule := due.Bill.Schedule
+		row := map[string]interface{}{
+			"name":   due.Bill.Name,
+			"due":    due.Due.Format(cryptobill.DateLayout),
+			"pay_by": due.PayBy,
+			"late":   due.PayBy.Before(now),
+			"type":   due.Bill.Type(),
+		}
+		if schedule.Amount != 0 {
+			row["amount"] = schedule.Amount
+			row["fiat"] = schedule.Fiat
+			row["variable"] = schedule.Variable
+		}
+
+		records.Add(row)
+	}
+
+	return m.write(records)
+}
+
+func (m *Main) scheduler(opts *Scheduler) error {
+	// Each occurrence is shown at most once a day, so only today's are kept.
+	prepared := map[string]bool{}
+	day := ""
+
+	for {
+		now := time.Now()
+		if today := now.Format(cryptobill.DateLayout); today != day {
+			prepared = map[string]bool{}
+			day = today
+		}
+
+		quotes, err := m.cb.PrepareQuotes(now)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", now.Format(time.RFC3339), err)
+		}
+
+		for _, pq := range quotes {
+			key := pq.Bill.Name + " " + pq.Due.Format(cryptobill.DateLayout)
+			if prepared[key] {
+				continue
+			}
+			prepared[key] = true
+			m.notify(cryptobill.NewDueEvent(&pq.DueBill))
+
+			if m.machineOutput() {
+				err = m.writePrepared(pq, now)
+			} else {
+				err = m.printPrepared(pq)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if opts.Once {
+			return nil
+		}
+
+		time.Sleep(opts.Interval)
+	}
+}
+
+func (m *Main) printPrepared(pq cryptobill.PreparedQuote) error {
+	schedule := pq.Bill.Schedule
+	fmt.Printf("%v: due %v, pay by %v\n",
+		pq.Bill.Name,
+		pq.Due.Format(cryptobill.DateLayout),
+		pq.PayBy.Format("2006-01-02 15:04 MST"),
+	)
+
+	if schedule.Amount == 0 {
+		fmt.Println("  No amount set, use \"bill schedule --amount\" to get quotes.")
+		return nil
+	}
+	if pq.Err != nil {
+		fmt.Printf("  Couldn't quote: %v\n", strings.Replace(pq.Err.Error(), "\n", "\n  ", -1))
+		return nil
+	}
+	if schedule.Variable {
+		fmt.Printf("  Quoted on an estimate of %.2f %v, check the actual bill.\n", schedule.Amount, schedule.Fiat)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.AlignRight|tabwriter.Debug)
+	for _, quote := range pq.Quotes {
+		payBy, err := m.cb.ServicePayBy(pq.Due, quote.Service.ShortName())
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "  %v\t%v\t%5.5f\t send by %v\t\n",
+			quote.Service.ShortName(),
+			quote.Pair.Crypto,
+			quote.Conversion.Crypto,
+			payBy.Format("2006-01-02 15:04"),
+		)
+	}
+
+	return w.Flush()
+}
+
+// writePrepared writes one record per quote, or one without a quote if there are none.
+func (m *Main) writePrepared(pq cryptobill.PreparedQuote, now time.Time) error {
+	records := &Records{
+		Fields: []Field{
+			{Name: "time"},
+			{Name: "bill"},
+			{Name: "due"},
+			{Name: "pay_by"},
+			{Name: "amount", Format: "%.2f"},
+			{Name: "fiat"},
+			{Name: "variable"},
+			{Name: "service"},
+			{Name: "crypto"},
+			{Name: "crypto_amount", Format: "%.5f"},
+			{Name: "send_by"},
+			{Name: "error"},
+		},
+		Stream: true,
+	}
+
+	schedule := pq.Bill.Schedule
+	base := func() map[string]interface{} {
+		row := map[string]interface{}{
+			"time":   now,
+			"bill":   pq.Bill.Name,
+			"due":    pq.Due.Format(cryptobill.DateLayout),
+			"pay_by": pq.PayBy,
+		}
+		if schedule.Amount != 0 {
+			row["amount"] = schedule.Amount
+			row["fiat"] = schedule.Fiat
+			row["variable"] = schedule.Variable
+		}
+		return row
+	}
+
+	for _, quote := range pq.Quotes {
+		sendBy, err := m.cb.ServicePayBy(pq.Due, quote.Service.ShortName())
+		if err != nil {
+			return err
+		}
+
+		row := base()
+		row["service"] = quote.Service.ShortName()
+		row["crypto"] = quote.Pair.Crypto
+		row["crypto_amount"] = quote.Conversion.Crypto
+		row["send_by"] = sendBy
+		records.Add(row)
+	}
+
+	if len(pq.Quotes) == 0 {
+		row := base()
+		switch {
+		case pq.Err != nil:
+			row["error"] = pq.Err.Error()
+		case schedule.Amount == 0:
+			row["error"] = "no amount set"
+		}
+		records.Add(row)
+	}
+
+	return m.write(records)
+}
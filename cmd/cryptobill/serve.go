@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/gak/cryptobill"
+	"github.com/pkg/errors"
+	"net/http"
+	"os"
+	"time"
+)
+
+type Serve struct {
+	Listen string `default:"127.0.0.1:8080" help:"Address to listen on."`
+	Token  string `env:"CRYPTOBILL_API_TOKEN" help:"Token clients must send as \"Authorization: Bearer <token>\". A random one is made up if not given."`
+}
+
+func (m *Main) serve(opts *Serve) error {
+	token := opts.Token
+	if token == "" {
+		b := make([]byte, 24)
+		_, err := rand.Read(b)
+		if err != nil {
+			return errors.Wrap(err, "random token")
+		}
+		token = hex.EncodeToString(b)
+		fmt.Fprintf(os.Stderr, "API token: %v\n", token)
+	}
+
+	// Nobody is at the terminal to type a passphrase for each request, so a vault needs to be
+	// unlocked with "vault unlock" first.
+	m.cb.Passphrase = nil
+
+	m.cb.Metrics = cryptobill.NewMetrics()
+	api := cryptobill.NewAPIServer(m.cb, token)
+	api.Handle("GET /metrics", m.cb.MetricsHandler())
+
+	server := &http.Server{
+		Addr:              opts.Listen,
+		Handler:           api,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	fmt.Fprintf(os.Stderr, "Serving on http://%v/api/v1\n", opts.Listen)
+	return server.ListenAndServe()
+}
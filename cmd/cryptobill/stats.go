@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gak/cryptobill"
+	"strings"
+	"time"
+)
+
+type Stats struct {
+	By       string   `help:"Also split by hour or weekday."`
+	Days     int      `help:"Only use quotes from this many days back. All of them if not given."`
+	Services []string `help:"Filter by service, e.g. PBC"`
+	Filter   []string `help:"Filter by cryptocurrency, e.g. BTC,ETH"`
+}
+
+func (m *Main) stats(opts *Stats) error {
+	var since time.Time
+	if opts.Days > 0 {
+		since = time.Now().AddDate(0, 0, -opts.Days)
+	}
+
+	records, err := m.cb.ReadHistory(since)
+	if err != nil {
+		return err
+	}
+
+	records = filterRecords(records, opts)
+	if len(records) == 0 && !m.machineOutput() {
+		fmt.Println("No recorded quotes, use \"quote --record\" or \"watch --record\".")
+		return nil
+	}
+
+	stats, err := m.cb.HistoryStats(records, strings.ToLower(opts.By))
+	if err != nil {
+		return err
+	}
+
+	out := &Records{Fields: []Field{{Name: "service"}, {Name: "crypto"}}}
+	if opts.By != "" {
+		out.Fields = append(out.Fields, Field{Name: strings.ToLower(opts.By)})
+	}
+	out.Fields = append(out.Fields,
+		Field{Name: "quotes"},
+		Field{Name: "average", Format: "%.3f"},
+		Field{Name: "median", Format: "%.3f"},
+		Field{Name: "best", Format: "%.3f"},
+	)
+	if opts.By != "" {
+		out.Fields = append(out.Fields, Field{Name: "cheapest"})
+	}
+
+	for _, s := range stats {
+		row := map[string]interface{}{
+			"service": s.Service,
+			"crypto":  s.Crypto,
+			"quotes":  s.Count,
+			"average": s.Mean,
+			"median":  s.Median,
+			"best":    s.Best,
+		}
+		if opts.By != "" {
+			row[strings.ToLower(opts.By)] = s.Group
+			row["cheapest"] = s.Cheapest
+		}
+		out.Add(row)
+	}
+
+	return m.write(out)
+}
+
+func filterRecords(records []cryptobill.QuoteRecord, opts *Stats) []cryptobill.QuoteRecord {
+	var filtered []cryptobill.QuoteRecord
+	for _, r := range records {
+		if matchesAny(opts.Services, r.Service) && matchesAny(opts.Filter, string(r.Crypto)) {
+			filtered = append(filtered, r)
+		}
+	}
+	return filtered
+}
+
+func matchesAny(filters []string, value string) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	for _, f := range filters {
+		if strings.EqualFold(f, value) {
+			return true
+		}
+	}
+	return false
+}
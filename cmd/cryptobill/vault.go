@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"github.com/gak/cryptobill"
+	"github.com/pkg/errors"
+	"golang.org/x/term"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type Vault struct {
+	Init   struct{} `cmd help:"Create an encrypted vault and move your bills into it."`
+	Unlock struct {
+		Timeout time.Duration `default:"15m" help:"How long the key is kept before asking for the passphrase again."`
+	} `cmd help:"Start an agent which remembers the vault key for a while."`
+	Lock  struct{} `cmd help:"Stop the agent so the passphrase is needed again."`
+	Rekey struct{} `cmd help:"Change the vault passphrase."`
+	Auth  struct {
+		Service string `arg help:"Service, e.g. PBC"`
+		Auth    string `arg help:"Your login for the service, e.g. your PBC email address."`
+	} `cmd help:"Store your login for a service so --auth isn't needed."`
+
+	// Run by "vault unlock" in the background, reading the key from stdin.
+	Agent struct {
+		Timeout time.Duration `default:"15m"`
+	} `cmd hidden`
+}
+
+// passphraseEnv lets scripts supply the passphrase without a terminal.
+const passphraseEnv = "CRYPTOBILL_PASSPHRASE"
+
+// Shared so that consecutive prompts don't lose each other's buffered input.
+var stdin = bufio.NewReader(os.Stdin)
+
+func readPassphrase(prompt string) (string, error) {
+	if passphrase, ok := os.LookupEnv(passphraseEnv); ok {
+		return passphrase, nil
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		data, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", errors.Wrap(err, "read passphrase")
+		}
+		return string(data), nil
+	}
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.Wrap(err, "read passphrase")
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func readNewPassphrase() (string, error) {
+	passphrase, err := readPassphrase("New vault passphrase: ")
+	if err != nil {
+		return "", err
+	}
+
+	confirm, err := readPassphrase("Repeat passphrase: ")
+	if err != nil {
+		return "", err
+	}
+
+	if passphrase != confirm {
+		return "", errors.New("passphrases don't match")
+	}
+
+	return passphrase, nil
+}
+
+func (m *Main) vaultInit() error {
+	passphrase, err := readNewPassphrase()
+	if err != nil {
+		return err
+	}
+
+	err = m.cb.InitVault(passphrase)
+	if err != nil {
+		return errors.Wrap(err, "init vault")
+	}
+
+	fmt.Println("Vault created. Your bills are now encrypted.")
+	return nil
+}
+
+func (m *Main) vaultUnlock(timeout time.Duration) error {
+	if m.cb.AgentRunning() {
+		fmt.Println("Vault is already unlocked.")
+		return nil
+	}
+
+	passphrase, err := readPassphrase("Vault passphrase: ")
+	if err != nil {
+		return err
+	}
+
+	key, err := m.cb.UnlockVault(passphrase)
+	if err != nil {
+		return errors.Wrap(err, "unlock vault")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "find executable")
+	}
+
+	args := []string{"vault", "agent", "--timeout", timeout.String()}
+	if m.cli.ConfigDir != "" {
+		args = append([]string{"--config-dir", m.cli.ConfigDir}, args...)
+	}
+
+	cmd := exec.Command(exe, args...)
+	cmd.Stdin = strings.NewReader(hex.EncodeToString(key))
+	err = cmd.Start()
+	if err != nil {
+		return errors.Wrap(err, "start agent")
+	}
+
+	// Wait for the agent to start listening.
+	for i := 0; i < 50 && !m.cb.AgentRunning(); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if !m.cb.AgentRunning() {
+		return errors.New("agent didn't start")
+	}
+
+	fmt.Printf("Vault unlocked for %v.\n", timeout)
+	return cmd.Process.Release()
+}
+
+func (m *Main) vaultAgent(timeout time.Duration) error {
+	// Outlive the terminal that started us.
+	signal.Ignore(syscall.SIGHUP)
+
+	data, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return errors.Wrap(err, "read key")
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return errors.Wrap(err, "decode key")
+	}
+
+	socket, err := m.cb.AgentSocket()
+	if err != nil {
+		return err
+	}
+
+	return cryptobill.ServeAgent(socket, key, timeout)
+}
+
+func (m *Main) vaultRekey() error {
+	old, err := readPassphrase("Current vault passphrase: ")
+	if err != nil {
+		return err
+	}
+
+	passphrase, err := readNewPassphrase()
+	if err != nil {
+		return err
+	}
+
+	err = m.cb.RekeyVault(old, passphrase)
+	if err != nil {
+		return errors.Wrap(err, "rekey vault")
+	}
+
+	fmt.Println("Vault passphrase changed.")
+	return nil
+}
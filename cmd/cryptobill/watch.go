@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gak/cryptobill"
+	"github.com/pkg/errors"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+type WatchCmd struct {
+	Rule      []string      `help:"Alert when a route's markup is low enough, e.g. \"bill=rates crypto=BTC under=3\". Keys are bill, crypto, service and under. Can be repeated."`
+	Amount    float64       `default:"100" help:"Amount to quote for rules without a bill."`
+	Fiat      string        `default:"AUD" help:"Fiat currency of --amount."`
+	Interval  time.Duration `default:"5m" help:"How often to quote."`
+	RateLimit time.Duration `default:"2s" help:"Minimum time between requests to the same site."`
+	Once      bool          `help:"Quote once and exit."`
+	Record    bool          `help:"Keep the quotes in history.jsonl for \"stats\"."`
+	Metrics   string        `help:"Serve Prometheus metrics at /metrics on this address, e.g. 127.0.0.1:9090."`
+}
+
+// watchTarget is an amount to quote, either for a scheduled bill or the watch amount.
+type watchTarget struct {
+	bill string
+	info cryptobill.FiatInfo
+}
+
+func (m *Main) watch(opts *WatchCmd) error {
+	var rules []*cryptobill.AlertRule
+	for _, s := range opts.Rule {
+		rule, err := cryptobill.ParseAlertRule(s)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, rule)
+	}
+
+	targets, err := m.watchTargets(opts, rules)
+	if err != nil {
+		return err
+	}
+
+	if opts.Metrics != "" {
+		err = m.serveMetrics(opts.Metrics)
+		if err != nil {
+			return err
+		}
+	}
+
+	m.cb.HttpClient.Transport = cryptobill.NewRateLimiter(m.cb.HttpClient.Transport, opts.RateLimit)
+	w := cryptobill.NewWatch(rules)
+
+	for {
+		now := time.Now()
+		var alerts []cryptobill.Alert
+		for _, target := range targets {
+			targetAlerts, err := m.watchRound(w, target, now, opts.Record)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v: %v: %v\n", now.Format(time.RFC3339), target.name(), err)
+			}
+			alerts = append(alerts, targetAlerts...)
+		}
+
+		err = m.writeWatchStats(w, alerts, now)
+		if err != nil {
+			return err
+		}
+
+		if opts.Once {
+			return nil
+		}
+
+		time.Sleep(opts.Interval)
+	}
+}
+
+// serveMetrics serves Prometheus metrics in the background while watching.
+func (m *Main) serveMetrics(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Wrap(err, "metrics")
+	}
+
+	m.cb.Metrics = cryptobill.NewMetrics()
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", m.cb.MetricsHandler())
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		err := server.Serve(listener)
+		fmt.Fprintln(os.Stderr, errors.Wrap(err, "metrics"))
+	}()
+
+	fmt.Fprintf(os.Stderr, "Serving metrics on http://%v/metrics\n", listener.Addr())
+	return nil
+}
+
+// watchTargets works out what to quote: each bill named by a rule, plus the watch amount if any
+// rule doesn't name a bill.
+func (m *Main) watchTargets(opts *WatchCmd, rules []*cryptobill.AlertRule) ([]watchTarget, error) {
+	fiat, err := cryptobill.NewCurrencyFromString(opts.Fiat)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []watchTarget
+	seen := map[string]bool{}
+	needAmount := len(rules) == 0
+
+	for _, rule := range rules {
+		if rule.Bill == "" {
+			needAmount = true
+			continue
+		}
+		if seen[rule.Bill] {
+			continue
+		}
+		seen[rule.Bill] = true
+
+		bill, err := m.cb.GetBill(rule.Bill)
+		if err != nil {
+			return nil, errors.Wrap(err, "get bill")
+		}
+		if bill.Schedule == nil || bill.Schedule.Amount == 0 {
+			return nil, errors.New(rule.Bill + " has no amount, use \"bill schedule --amount\"")
+		}
+
+		targets = append(targets, watchTarget{
+			bill: rule.Bill,
+			info: cryptobill.FiatInfo{Amount: bill.Schedule.Amount, Fiat: bill.Schedule.Fiat},
+		})
+	}
+
+	if needAmount {
+		targets = append(targets, watchTarget{
+			info: cryptobill.FiatInfo{Amount: cryptobill.Amount(opts.Amount), Fiat: fiat},
+		})
+	}
+
+	return targets, nil
+}
+
+func (t *watchTarget) name() string {
+	if t.bill != "" {
+		return t.bill
+	}
+	return fmt.Sprintf("%.2f %v", t.info.Amount, t.info.Fiat)
+}
+
+// watchRound quotes one target and returns the alerts that started firing.
+func (m *Main) watchRound(w *cryptobill.Watch, target watchTarget, now time.Time, record bool) ([]cryptobill.Alert, error) {
+	// Some services failing still leaves the others to compare.
+	result, quoteErr := m.cb.Quote(&target.info)
+	if len(result) == 0 {
+		return nil, errors.Wrap(quoteErr, "quote")
+	}
+	if quoteErr != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v: %v\n", now.Format(time.RFC3339), target.name(), quoteErr)
+	}
+
+	var shown []cryptobill.QuoteResult
+	for _, quote := range result {
+		if m.showQuote(quote) {
+			shown = append(shown, quote)
+		}
+	}
+
+	lookup := m.fetchExchange(shown)
+
+	if record {
+		err := m.cb.RecordQuotes(now, result, lookup)
+		if err != nil {
+			return nil, errors.Wrap(err, "record")
+		}
+	}
+
+	var markups []cryptobill.Markup
+	for _, quote := range shown {
+		// Without a reference price there's no markup to alert on.
+		reference, ok := lookup[quote.Pair.Crypto]
+		if !ok {
+			continue
+		}
+		markups = append(markups, cryptobill.NewMarkup(quote, reference))
+	}
+
+	alerts := w.Observe(target.bill, markups, now)
+	for _, alert := range alerts {
+		m.notify(cryptobill.NewAlertEvent(&alert))
+	}
+
+	return alerts, nil
+}
+
+// writeWatchStats writes every route's markup. Tables are preceded by the time and any alerts,
+// while other formats have a record per route with alert set if one fired for it.
+func (m *Main) writeWatchStats(w *cryptobill.Watch, alerts []cryptobill.Alert, now time.Time) error {
+	records := &Records{
+		Fields: []Field{
+			{Name: "time", Extra: true},
+			{Name: "bill"},
+			{Name: "service"},
+			{Name: "crypto"},
+			{Name: "markup", Format: "%.3f"},
+			{Name: "best", Format: "%.3f"},
+			{Name: "worst", Format: "%.3f"},
+			{Name: "alert", Extra: true},
+		},
+		Stream: true,
+	}
+
+	alerted := map[string]bool{}
+	for _, alert := range alerts {
+		q := alert.Markup.Quote
+		alerted[alert.Bill+" "+q.Service.ShortName()+" "+string(q.Pair.Crypto)] = true
+	}
+
+	for _, s := range w.Stats() {
+		row := map[string]interface{}{
+			"time":    now,
+			"service": s.Service,
+			"crypto":  s.Crypto,
+			"markup":  s.Last,
+			"best":    s.Best,
+			"worst":   s.Worst,
+			"alert":   alerted[s.Bill+" "+s.Service+" "+string(s.Crypto)],
+		}
+		if s.Bill != "" {
+			row["bill"] = s.Bill
+		}
+		records.Add(row)
+	}
+
+	if !m.machineOutput() {
+		fmt.Println(now.Format(time.RFC3339))
+		for _, alert := range alerts {
+			fmt.Printf("ALERT %v\n", &alert)
+		}
+	}
+
+	return m.write(records)
+}
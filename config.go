@@ -0,0 +1,64 @@
+package cryptobill
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+)
+
+// ConfigDirEnv overrides the directory cryptobill keeps its files in.
+const ConfigDirEnv = "CRYPTOBILL_CONFIG_DIR"
+
+// BillsPathEnv overrides the location of the bills file.
+const BillsPathEnv = "CRYPTOBILL_BILLS"
+
+// DefaultConfigDir returns $CRYPTOBILL_CONFIG_DIR if set, otherwise "cryptobill" inside the user's
+// config directory, e.g. $XDG_CONFIG_HOME/cryptobill or ~/.config/cryptobill.
+func DefaultConfigDir() (string, error) {
+	if dir := os.Getenv(ConfigDirEnv); dir != "" {
+		return dir, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", errors.Wrap(err, "user config dir")
+	}
+
+	return filepath.Join(dir, "cryptobill"), nil
+}
+
+func (cb *CryptoBill) configDir() (string, error) {
+	if cb.ConfigDir != "" {
+		return cb.ConfigDir, nil
+	}
+
+	return DefaultConfigDir()
+}
+
+// stateDir is where payments and quote history are kept. In the sandbox it's a directory inside
+// the config directory, so that trying things out doesn't mix with real records.
+func (cb *CryptoBill) stateDir() (string, error) {
+	dir, err := cb.configDir()
+	if err != nil || !cb.sandbox {
+		return dir, err
+	}
+
+	return filepath.Join(dir, "sandbox"), nil
+}
+
+func (cb *CryptoBill) billsPath() (string, error) {
+	if cb.BillsPath != "" {
+		return cb.BillsPath, nil
+	}
+
+	if path := os.Getenv(BillsPathEnv); path != "" {
+		return path, nil
+	}
+
+	dir, err := cb.configDir()
+	if err != nil {
+		return "", errors.Wrap(err, "config dir")
+	}
+
+	return filepath.Join(dir, "bills.json"), nil
+}
@@ -0,0 +1,81 @@
+package cryptobill
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNotConfirmed is returned by Confirm when the payment shouldn't go ahead.
+	ErrNotConfirmed = errors.New("payment not confirmed")
+
+	// ErrDryRun is returned by Confirm to stop a payment once it has been previewed.
+	ErrDryRun = errors.New("dry run, no order was created")
+)
+
+// PayPreview is what a service is about to order, after it has filled in the biller or BSB name.
+type PayPreview struct {
+	Service string
+
+	// The biller or BSB name the service found, which should match who you expect to pay.
+	Payee   string
+	Details string
+
+	Fiat         Currency
+	FiatAmount   Amount
+	Crypto       Currency
+	CryptoAmount Amount
+
+	// Fiat per crypto.
+	Rate Amount
+
+	// A fixed charge in fiat, and a percentage of the amount, on top of the rate. Zero when the
+	// service doesn't say.
+	Fee        Amount
+	FeePercent float64
+
+	// Percent over the reference price, or nil if it couldn't be found.
+	Markup *float64
+}
+
+func newBPAYPreview(s Service, bpay *PayBPAY) *PayPreview {
+	return &PayPreview{
+		Service:    s.ShortName(),
+		Payee:      bpay.Name,
+		Details:    fmt.Sprintf("biller %v, ref %v", bpay.Code, bpay.Account),
+		Fiat:       bpay.Fiat,
+		FiatAmount: bpay.Amount,
+		Crypto:     bpay.Crypto,
+	}
+}
+
+func newEFTPreview(s Service, eft *PayEFT) *PayPreview {
+	bill := &Bill{EFT: eft.EFT}
+	return &PayPreview{
+		Service:    s.ShortName(),
+		Payee:      eft.BSBName,
+		Details:    bill.Summary(),
+		Fiat:       eft.Fiat,
+		FiatAmount: eft.Amount,
+		Crypto:     eft.Crypto,
+	}
+}
+
+// confirm is called by services right before they create an order. The markup is filled in from
+// the reference price on the way through.
+func (cb *CryptoBill) confirm(preview *PayPreview) error {
+	if cb.Confirm == nil {
+		return nil
+	}
+
+	if preview.CryptoAmount > 0 {
+		reference, err := cb.ReferencePrice(Pair{preview.Fiat, preview.Crypto})
+		if err == nil && reference > 0 {
+			q := QuoteResult{Conversion: Conversion{preview.FiatAmount, preview.CryptoAmount}}
+			markup := NewMarkup(q, reference).Percent
+			preview.Markup = &markup
+		}
+	}
+
+	return cb.Confirm(preview)
+}
@@ -1,14 +1,68 @@
 package cryptobill
 
 import (
-	"errors"
+	"context"
+	"github.com/pkg/errors"
+	"log/slog"
 	"net/http"
 	"net/http/cookiejar"
-	"strings"
+	"time"
 )
 
 type CryptoBill struct {
 	HttpClient *http.Client
+
+	// Where bills, backups and other state are kept. Defaults to DefaultConfigDir().
+	ConfigDir string
+
+	// Defaults to $CRYPTOBILL_BILLS, then bills.json inside ConfigDir.
+	BillsPath string
+
+	// Keeps bills and payments. If nil, they're kept in files in ConfigDir, or the vault.
+	Store Store
+
+	// Works out pay-by times for scheduled bills. Defaults to NewCalendar().
+	Calendar *Calendar
+
+	// Where events are sent. Defaults to notify.json in ConfigDir.
+	Notifier *Notifier
+
+	// Reference prices for markups. Defaults to BitcoinAverage.
+	Oracle Oracle
+
+	// Converts quotes for services that price in another fiat. Defaults to Frankfurter.
+	FX FXSource
+
+	// Where the library logs what it's doing, such as retries and currencies it skipped. If nil,
+	// nothing is logged.
+	Logger *slog.Logger
+
+	// Collects request latencies, errors, quotes and reference prices for MetricsHandler. If nil,
+	// nothing is collected.
+	Metrics *Metrics
+
+	// What payments and policy limits take as the current time. Defaults to time.Now.
+	Clock func() time.Time
+
+	// Quoted and paid through. See Register.
+	services *registry
+
+	// Cancels the requests of a quote stream. Set by withContext.
+	ctx context.Context
+
+	// Keep payments and quote history apart from the real ones. Set by EnableSandbox.
+	sandbox bool
+
+	// Checked before every payment. Defaults to policy.json in ConfigDir.
+	Policy *Policy
+
+	// Asked with what a service is about to order, just before the order is created. Returning an
+	// error, such as ErrNotConfirmed or ErrDryRun, stops the payment. If nil, payments go ahead.
+	Confirm func(preview *PayPreview) error
+
+	// Asks for the vault passphrase when no agent is holding the key. If nil, a locked vault is an
+	// error.
+	Passphrase func(prompt string) (string, error)
 }
 
 type Service interface {
@@ -20,12 +74,6 @@ type Service interface {
 	PayEFT(cb *CryptoBill, eft *PayEFT) (*PayResult, error)
 }
 
-var Services = []Service{
-	NewLivingRoom(),
-	NewPaidByCoins(),
-	NewBit2Bill(),
-}
-
 type Amount float64
 
 type Pair struct {
@@ -40,6 +88,9 @@ type QuoteResult struct {
 	Service    Service
 	Pair       Pair
 	Conversion Conversion
+
+	// Set when the service priced in another fiat, and the quote was converted.
+	FX *FXConversion
 }
 
 type PayResult struct {
@@ -61,7 +112,7 @@ type PayInfoService struct {
 	PayInfo
 	Service string `arg help:"Service, e.g. PBC"`
 
-	Auth string `required help:"For now only for your PBC email address."`
+	Auth string `help:"For now only for your PBC email address. Taken from the vault if not given."`
 }
 
 type PayBPAY struct {
@@ -90,33 +141,163 @@ type EFT struct {
 	Remitter      string `help:"Shown on the receiving bank statement."`
 }
 
-func NewCryptoBill() *CryptoBill {
+// NewCryptoBill returns a CryptoBill with DefaultServices, changed by any options.
+func NewCryptoBill(opts ...Option) (*CryptoBill, error) {
 	jar, err := cookiejar.New(nil)
 	if err != nil {
-		panic(err)
+		return nil, errors.Wrap(err, "cookie jar")
 	}
 
-	return &CryptoBill{
-		HttpClient: &http.Client{Jar: jar},
+	cb := &CryptoBill{
+		HttpClient: &http.Client{Jar: jar, Transport: NewProviderTransport(nil)},
+		services:   &registry{},
 	}
-}
 
-func (cb *CryptoBill) PayBPAY(bpay *PayBPAY) (*PayResult, error) {
-	for _, s := range Services {
-		if strings.EqualFold(s.ShortName(), bpay.Service) {
-			return s.PayBPAY(cb, bpay)
+	err = cb.setServices(DefaultServices())
+	if err != nil {
+		return nil, errors.Wrap(err, "default services")
+	}
+
+	for _, opt := range opts {
+		err = opt(cb)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	return nil, errors.New("unknown service: " + bpay.Service)
+	if pt, ok := cb.HttpClient.Transport.(*ProviderTransport); ok && pt.Logger == nil {
+		pt.Logger = cb.Logger
+	}
+	return cb, nil
 }
 
-func (cb *CryptoBill) PayEFT(eft *PayEFT) (*PayResult, error) {
-	for _, s := range Services {
-		if strings.EqualFold(s.ShortName(), eft.Service) {
-			return s.PayEFT(cb, eft)
-		}
+var discardLogger = slog.New(slog.DiscardHandler)
+
+func (cb *CryptoBill) log() *slog.Logger {
+	if cb.Logger != nil {
+		return cb.Logger
+	}
+	return discardLogger
+}
+
+// withContext returns a copy of cb whose requests are cancelled when ctx is done. Everything else,
+// including the services, is shared with cb.
+func (cb *CryptoBill) withContext(ctx context.Context) *CryptoBill {
+	if ctx.Done() == nil {
+		return cb
+	}
+
+	scoped := *cb
+	client := *cb.HttpClient
+	client.Transport = &contextTransport{ctx: ctx, next: client.Transport}
+	scoped.HttpClient = &client
+	scoped.ctx = ctx
+	return &scoped
+}
+
+func (cb *CryptoBill) context() context.Context {
+	if cb.ctx != nil {
+		return cb.ctx
+	}
+	return context.Background()
+}
+
+// contextTransport sends every request with ctx, so they all stop when it's done.
+type contextTransport struct {
+	ctx  context.Context
+	next http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return next.RoundTrip(req.WithContext(t.ctx))
+}
+
+func (cb *CryptoBill) now() time.Time {
+	if cb.Clock != nil {
+		return cb.Clock()
+	}
+	return time.Now()
+}
+
+// fillAuth falls back to the credentials in the vault when no auth was given.
+func (cb *CryptoBill) fillAuth(s Service, info *PayInfoService) error {
+	if info.Auth != "" {
+		return nil
+	}
+	if cb.sandbox {
+		// The fakes don't check it, so there's no need to unlock the vault.
+		info.Auth = "sandbox"
+		return nil
+	}
+
+	auth, err := cb.Credential(s.ShortName())
+	if err != nil {
+		return errors.Wrap(err, "credential")
+	}
+	if auth == "" {
+		return newProviderError(AuthRequired, s.ShortName(), "auth", "no auth given, use --auth or \"vault auth\"")
+	}
+
+	info.Auth = auth
+	return nil
+}
+
+// checkPayFiat stops a payment in a fiat the service doesn't pay in. Unlike quotes, payments aren't
+// converted, since the bill has to be paid in its own currency.
+func checkPayFiat(s Service, fiat Currency) error {
+	if !pricesIn(s, fiat) {
+		return newProviderError(Unsupported, s.ShortName(), "fiat", "doesn't pay in %v", fiat)
+	}
+	return nil
+}
+
+// payBPAY asks the service to pay. Only Pay and Approve call it, after checking the policy.
+func (cb *CryptoBill) payBPAY(bpay *PayBPAY) (*PayResult, error) {
+	s, err := cb.Service(bpay.Service)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkPayFiat(s, bpay.Fiat)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cb.fillAuth(s, &bpay.PayInfoService)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	result, err := s.PayBPAY(cb, bpay)
+	err = classify(s.ShortName(), err)
+	cb.observe(s.ShortName(), "pay", start, err)
+	return result, err
+}
+
+func (cb *CryptoBill) payEFT(eft *PayEFT) (*PayResult, error) {
+	s, err := cb.Service(eft.Service)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkPayFiat(s, eft.Fiat)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cb.fillAuth(s, &eft.PayInfoService)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, errors.New("unknown service: " + eft.Service)
+	start := time.Now()
+	result, err := s.PayEFT(cb, eft)
+	err = classify(s.ShortName(), err)
+	cb.observe(s.ShortName(), "pay", start, err)
+	return result, err
 }
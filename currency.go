@@ -9,6 +9,15 @@ type Currency string
 
 var Currencies = map[string]Currency{
 	"AUD": Currency("AUD"),
+	"USD": Currency("USD"),
+	"EUR": Currency("EUR"),
+	"GBP": Currency("GBP"),
+	"NZD": Currency("NZD"),
+	"CAD": Currency("CAD"),
+	"JPY": Currency("JPY"),
+	"SGD": Currency("SGD"),
+	"HKD": Currency("HKD"),
+	"CHF": Currency("CHF"),
 
 	"BTC":       Currency("BTC"),
 	"ETH":       Currency("ETH"),
@@ -30,6 +39,16 @@ var Currencies = map[string]Currency{
 	"OMG":       Currency("OMG"),
 }
 
+// The Currencies that are fiat rather than crypto.
+var fiats = map[Currency]bool{
+	"AUD": true, "USD": true, "EUR": true, "GBP": true, "NZD": true,
+	"CAD": true, "JPY": true, "SGD": true, "HKD": true, "CHF": true,
+}
+
+func (c Currency) IsFiat() bool {
+	return fiats[c]
+}
+
 func NewCurrencyFromString(s string) (Currency, error) {
 	s = strings.ToUpper(s)
 
@@ -0,0 +1,73 @@
+package cryptobill
+
+import (
+	"fmt"
+	"github.com/hashicorp/go-multierror"
+	"strings"
+)
+
+// BillProblem is something wrong with a stored bill found by Doctor.
+type BillProblem struct {
+	// The key the bill is stored under.
+	Key     string
+	Problem string
+
+	// Whether Doctor can repair it. Other problems need the bill to be edited or removed by hand.
+	Fixable bool
+}
+
+// Doctor checks the stored bills for problems, repairing what it can if fix is set. Loading the
+// bills also runs any pending migrations, so an old file is upgraded either way.
+func (cb *CryptoBill) Doctor(fix bool) ([]BillProblem, error) {
+	var problems []BillProblem
+	err := cb.withBills(fix, func(entries Bills) (Bills, error) {
+		problems = diagnoseBills(entries, fix)
+		return entries, nil
+	})
+
+	return problems, err
+}
+
+func diagnoseBills(entries Bills, fix bool) []BillProblem {
+	var problems []BillProblem
+	report := func(key, problem string, fixable bool) {
+		problems = append(problems, BillProblem{Key: key, Problem: problem, Fixable: fixable})
+	}
+
+	for _, key := range entries.Names() {
+		bill := entries[key]
+
+		if bill == nil {
+			report(key, "entry is empty", true)
+			if fix {
+				delete(entries, key)
+			}
+			continue
+		}
+
+		if strings.TrimSpace(key) == "" {
+			report(key, "stored without a name", false)
+			continue
+		}
+
+		if bill.Name != key {
+			report(key, fmt.Sprintf("name is %q but it is stored as %q", bill.Name, key), true)
+			if fix {
+				bill.Name = key
+			}
+		}
+
+		// The name was checked above. Check digits aren't, as they are only a guess at what the
+		// biller uses.
+		named := *bill
+		named.Name = key
+		err := named.Validate(false)
+		if merr, ok := err.(*multierror.Error); ok {
+			for _, e := range merr.Errors {
+				report(key, e.Error(), false)
+			}
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,169 @@
+package cryptobill
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// ErrorKind says what sort of problem a service had, so callers can decide what to do about it.
+type ErrorKind string
+
+const (
+	// The service couldn't be reached or is failing. Trying again later may work.
+	Unavailable ErrorKind = "unavailable"
+
+	// The service turned down something given to it, such as the biller code. Field says what.
+	RejectedInput ErrorKind = "rejected_input"
+
+	// Credentials are missing or weren't accepted.
+	AuthRequired ErrorKind = "auth_required"
+
+	// The quoted rate ran out before the order was made. Quote again.
+	RateExpired ErrorKind = "rate_expired"
+
+	// The service doesn't take this coin or pay this way, e.g. EFT.
+	Unsupported ErrorKind = "unsupported"
+
+	// The amount is under the service's minimum or over its maximum.
+	AmountOutOfRange ErrorKind = "amount_out_of_range"
+)
+
+// ProviderError is a failure of a service, or of a request it was given.
+type ProviderError struct {
+	Kind    ErrorKind
+	Service string
+
+	// What the service didn't like, e.g. "code", "bsb", "crypto" or "amount". May be empty.
+	Field string
+
+	// Why, often as the service put it.
+	Message string
+
+	// What went wrong underneath, if anything.
+	Err error
+}
+
+func (e *ProviderError) Error() string {
+	s := string(e.Kind)
+	if e.Field != "" {
+		s += " " + e.Field
+	}
+	if e.Service != "" {
+		s = e.Service + ": " + s
+	}
+
+	switch {
+	case e.Message != "":
+		return s + ": " + e.Message
+	case e.Err != nil:
+		return s + ": " + e.Err.Error()
+	}
+	return s
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+func newProviderError(kind ErrorKind, service, field, format string, args ...interface{}) *ProviderError {
+	return &ProviderError{Kind: kind, Service: service, Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+// AsProviderError finds a *ProviderError in the chain of wrapped errors.
+func AsProviderError(err error) (*ProviderError, bool) {
+	for err != nil {
+		if perr, ok := err.(*ProviderError); ok {
+			return perr, true
+		}
+		err = unwrap(err)
+	}
+	return nil, false
+}
+
+// unwrap goes through both pkg/errors causes and standard library wrapping, such as *url.Error.
+func unwrap(err error) error {
+	switch e := err.(type) {
+	case interface{ Cause() error }:
+		return e.Cause()
+	case interface{ Unwrap() error }:
+		return e.Unwrap()
+	}
+	return nil
+}
+
+// classify makes a service's error into a *ProviderError when it can tell what kind it is, such as
+// an HTTP status or a network failure. Anything else is returned as it was.
+func classify(service string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if perr, ok := AsProviderError(err); ok {
+		if perr.Service == "" {
+			perr.Service = service
+		}
+		return err
+	}
+
+	// A *url.Error from the client may be wrapping an *HTTPError from the transport, which says more.
+	network := false
+	for e := err; e != nil; e = unwrap(e) {
+		switch e := e.(type) {
+		case *HTTPError:
+			return &ProviderError{Kind: statusKind(e.StatusCode), Service: service, Err: err}
+		case *CircuitOpenError:
+			return &ProviderError{Kind: Unavailable, Service: service, Err: err}
+		case *url.Error, net.Error:
+			network = true
+		}
+	}
+	if network {
+		return &ProviderError{Kind: Unavailable, Service: service, Err: err}
+	}
+	return err
+}
+
+func statusKind(status int) ErrorKind {
+	switch {
+	case status == 401 || status == 403:
+		return AuthRequired
+	case status == 408 || status == 429 || status >= 500:
+		return Unavailable
+	}
+	return RejectedInput
+}
+
+// messageKinds are words services use in their messages, and what they mean. The first match wins.
+var messageKinds = []struct {
+	words []string
+	kind  ErrorKind
+	field string
+}{
+	// Before the rest, so that "session expired, please login" asks for a login rather than a quote.
+	{[]string{"email", "verif", "pin", "login", "unauthori", "session"}, AuthRequired, ""},
+	{[]string{"temporarily", "unavailable", "rate limit", "too many requests", "try again later", "maintenance", "timed out"}, Unavailable, ""},
+	{[]string{"expired"}, RateExpired, ""},
+	{[]string{"minimum", "maximum", "too small", "too large", "exceed", "limit"}, AmountOutOfRange, "amount"},
+	{[]string{"not supported", "unsupported", "not available"}, Unsupported, ""},
+	{[]string{"biller"}, RejectedInput, "code"},
+	{[]string{"reference", "crn"}, RejectedInput, "account"},
+	{[]string{"bsb"}, RejectedInput, "bsb"},
+	{[]string{"account"}, RejectedInput, "account_number"},
+	{[]string{"currency", "coin"}, Unsupported, "crypto"},
+	{[]string{"amount"}, AmountOutOfRange, "amount"},
+}
+
+// messageError guesses the kind of error from a message a service sent back, such as PBC's
+// Message field. One that can't be placed is taken as the service turning the request down.
+func messageError(service, message string) *ProviderError {
+	lower := strings.ToLower(message)
+	for _, mk := range messageKinds {
+		for _, word := range mk.words {
+			if strings.Contains(lower, word) {
+				return &ProviderError{Kind: mk.kind, Service: service, Field: mk.field, Message: message}
+			}
+		}
+	}
+	return &ProviderError{Kind: RejectedInput, Service: service, Message: message}
+}
@@ -0,0 +1,75 @@
+package cryptobill
+
+import (
+	"github.com/pkg/errors"
+	"net/http"
+	"testing"
+)
+
+func assertKind(t *testing.T, err error, kind ErrorKind, field string) {
+	t.Helper()
+
+	perr, ok := AsProviderError(err)
+	if !ok {
+		t.Fatalf("%v isn't a provider error", err)
+	}
+	if perr.Kind != kind || perr.Field != field {
+		t.Errorf("%v is %v %q, want %v %q", err, perr.Kind, perr.Field, kind, field)
+	}
+}
+
+func TestMessageError(t *testing.T) {
+	for _, tc := range []struct {
+		message string
+		kind    ErrorKind
+		field   string
+	}{
+		{"Invalid Biller Code", RejectedInput, "code"},
+		{"Invalid BSB number", RejectedInput, "bsb"},
+		{"The customer reference number is not valid", RejectedInput, "account"},
+		{"Quote has expired, please try again", RateExpired, ""},
+		{"Amount is below the minimum of $20", AmountOutOfRange, "amount"},
+		{"Please verify your email address", AuthRequired, ""},
+		{"Currency not supported", Unsupported, ""},
+		{"Service temporarily unavailable", Unavailable, ""},
+		{"Session expired, please login again", AuthRequired, ""},
+		{"Your login has expired", AuthRequired, ""},
+		{"Request timed out", Unavailable, ""},
+		{"Connection to the exchange timed out", Unavailable, ""},
+		{"Rate expired", RateExpired, ""},
+		{"Something went wrong", RejectedInput, ""},
+	} {
+		assertKind(t, messageError("PBC", tc.message), tc.kind, tc.field)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	server, _ := flakyServer(t, 503, 401)
+	transport, _ := testTransport()
+	transport.Retries = 0
+	client := &http.Client{Transport: transport}
+
+	_, err := client.Get(server.URL)
+	err = classify("LROS", errors.Wrap(err, "lros request"))
+	assertKind(t, err, Unavailable, "")
+	if perr, _ := AsProviderError(err); perr.Service != "LROS" {
+		t.Errorf("service = %v, want LROS", perr.Service)
+	}
+
+	_, err = client.Get(server.URL)
+	assertKind(t, classify("LROS", err), AuthRequired, "")
+
+	_, err = client.Get("http://127.0.0.1:1/")
+	assertKind(t, classify("LROS", err), Unavailable, "")
+
+	// Errors that aren't from the service are left alone.
+	if err := classify("PBC", ErrNotConfirmed); err != ErrNotConfirmed {
+		t.Errorf("classify changed %v", err)
+	}
+
+	// Ones that already have a kind get the service filled in.
+	err = classify("PBC", errors.Wrap(newProviderError(RateExpired, "", "", "too slow"), "pay"))
+	if perr, _ := AsProviderError(err); perr.Service != "PBC" || perr.Kind != RateExpired {
+		t.Errorf("got %v, want PBC's rate expired", err)
+	}
+}
@@ -0,0 +1,205 @@
+package cryptobill
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// FiatPricer is implemented by services that say which fiat currencies they price in. Quotes in
+// any other fiat are converted through CryptoBill.FX first. Services that don't implement it price
+// in AUD.
+type FiatPricer interface {
+	// Fiats returns nil if the service takes any fiat, and only quotes pairs it really has.
+	Fiats() []Currency
+}
+
+// FXSource gives exchange rates between fiat currencies.
+type FXSource interface {
+	// FXRate is how much of to one unit of from buys.
+	FXRate(cb *CryptoBill, from, to Currency) (Amount, error)
+}
+
+// FXConversion is set on a quote made in another fiat and converted.
+type FXConversion struct {
+	// The fiat the service priced in, and the amount of it that was quoted.
+	Fiat   Currency
+	Amount Amount
+
+	// How much of Fiat one unit of the asked fiat buys.
+	Rate Amount
+}
+
+// Frankfurter is the default FXSource, using the European Central Bank's daily rates from
+// frankfurter.app.
+type Frankfurter struct{}
+
+type FrankfurterResponse struct {
+	Rates map[string]float64
+}
+
+func (Frankfurter) FXRate(cb *CryptoBill, from, to Currency) (Amount, error) {
+	url := "https://api.frankfurter.app/latest?from=" + string(from) + "&to=" + string(to)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "request builder")
+	}
+
+	resp, err := cb.HttpClient.Do(req)
+	if err != nil {
+		return 0, errors.Wrap(err, "server request")
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, errors.Wrap(err, "reading body")
+	}
+
+	decoded := FrankfurterResponse{}
+	err = json.Unmarshal(body, &decoded)
+	if err != nil {
+		return 0, errors.Wrap(err, "decoding body to json: "+string(body))
+	}
+
+	rate, ok := decoded.Rates[string(to)]
+	if !ok || rate <= 0 {
+		return 0, errors.Errorf("no rate from %v to %v", from, to)
+	}
+	return Amount(rate), nil
+}
+
+// FixedFX gives the same exchange rates every time.
+type FixedFX struct {
+	Base Currency
+
+	// How much of each currency one unit of Base buys.
+	Rates map[Currency]Amount
+}
+
+func (fx *FixedFX) FXRate(cb *CryptoBill, from, to Currency) (Amount, error) {
+	rate := func(c Currency) (Amount, bool) {
+		if c == fx.Base {
+			return 1, true
+		}
+		r, ok := fx.Rates[c]
+		return r, ok && r > 0
+	}
+
+	fromRate, ok := rate(from)
+	if !ok {
+		return 0, errors.Errorf("no fixed rate for %v", from)
+	}
+	toRate, ok := rate(to)
+	if !ok {
+		return 0, errors.Errorf("no fixed rate for %v", to)
+	}
+	return toRate / fromRate, nil
+}
+
+// FXRate is how much of to one unit of from buys.
+func (cb *CryptoBill) FXRate(from, to Currency) (Amount, error) {
+	if from == to {
+		return 1, nil
+	}
+
+	fx := cb.FX
+	if fx == nil {
+		fx = Frankfurter{}
+	}
+
+	start := time.Now()
+	rate, err := fx.FXRate(cb, from, to)
+	cb.observe("fx", "rate", start, err)
+	return rate, err
+}
+
+// serviceFiats is what s prices in, or empty if it takes any fiat.
+func serviceFiats(s Service) []Currency {
+	if pricer, ok := s.(FiatPricer); ok {
+		return pricer.Fiats()
+	}
+	return []Currency{"AUD"}
+}
+
+// pricesIn is whether s can quote and pay in fiat without converting.
+func pricesIn(s Service, fiat Currency) bool {
+	fiats := serviceFiats(s)
+	if len(fiats) == 0 {
+		return true
+	}
+	for _, f := range fiats {
+		if f == fiat {
+			return true
+		}
+	}
+	return false
+}
+
+// quoteIn quotes s in info.Fiat, converting through cb.FX when s prices in another fiat.
+func (cb *CryptoBill) quoteIn(s Service, info *FiatInfo) ([]QuoteResult, error) {
+	if !info.Fiat.IsFiat() {
+		return nil, newProviderError(Unsupported, s.ShortName(), "fiat", "%v isn't a fiat currency", info.Fiat)
+	}
+
+	if pricesIn(s, info.Fiat) {
+		quotes, err := s.Quote(cb, info)
+		if err != nil {
+			return nil, err
+		}
+		return onlyFiat(s, quotes, info.Fiat)
+	}
+
+	base := serviceFiats(s)[0]
+	rate, err := cb.FXRate(info.Fiat, base)
+	if err != nil {
+		// Only the FX source being down is worth trying again.
+		err = classify(s.ShortName(), errors.Wrapf(err, "fx %v to %v", info.Fiat, base))
+		if perr, ok := AsProviderError(err); ok && perr.Kind == Unavailable {
+			return nil, err
+		}
+		return nil, &ProviderError{
+			Kind:    Unsupported,
+			Service: s.ShortName(),
+			Field:   "fiat",
+			Message: fmt.Sprintf("prices in %v, and %v couldn't be converted", base, info.Fiat),
+			Err:     err,
+		}
+	}
+
+	converted := &FiatInfo{Amount: info.Amount * rate, Fiat: base}
+	quotes, err := s.Quote(cb, converted)
+	if err != nil {
+		return nil, err
+	}
+	quotes, err = onlyFiat(s, quotes, base)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range quotes {
+		quotes[i].Pair.Fiat = info.Fiat
+		quotes[i].Conversion.Fiat = info.Amount
+		quotes[i].FX = &FXConversion{Fiat: base, Amount: converted.Amount, Rate: rate}
+	}
+	cb.log().Debug("converted quote", "service", s.ShortName(), "from", info.Fiat, "to", base, "rate", rate)
+	return quotes, nil
+}
+
+// onlyFiat drops quotes in other fiats, and fails if that leaves none of several.
+func onlyFiat(s Service, quotes []QuoteResult, fiat Currency) ([]QuoteResult, error) {
+	var kept []QuoteResult
+	for _, q := range quotes {
+		if q.Pair.Fiat == fiat {
+			kept = append(kept, q)
+		}
+	}
+
+	if len(kept) == 0 && len(quotes) > 0 {
+		return nil, newProviderError(Unsupported, s.ShortName(), "fiat", "no quotes in %v", fiat)
+	}
+	return kept, nil
+}
@@ -0,0 +1,73 @@
+package cryptobill
+
+import (
+	"testing"
+)
+
+func TestFixedFX(t *testing.T) {
+	fx := &FixedFX{Base: "AUD", Rates: map[Currency]Amount{"USD": 0.5, "EUR": 0.4}}
+	cb := testCryptoBill(t, WithFX(fx))
+
+	for _, tc := range []struct {
+		from, to Currency
+		want     Amount
+	}{
+		{"AUD", "USD", 0.5},
+		{"USD", "AUD", 2},
+		{"USD", "EUR", 0.8},
+		{"EUR", "EUR", 1},
+	} {
+		rate, err := cb.FXRate(tc.from, tc.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertAmount(t, string(tc.from)+" to "+string(tc.to), rate, tc.want)
+	}
+
+	_, err := cb.FXRate("AUD", "NZD")
+	if err == nil {
+		t.Error("expected no rate for NZD")
+	}
+}
+
+func TestQuoteConvertsFiat(t *testing.T) {
+	cb, _ := replayCryptoBill(t, "bit2bill_quote")
+	cb.FX = &FixedFX{Base: "AUD", Rates: map[Currency]Amount{"USD": 0.5}}
+	info := FiatInfo{Amount: 100, Fiat: "USD"}
+
+	results, err := cb.quote(NewBit2Bill(), &info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	amounts := quoteAmounts(t, results, "B2B", info)
+	assertAmount(t, "BTC", amounts["BTC"], 200/9012.37)
+	for _, r := range results {
+		if r.FX == nil || r.FX.Fiat != "AUD" || r.FX.Amount != 200 || r.FX.Rate != 2 {
+			t.Errorf("%v FX = %+v", r.Pair.Crypto, r.FX)
+		}
+	}
+}
+
+func TestQuoteUnsupportedFiat(t *testing.T) {
+	cb := testCryptoBill(t, WithFX(&FixedFX{Base: "AUD", Rates: map[Currency]Amount{"USD": 0.5}}))
+
+	// Asked directly, the service says so rather than labelling AUD rates as USD.
+	_, err := NewBit2Bill().Quote(cb, &FiatInfo{Amount: 100, Fiat: "USD"})
+	assertKind(t, err, Unsupported, "fiat")
+
+	// No rate to convert with.
+	_, err = cb.quote(NewBit2Bill(), &FiatInfo{Amount: 100, Fiat: "NZD"})
+	assertKind(t, err, Unsupported, "fiat")
+
+	_, err = cb.quote(NewBit2Bill(), &FiatInfo{Amount: 100, Fiat: "BTC"})
+	assertKind(t, err, Unsupported, "fiat")
+
+	// Payments aren't converted.
+	_, err = cb.payBPAY(&PayBPAY{PayInfoService: PayInfoService{
+		PayInfo: PayInfo{FiatInfo: FiatInfo{Amount: 100, Fiat: "USD"}, Crypto: "BTC"},
+		Service: "PBC",
+		Auth:    "me@example.com",
+	}})
+	assertKind(t, err, Unsupported, "fiat")
+}
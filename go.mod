@@ -1,5 +1,7 @@
 module github.com/gak/cryptobill
 
+go 1.26.0
+
 require (
 	github.com/alecthomas/kong v0.0.0-20180814110558-ebe508cf4619
 	github.com/alecthomas/repr v0.0.0-20180920225502-7ed41413b477
@@ -9,4 +11,12 @@ require (
 	github.com/pkg/errors v0.8.0
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/stretchr/testify v1.2.2 // indirect
+	golang.org/x/crypto v0.57.0
+	golang.org/x/term v0.46.0
+	gopkg.in/yaml.v2 v2.4.0
+)
+
+require (
+	github.com/hashicorp/errwrap v1.0.0 // indirect
+	golang.org/x/sys v0.48.0 // indirect
 )
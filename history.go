@@ -0,0 +1,263 @@
+package cryptobill
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// QuoteRecord is a quote kept in the history file.
+type QuoteRecord struct {
+	Time         time.Time `json:"time"`
+	Service      string    `json:"service"`
+	Crypto       Currency  `json:"crypto"`
+	Fiat         Currency  `json:"fiat"`
+	FiatAmount   Amount    `json:"fiatAmount"`
+	CryptoAmount Amount    `json:"cryptoAmount"`
+
+	// Fiat price of one unit of the crypto at the time, if it was looked up.
+	Reference Amount `json:"reference,omitempty"`
+}
+
+// Markup is the percentage over the reference price, or false if there isn't one.
+func (r *QuoteRecord) Markup() (float64, bool) {
+	if r.Reference == 0 || r.FiatAmount == 0 {
+		return 0, false
+	}
+	return float64(r.Reference*r.CryptoAmount/r.FiatAmount)*100 - 100, true
+}
+
+func (cb *CryptoBill) historyPath() (string, error) {
+	dir, err := cb.stateDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "history.jsonl"), nil
+}
+
+// RecordQuotes appends quotes to the history file, one JSON object per line. reference holds the
+// fiat price of each crypto and may be nil.
+func (cb *CryptoBill) RecordQuotes(at time.Time, results []QuoteResult, reference map[Currency]Amount) error {
+	path, err := cb.historyPath()
+	if err != nil {
+		return errors.Wrap(err, "history path")
+	}
+
+	var lines []byte
+	for _, q := range results {
+		line, err := json.Marshal(&QuoteRecord{
+			Time:         at,
+			Service:      q.Service.ShortName(),
+			Crypto:       q.Pair.Crypto,
+			Fiat:         q.Pair.Fiat,
+			FiatAmount:   q.Conversion.Fiat,
+			CryptoAmount: q.Conversion.Crypto,
+			Reference:    reference[q.Pair.Crypto],
+		})
+		if err != nil {
+			return errors.Wrap(err, "encode json")
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return errors.Wrap(err, "mkdir")
+	}
+
+	unlock, err := lockPath(path)
+	if err != nil {
+		return errors.Wrap(err, "lock history")
+	}
+	defer unlock()
+
+	fp, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.Wrap(err, "open history")
+	}
+
+	_, err = fp.Write(lines)
+	if err != nil {
+		fp.Close()
+		return errors.Wrap(err, "write history")
+	}
+
+	return errors.Wrap(fp.Close(), "close history")
+}
+
+// ReadHistory returns the recorded quotes from since onwards. A zero since returns everything.
+func (cb *CryptoBill) ReadHistory(since time.Time) ([]QuoteRecord, error) {
+	path, err := cb.historyPath()
+	if err != nil {
+		return nil, errors.Wrap(err, "history path")
+	}
+
+	fp, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "open history")
+	}
+	defer fp.Close()
+
+	var records []QuoteRecord
+	scanner := bufio.NewScanner(fp)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		record := QuoteRecord{}
+		err = json.Unmarshal(line, &record)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%v line %v", path, n)
+		}
+
+		if record.Time.Before(since) {
+			continue
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "read history")
+	}
+
+	return records, nil
+}
+
+// Ways of splitting up markup stats besides service and coin.
+const (
+	GroupNone    = ""
+	GroupHour    = "hour"
+	GroupWeekday = "weekday"
+)
+
+// MarkupStats summarises the markups of one service and coin, optionally within an hour of the
+// day or a weekday.
+type MarkupStats struct {
+	Service string
+	Crypto  Currency
+	Group   string
+
+	Count              int
+	Mean, Median, Best float64
+
+	// Set on the group with the lowest mean for the service and coin.
+	Cheapest bool
+
+	order int
+}
+
+// HistoryStats works out markup stats from records, skipping those without a reference price.
+// Hours and weekdays are in loc.
+func HistoryStats(records []QuoteRecord, by string, loc *time.Location) ([]MarkupStats, error) {
+	if by != GroupNone && by != GroupHour && by != GroupWeekday {
+		return nil, errors.Errorf("can't group by %q, use %v or %v", by, GroupHour, GroupWeekday)
+	}
+
+	type key struct {
+		service string
+		crypto  Currency
+		group   string
+		order   int
+	}
+	markups := map[key][]float64{}
+
+	for _, r := range records {
+		markup, ok := r.Markup()
+		if !ok {
+			continue
+		}
+
+		k := key{service: strings.ToUpper(r.Service), crypto: r.Crypto}
+		t := r.Time.In(loc)
+		switch by {
+		case GroupHour:
+			k.group = fmt.Sprintf("%02d:00", t.Hour())
+			k.order = t.Hour()
+		case GroupWeekday:
+			k.group = t.Weekday().String()[:3]
+			// Monday first.
+			k.order = (int(t.Weekday()) + 6) % 7
+		}
+		markups[k] = append(markups[k], markup)
+	}
+
+	var stats []MarkupStats
+	for k, values := range markups {
+		sort.Float64s(values)
+
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+
+		median := values[len(values)/2]
+		if len(values)%2 == 0 {
+			median = (values[len(values)/2-1] + median) / 2
+		}
+
+		stats = append(stats, MarkupStats{
+			Service: k.service,
+			Crypto:  k.crypto,
+			Group:   k.group,
+			Count:   len(values),
+			Mean:    sum / float64(len(values)),
+			Median:  median,
+			Best:    values[0],
+			order:   k.order,
+		})
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		a, b := stats[i], stats[j]
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		if a.Crypto != b.Crypto {
+			return a.Crypto < b.Crypto
+		}
+		return a.order < b.order
+	})
+
+	if by != GroupNone {
+		markCheapest(stats)
+	}
+
+	return stats, nil
+}
+
+// markCheapest flags the group with the lowest mean in each run of the same service and coin.
+func markCheapest(stats []MarkupStats) {
+	for start := 0; start < len(stats); {
+		cheapest := start
+		end := start
+		for ; end < len(stats) && stats[end].Service == stats[start].Service && stats[end].Crypto == stats[start].Crypto; end++ {
+			if stats[end].Mean < stats[cheapest].Mean {
+				cheapest = end
+			}
+		}
+
+		stats[cheapest].Cheapest = true
+		start = end
+	}
+}
+
+// HistoryStats is HistoryStats with hours and weekdays in the calendar's time zone.
+func (cb *CryptoBill) HistoryStats(records []QuoteRecord, by string) ([]MarkupStats, error) {
+	cal, err := cb.calendar()
+	if err != nil {
+		return nil, err
+	}
+
+	return HistoryStats(records, by, cal.Location)
+}
@@ -0,0 +1,186 @@
+package cryptobill
+
+import (
+	"bufio"
+	"encoding/csv"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Holiday is a day banks don't process payments.
+type Holiday struct {
+	Date string
+	Name string
+}
+
+// LoadHolidays reads public holidays from an .ics or .csv file.
+//
+// Every event in an ICS file is taken to be a holiday, so it should be a calendar for your state.
+// A CSV file needs a header with a date column, and may have a name column and a jurisdiction
+// (or state) column, like the list published on data.gov.au. Rows for other states are skipped,
+// while rows for "national" or with no jurisdiction are kept.
+func LoadHolidays(path, state string) ([]Holiday, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "open holidays")
+	}
+	defer fp.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ics", ".ical":
+		return readICSHolidays(fp)
+	case ".csv":
+		return readCSVHolidays(fp, state)
+	}
+
+	return nil, errors.New("holidays need to be an .ics or .csv file: " + path)
+}
+
+// readICSHolidays understands just enough of iCalendar for all-day events.
+func readICSHolidays(r io.Reader) ([]Holiday, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		// Long lines are folded by starting the continuation with a space or tab.
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "read ics")
+	}
+
+	var holidays []Holiday
+	var start, end, name string
+	inEvent := false
+	for _, line := range lines {
+		bits := strings.SplitN(line, ":", 2)
+		if len(bits) != 2 {
+			continue
+		}
+		// Drop parameters, e.g. DTSTART;VALUE=DATE.
+		key := strings.ToUpper(strings.SplitN(bits[0], ";", 2)[0])
+		value := bits[1]
+
+		switch {
+		case key == "BEGIN" && value == "VEVENT":
+			inEvent = true
+			start, end, name = "", "", ""
+		case key == "END" && value == "VEVENT":
+			inEvent = false
+			days, err := icsDays(start, end)
+			if err != nil {
+				return nil, errors.Wrap(err, name)
+			}
+			for _, day := range days {
+				holidays = append(holidays, Holiday{Date: day, Name: name})
+			}
+		case inEvent && key == "DTSTART":
+			start = value
+		case inEvent && key == "DTEND":
+			end = value
+		case inEvent && key == "SUMMARY":
+			name = value
+		}
+	}
+
+	return holidays, nil
+}
+
+// icsDays expands an event into its dates. DTEND is exclusive and optional.
+func icsDays(start, end string) ([]string, error) {
+	if len(start) < 8 {
+		return nil, errors.Errorf("bad DTSTART %q", start)
+	}
+
+	first, err := time.Parse("20060102", start[:8])
+	if err != nil {
+		return nil, errors.Wrap(err, "DTSTART")
+	}
+
+	last := first
+	if len(end) >= 8 {
+		last, err = time.Parse("20060102", end[:8])
+		if err != nil {
+			return nil, errors.Wrap(err, "DTEND")
+		}
+		last = last.AddDate(0, 0, -1)
+	}
+
+	var days []string
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		days = append(days, day.Format(DateLayout))
+	}
+	if len(days) == 0 {
+		days = append(days, first.Format(DateLayout))
+	}
+
+	return days, nil
+}
+
+func readCSVHolidays(r io.Reader, state string) ([]Holiday, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, errors.Wrap(err, "read csv")
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	dateCol, nameCol, stateCol := -1, -1, -1
+	for i, heading := range rows[0] {
+		heading = strings.ToLower(strings.TrimSpace(heading))
+		switch {
+		case heading == "date" && dateCol < 0:
+			dateCol = i
+		case strings.Contains(heading, "name") && nameCol < 0:
+			nameCol = i
+		case (heading == "jurisdiction" || heading == "state") && stateCol < 0:
+			stateCol = i
+		}
+	}
+	if dateCol < 0 {
+		return nil, errors.New("holidays csv needs a date column")
+	}
+
+	var holidays []Holiday
+	for _, row := range rows[1:] {
+		if stateCol >= 0 {
+			jurisdiction := strings.TrimSpace(row[stateCol])
+			if jurisdiction != "" && !strings.EqualFold(jurisdiction, "national") && !strings.EqualFold(jurisdiction, state) {
+				continue
+			}
+		}
+
+		date, err := parseHolidayDate(strings.TrimSpace(row[dateCol]))
+		if err != nil {
+			return nil, err
+		}
+
+		holiday := Holiday{Date: date}
+		if nameCol >= 0 {
+			holiday.Name = strings.TrimSpace(row[nameCol])
+		}
+		holidays = append(holidays, holiday)
+	}
+
+	return holidays, nil
+}
+
+func parseHolidayDate(s string) (string, error) {
+	for _, layout := range []string{DateLayout, "20060102", "2/01/2006"} {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t.Format(DateLayout), nil
+		}
+	}
+
+	return "", errors.Errorf("can't understand holiday date %q", s)
+}
@@ -2,18 +2,19 @@ package cryptobill
 
 import (
 	"encoding/json"
-	"fmt"
 	"github.com/pkg/errors"
 	"io"
 	"io/ioutil"
 	"net/http"
-	"strings"
 )
 
 type LivingRoom struct{}
 
 type QuoteResponse map[string]float64
 
+// Rates are keyed like "AUD_BTC".
+var livingRoomPairs = mustPairFormat("{fiat}_{crypto}", "", nil)
+
 func NewLivingRoom() Service {
 	return &LivingRoom{}
 }
@@ -30,44 +31,44 @@ func (lros *LivingRoom) Website() string {
 	return ""
 }
 
+// Fiats is only AUD, though the rates name the fiat of each pair.
+func (lros *LivingRoom) Fiats() []Currency {
+	return []Currency{"AUD"}
+}
+
 func (lros *LivingRoom) Quote(cb *CryptoBill, info *FiatInfo) ([]QuoteResult, error) {
 	decoded := QuoteResponse{}
 	if err := lros.request(cb, "GET", "https://www.livingroomofsatoshi.com/api/v1/current_rates", nil, &decoded); err != nil {
 		return nil, errors.Wrap(err, "lros request")
 	}
 
-	var results []QuoteResult
-	for pair, quoted := range decoded {
-		bits := strings.Split(pair, "_")
-		fiat, err := NewCurrencyFromString(bits[0])
-		if err != nil {
-			fmt.Println(err.Error())
-			continue
-		}
+	reader := newPairReader(lros.ShortName(), livingRoomPairs, info.Fiat)
+	defer reader.Log(cb.log())
 
-		crypto, err := NewCurrencyFromString(bits[1])
-		if err != nil {
-			fmt.Println(err.Error())
+	var results []QuoteResult
+	for key, quoted := range decoded {
+		pair, ok := reader.Parse(key)
+		if !ok || !reader.Rate(key, quoted) {
 			continue
 		}
 
 		qr := QuoteResult{
-			lros,
-			Pair{fiat, crypto},
-			Conversion{info.Amount, info.Amount / Amount(quoted)},
+			Service:    lros,
+			Pair:       pair,
+			Conversion: Conversion{info.Amount, info.Amount / Amount(quoted)},
 		}
 		results = append(results, qr)
 	}
 
-	return results, nil
+	return reader.Quotes(results)
 }
 
 func (lros *LivingRoom) PayBPAY(cb *CryptoBill, bpay *PayBPAY) (*PayResult, error) {
-	return nil, nil
+	return nil, newProviderError(Unsupported, lros.ShortName(), "", "paying BPAY isn't done yet")
 }
 
 func (lros *LivingRoom) PayEFT(cb *CryptoBill, eft *PayEFT) (*PayResult, error) {
-	panic("implement me")
+	return nil, newProviderError(Unsupported, lros.ShortName(), "", "paying EFT isn't done yet")
 }
 
 func (lros *LivingRoom) request(cb *CryptoBill, method, url string, body io.Reader, out interface{}) error {
@@ -0,0 +1,72 @@
+package cryptobill
+
+import "testing"
+
+func TestLivingRoomQuote(t *testing.T) {
+	cb, _ := replayCryptoBill(t, "livingroom_quote")
+	info := FiatInfo{Amount: 100, Fiat: "AUD"}
+
+	results, err := NewLivingRoom().Quote(cb, &info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pairs with unknown currencies are skipped.
+	amounts := quoteAmounts(t, results, "LROS", info)
+	if len(amounts) != 2 {
+		t.Fatalf("got quotes for %v, want BTC and ETH", amounts)
+	}
+	assertAmount(t, "BTC", amounts["BTC"], 100/9104.11)
+	assertAmount(t, "ETH", amounts["ETH"], 100/296.02)
+}
+
+func TestLivingRoomQuoteZeroRate(t *testing.T) {
+	cb, _ := replayCryptoBill(t, "livingroom_quote_zero")
+	info := FiatInfo{Amount: 100, Fiat: "AUD"}
+
+	results, err := NewLivingRoom().Quote(cb, &info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The zero ETH rate is skipped rather than quoted as infinite.
+	amounts := quoteAmounts(t, results, "LROS", info)
+	if len(amounts) != 1 {
+		t.Fatalf("got quotes for %v, want BTC", amounts)
+	}
+	assertAmount(t, "BTC", amounts["BTC"], 100/9104.11)
+}
+
+func TestLivingRoomQuoteDown(t *testing.T) {
+	cb, _ := replayCryptoBill(t, "livingroom_quote_down")
+
+	results, err := NewLivingRoom().Quote(cb, &FiatInfo{Amount: 100, Fiat: "AUD"})
+	if err == nil {
+		t.Fatalf("expected an error from an html error page, got %v", results)
+	}
+}
+
+func TestLivingRoomQuotePairs(t *testing.T) {
+	cb, _ := replayCryptoBill(t, "livingroom_quote_pairs")
+
+	// Each fiat gets only its own rates, and the malformed "AUD" key is skipped.
+	for _, tc := range []struct {
+		fiat Currency
+		rate Amount
+	}{
+		{"AUD", 9104.11},
+		{"USD", 6500},
+	} {
+		info := FiatInfo{Amount: 100, Fiat: tc.fiat}
+		results, err := NewLivingRoom().Quote(cb, &info)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		amounts := quoteAmounts(t, results, "LROS", info)
+		if len(amounts) != 1 {
+			t.Fatalf("got %v quotes for %v, want BTC", amounts, tc.fiat)
+		}
+		assertAmount(t, string(tc.fiat), amounts["BTC"], 100/tc.rate)
+	}
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+
+package cryptobill
+
+import (
+	"os"
+	"syscall"
+)
+
+func tryLock(path string) (func(), error) {
+	fp, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(fp.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		fp.Close()
+		return nil, errLocked
+	}
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+
+	return func() {
+		syscall.Flock(int(fp.Fd()), syscall.LOCK_UN)
+		fp.Close()
+	}, nil
+}
@@ -0,0 +1,24 @@
+//go:build windows
+
+package cryptobill
+
+import (
+	"os"
+)
+
+// Windows has no flock, so the lock is the existence of the file itself. A crashed process can leave
+// it behind, in which case it needs to be removed by hand.
+func tryLock(path string) (func(), error) {
+	fp, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return nil, errLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		fp.Close()
+		os.Remove(path)
+	}, nil
+}
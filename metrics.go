@@ -0,0 +1,336 @@
+package cryptobill
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics collects how services are behaving: how long requests take and how they fail, the
+// latest quotes, and reference prices. MetricsHandler serves them to Prometheus.
+type Metrics struct {
+	mu         sync.Mutex
+	requests   map[requestKey]*histogram
+	errors     map[errorKey]int
+	quotes     map[quoteKey]quoteSample
+	references map[Pair]Amount
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:   map[requestKey]*histogram{},
+		errors:     map[errorKey]int{},
+		quotes:     map[quoteKey]quoteSample{},
+		references: map[Pair]Amount{},
+	}
+}
+
+type requestKey struct {
+	service, operation string
+}
+
+type errorKey struct {
+	service, operation string
+	kind               ErrorKind
+}
+
+type quoteKey struct {
+	service string
+	pair    Pair
+}
+
+type quoteSample struct {
+	quote QuoteResult
+	at    time.Time
+}
+
+// Upper bounds of the request duration buckets, in seconds.
+var requestBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type histogram struct {
+	counts []int
+	count  int
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range requestBuckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// The methods below do nothing on a nil Metrics, so the library doesn't need to check.
+
+func (m *Metrics) observeRequest(service, operation string, elapsed time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := requestKey{service, operation}
+	h := m.requests[key]
+	if h == nil {
+		h = &histogram{counts: make([]int, len(requestBuckets))}
+		m.requests[key] = h
+	}
+	h.observe(elapsed.Seconds())
+
+	if err != nil {
+		kind := ErrorKind("other")
+		if perr, ok := AsProviderError(err); ok {
+			kind = perr.Kind
+		}
+		m.errors[errorKey{service, operation, kind}]++
+	}
+}
+
+func (m *Metrics) observeQuotes(quotes []QuoteResult, at time.Time) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, q := range quotes {
+		if q.Conversion.Crypto <= 0 || q.Conversion.Fiat <= 0 {
+			continue
+		}
+		m.quotes[quoteKey{q.Service.ShortName(), q.Pair}] = quoteSample{q, at}
+	}
+}
+
+func (m *Metrics) observeReference(pair Pair, price Amount) {
+	if m == nil || price <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	m.references[pair] = price
+	m.mu.Unlock()
+}
+
+// observe records a request to a service taking since start, and how it failed if it did.
+func (cb *CryptoBill) observe(service, operation string, start time.Time, err error) {
+	cb.Metrics.observeRequest(service, operation, time.Since(start), err)
+}
+
+// MetricsHandler serves cb.Metrics and the number of payments in each status in the Prometheus text
+// format.
+func (cb *CryptoBill) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payments, err := cb.Payments()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		cb.Metrics.WriteText(w, payments)
+	})
+}
+
+// WriteText writes the metrics, with counts of payments by status, in the Prometheus text format.
+// m may be nil, leaving just the payments.
+func (m *Metrics) WriteText(w io.Writer, payments []*Payment) error {
+	out := &metricsWriter{w: bufio.NewWriter(w)}
+
+	if m != nil {
+		m.mu.Lock()
+		m.write(out)
+		m.mu.Unlock()
+	}
+
+	counts := map[string]int{}
+	for _, status := range []string{PaymentHeld, PaymentSubmitting, PaymentPrepared, PaymentFailed, PaymentReceived, PaymentPaid} {
+		counts[status] = 0
+	}
+	for _, p := range payments {
+		counts[p.Status]++
+	}
+	out.help("cryptobill_payments", "gauge", "Recorded payments by status.")
+	for _, status := range sortedKeys(counts) {
+		out.sample("cryptobill_payments", labels{"status", status}, float64(counts[status]))
+	}
+
+	return out.w.Flush()
+}
+
+func (m *Metrics) write(out *metricsWriter) {
+	var requests []requestKey
+	for key := range m.requests {
+		requests = append(requests, key)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		a, b := requests[i], requests[j]
+		return a.service < b.service || a.service == b.service && a.operation < b.operation
+	})
+
+	out.help("cryptobill_provider_request_duration_seconds", "histogram", "How long requests to services took.")
+	for _, key := range requests {
+		h := m.requests[key]
+		name := "cryptobill_provider_request_duration_seconds"
+		l := labels{"service", key.service, "operation", key.operation}
+		for i, bound := range requestBuckets {
+			out.sample(name+"_bucket", append(l, "le", strconv.FormatFloat(bound, 'g', -1, 64)), float64(h.counts[i]))
+		}
+		out.sample(name+"_bucket", append(l, "le", "+Inf"), float64(h.count))
+		out.sample(name+"_sum", l, h.sum)
+		out.sample(name+"_count", l, float64(h.count))
+	}
+
+	var errs []errorKey
+	for key := range m.errors {
+		errs = append(errs, key)
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		a, b := errs[i], errs[j]
+		if a.service != b.service {
+			return a.service < b.service
+		}
+		if a.operation != b.operation {
+			return a.operation < b.operation
+		}
+		return a.kind < b.kind
+	})
+
+	out.help("cryptobill_provider_errors_total", "counter", "Failed requests to services, by kind of failure.")
+	for _, key := range errs {
+		out.sample("cryptobill_provider_errors_total",
+			labels{"service", key.service, "operation", key.operation, "kind", string(key.kind)},
+			float64(m.errors[key]))
+	}
+
+	var quotes []quoteKey
+	for key := range m.quotes {
+		quotes = append(quotes, key)
+	}
+	sort.Slice(quotes, func(i, j int) bool {
+		a, b := quotes[i], quotes[j]
+		if a.service != b.service {
+			return a.service < b.service
+		}
+		if a.pair.Crypto != b.pair.Crypto {
+			return a.pair.Crypto < b.pair.Crypto
+		}
+		return a.pair.Fiat < b.pair.Fiat
+	})
+
+	out.help("cryptobill_quote_rate", "gauge", "Fiat price of one coin in the latest quote.")
+	for _, key := range quotes {
+		c := m.quotes[key].quote.Conversion
+		out.sample("cryptobill_quote_rate", quoteLabels(key), float64(c.Fiat/c.Crypto))
+	}
+
+	out.help("cryptobill_quote_timestamp_seconds", "gauge", "When the latest quote was made.")
+	for _, key := range quotes {
+		out.sample("cryptobill_quote_timestamp_seconds", quoteLabels(key), float64(m.quotes[key].at.Unix()))
+	}
+
+	out.help("cryptobill_quote_markup_percent", "gauge", "Markup of the latest quote over the reference price.")
+	for _, key := range quotes {
+		reference, ok := m.references[key.pair]
+		if !ok {
+			continue
+		}
+		out.sample("cryptobill_quote_markup_percent", quoteLabels(key), NewMarkup(m.quotes[key].quote, reference).Percent)
+	}
+
+	var pairs []Pair
+	for pair := range m.references {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		a, b := pairs[i], pairs[j]
+		return a.Crypto < b.Crypto || a.Crypto == b.Crypto && a.Fiat < b.Fiat
+	})
+
+	out.help("cryptobill_reference_price", "gauge", "Latest reference price of one coin.")
+	for _, pair := range pairs {
+		out.sample("cryptobill_reference_price", pairLabels(pair), float64(m.references[pair]))
+	}
+
+	// A large divergence usually means the reference price is stale or wrong, rather than every
+	// service having moved.
+	out.help("cryptobill_reference_divergence_percent", "gauge", "How far the reference price is from the median quoted rate.")
+	for _, pair := range pairs {
+		var rates []float64
+		for key, sample := range m.quotes {
+			if key.pair == pair {
+				c := sample.quote.Conversion
+				rates = append(rates, float64(c.Fiat/c.Crypto))
+			}
+		}
+		if len(rates) == 0 {
+			continue
+		}
+		divergence := (float64(m.references[pair])/median(rates) - 1) * 100
+		out.sample("cryptobill_reference_divergence_percent", pairLabels(pair), divergence)
+	}
+}
+
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
+
+func sortedKeys(m map[string]int) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// labels are names and values, in turn.
+type labels []string
+
+func quoteLabels(key quoteKey) labels {
+	return labels{"service", key.service, "crypto", string(key.pair.Crypto), "fiat", string(key.pair.Fiat)}
+}
+
+func pairLabels(pair Pair) labels {
+	return labels{"crypto", string(pair.Crypto), "fiat", string(pair.Fiat)}
+}
+
+type metricsWriter struct {
+	w *bufio.Writer
+}
+
+func (out *metricsWriter) help(name, kind, help string) {
+	fmt.Fprintf(out.w, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, kind)
+}
+
+func (out *metricsWriter) sample(name string, l labels, value float64) {
+	out.w.WriteString(name)
+	if len(l) > 0 {
+		out.w.WriteString("{")
+		for i := 0; i < len(l); i += 2 {
+			if i > 0 {
+				out.w.WriteString(",")
+			}
+			fmt.Fprintf(out.w, "%v=\"%v\"", l[i], labelEscaper.Replace(l[i+1]))
+		}
+		out.w.WriteString("}")
+	}
+	fmt.Fprintf(out.w, " %v\n", strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,99 @@
+package cryptobill
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	config := &SandboxConfig{
+		Fiat:      "AUD",
+		Reference: map[Currency]Amount{"BTC": 10000},
+		Services: map[string]FakeServiceConfig{
+			"GOOD": {Markup: 2},
+			"BAD":  {QuoteFailRate: 1},
+		},
+	}
+	services, err := config.NewServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cb := testCryptoBill(t,
+		WithServices(services...),
+		WithOracle(&FixedOracle{Fiat: "AUD", Prices: map[Currency]Amount{"BTC": 9900}}),
+		WithConfigDir(t.TempDir()),
+		WithMetrics(NewMetrics()),
+	)
+
+	_, err = cb.Quote(&FiatInfo{Amount: 100, Fiat: "AUD"})
+	if err == nil {
+		t.Fatal("expected BAD to fail")
+	}
+	_, err = cb.ReferencePrice(Pair{Fiat: "AUD", Crypto: "BTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	cb.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		"# TYPE cryptobill_provider_request_duration_seconds histogram\n",
+		`cryptobill_provider_request_duration_seconds_bucket{service="GOOD",operation="quote",le="+Inf"} 1` + "\n",
+		`cryptobill_provider_request_duration_seconds_count{service="BAD",operation="quote"} 1` + "\n",
+		`cryptobill_provider_errors_total{service="BAD",operation="quote",kind="unavailable"} 1` + "\n",
+		`cryptobill_quote_rate{service="GOOD",crypto="BTC",fiat="AUD"} 9803.92`,
+		`cryptobill_quote_markup_percent{service="GOOD",crypto="BTC",fiat="AUD"} 0.98`,
+		`cryptobill_reference_price{crypto="BTC",fiat="AUD"} 9900` + "\n",
+		`cryptobill_reference_divergence_percent{crypto="BTC",fiat="AUD"} 0.98`,
+		`cryptobill_payments{status="paid"} 0` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, `service="BAD",crypto=`) {
+		t.Errorf("failed quote has a rate:\n%s", body)
+	}
+}
+
+func TestMetricsWriteText(t *testing.T) {
+	m := NewMetrics()
+	m.observeRequest(`we"ird\`, "pay", 300*time.Millisecond, nil)
+	m.observeRequest(`we"ird\`, "pay", 3*time.Second, newProviderError(RejectedInput, "X", "code", "nope"))
+
+	var out strings.Builder
+	err := m.WriteText(&out, []*Payment{{Status: PaymentPaid}, {Status: PaymentPaid}, {Status: PaymentFailed}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`cryptobill_provider_request_duration_seconds_bucket{service="we\"ird\\",operation="pay",le="0.25"} 0` + "\n",
+		`cryptobill_provider_request_duration_seconds_bucket{service="we\"ird\\",operation="pay",le="0.5"} 1` + "\n",
+		`cryptobill_provider_request_duration_seconds_bucket{service="we\"ird\\",operation="pay",le="5"} 2` + "\n",
+		`cryptobill_provider_request_duration_seconds_sum{service="we\"ird\\",operation="pay"} 3.3` + "\n",
+		`cryptobill_provider_errors_total{service="we\"ird\\",operation="pay",kind="rejected_input"} 1` + "\n",
+		`cryptobill_payments{status="paid"} 2` + "\n",
+		`cryptobill_payments{status="failed"} 1` + "\n",
+		`cryptobill_payments{status="held"} 0` + "\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("missing %q in:\n%s", want, out.String())
+		}
+	}
+
+	// Without Metrics, only the payments are written.
+	out.Reset()
+	err = (*Metrics)(nil).WriteText(&out, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "provider") || !strings.Contains(out.String(), `cryptobill_payments{status="prepared"} 0`) {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+}
@@ -0,0 +1,113 @@
+package cryptobill
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"sort"
+)
+
+// BillsVersion is the version of the bills schema this build reads and writes. Bump it, and
+// register a migration from the previous version, whenever Bill, BPAY or EFT change in a way that
+// old files would decode wrongly.
+const BillsVersion = 2
+
+// billsDocument is what the bills file contains, and what is kept in the vault.
+type billsDocument struct {
+	Version int
+	Bills   Bills
+}
+
+// Migration upgrades a bills document from version From to From+1. The document is the decoded
+// JSON of the whole file, and Apply returns its replacement. Version is set by the caller.
+type Migration struct {
+	From        int
+	Description string
+	Apply       func(doc map[string]interface{}) (map[string]interface{}, error)
+}
+
+var migrations = map[int]Migration{}
+
+// RegisterMigration adds a migration. There can only be one migration from each version.
+func RegisterMigration(m Migration) {
+	if _, exists := migrations[m.From]; exists {
+		panic(fmt.Sprintf("duplicate bills migration from version %v", m.From))
+	}
+	migrations[m.From] = m
+}
+
+// Migrations returns the registered migrations, oldest first.
+func Migrations() []Migration {
+	var list []Migration
+	for _, m := range migrations {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].From < list[j].From
+	})
+	return list
+}
+
+func init() {
+	RegisterMigration(Migration{
+		From:        1,
+		Description: "wrap the bills in a versioned envelope",
+		Apply: func(doc map[string]interface{}) (map[string]interface{}, error) {
+			return map[string]interface{}{"Bills": doc}, nil
+		},
+	})
+}
+
+// documentVersion works out the version of a decoded bills file. Version 1 files are a bare map of
+// bills, which can be told apart because a bill is an object, never a number.
+func documentVersion(doc map[string]interface{}) int {
+	if v, ok := doc["Version"].(float64); ok {
+		return int(v)
+	}
+	return 1
+}
+
+// migrateBills decodes a bills file, running any migrations it needs. It returns the version the
+// file was at, so callers can tell whether it needs writing back.
+func migrateBills(data []byte) (*billsDocument, int, error) {
+	doc := map[string]interface{}{}
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "decode json")
+	}
+
+	from := documentVersion(doc)
+	if from > BillsVersion {
+		return nil, from, errors.Errorf("bills are version %v but this build only understands up to %v, please upgrade", from, BillsVersion)
+	}
+
+	for v := from; v < BillsVersion; v++ {
+		m, ok := migrations[v]
+		if !ok {
+			return nil, from, errors.Errorf("no migration from bills version %v", v)
+		}
+
+		doc, err = m.Apply(doc)
+		if err != nil {
+			return nil, from, errors.Wrapf(err, "migrate bills from version %v (%v)", v, m.Description)
+		}
+		doc["Version"] = v + 1
+	}
+
+	// Round trip through JSON to get from the generic document to the real types.
+	data, err = json.Marshal(doc)
+	if err != nil {
+		return nil, from, errors.Wrap(err, "encode migrated bills")
+	}
+
+	result := &billsDocument{}
+	err = json.Unmarshal(data, result)
+	if err != nil {
+		return nil, from, errors.Wrap(err, "decode migrated bills")
+	}
+	if result.Bills == nil {
+		result.Bills = Bills{}
+	}
+
+	return result, from, nil
+}
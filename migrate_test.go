@@ -0,0 +1,144 @@
+package cryptobill
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// legacyBills is a bills.json from before it was versioned, a bare map of bills.
+const legacyBills = `{
+  "rent": {"Name": "rent", "BPAY": {"Code": 23796, "Name": "", "Account": "1234567897"}, "EFT": {"BSB": "", "BSBName": "", "AccountNumber": "", "AccountName": "", "Remitter": ""}}
+}`
+
+func writeBillsFile(t *testing.T, dir, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, "bills.json")
+	err := ioutil.WriteFile(path, []byte(contents), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMigrateLegacyBills(t *testing.T) {
+	cb, dir := fileCryptoBill(t)
+	path := writeBillsFile(t, dir, legacyBills)
+
+	bills, err := cb.LoadBills()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bills["rent"] == nil || bills["rent"].BPAY.Code != 23796 {
+		t.Fatalf("got bills %v", bills)
+	}
+
+	// Loading writes the upgraded file back, keeping the old one as a backup.
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := billsDocument{}
+	err = json.Unmarshal(data, &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version != BillsVersion || doc.Bills["rent"] == nil {
+		t.Errorf("file is version %v with %v, want version %v", doc.Version, doc.Bills, BillsVersion)
+	}
+
+	backups, err := listBackups(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("got %v backups, want the legacy file", len(backups))
+	}
+	backup, _ := ioutil.ReadFile(backups[0])
+	if string(backup) != legacyBills {
+		t.Errorf("backup is %s, want the legacy file", backup)
+	}
+
+	// It only happens once.
+	_, err = cb.LoadBills()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backups, _ := listBackups(path); len(backups) != 1 {
+		t.Errorf("got %v backups after loading again, want 1", len(backups))
+	}
+}
+
+func TestMigrateNewerBills(t *testing.T) {
+	cb, dir := fileCryptoBill(t)
+	writeBillsFile(t, dir, `{"Version": 99, "Bills": {}}`)
+
+	_, err := cb.LoadBills()
+	if err == nil || !strings.Contains(err.Error(), "please upgrade") {
+		t.Errorf("expected a newer file to be refused, got %v", err)
+	}
+}
+
+func TestDoctor(t *testing.T) {
+	cb, dir := fileCryptoBill(t)
+	writeBillsFile(t, dir, `{"Version": 2, "Bills": {
+  "rent": {"Name": "old rent", "BPAY": {"Code": 23796, "Account": "1234567897"}},
+  "empty": null,
+  "broken": {"Name": "broken", "BPAY": {"Code": 1, "Account": "1"}}
+}}`)
+
+	problems, err := cb.Doctor(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]BillProblem{}
+	for _, p := range problems {
+		found[p.Key] = p
+	}
+	if p := found["rent"]; !p.Fixable || !strings.Contains(p.Problem, `"old rent"`) {
+		t.Errorf("rent: got %+v, want a fixable name mismatch", p)
+	}
+	if p := found["empty"]; !p.Fixable {
+		t.Errorf("empty: got %+v, want a fixable empty entry", p)
+	}
+	if p := found["broken"]; p.Fixable || p.Problem == "" {
+		t.Errorf("broken: got %+v, want a problem to fix by hand", p)
+	}
+
+	// Without fix nothing changes.
+	bills, err := cb.LoadBills()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bills["rent"].Name != "old rent" {
+		t.Errorf("name = %q, want it left alone", bills["rent"].Name)
+	}
+
+	_, err = cb.Doctor(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bills, err = cb.LoadBills()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bills["rent"].Name != "rent" {
+		t.Errorf("name = %q after fixing, want rent", bills["rent"].Name)
+	}
+	if _, ok := bills["empty"]; ok {
+		t.Error("empty entry still there after fixing")
+	}
+
+	problems, err = cb.Doctor(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range problems {
+		if p.Fixable {
+			t.Errorf("%v still has %q after fixing", p.Key, p.Problem)
+		}
+	}
+}
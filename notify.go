@@ -0,0 +1,521 @@
+package cryptobill
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Kinds of events that can be sent to sinks.
+const (
+	// A watch rule was met.
+	EventAlert = "alert"
+
+	// A payment was prepared or failed.
+	EventPayment = "payment"
+
+	// A scheduled bill is coming up to its pay-by time.
+	EventDue = "due"
+)
+
+var EventKinds = []string{EventAlert, EventPayment, EventDue}
+
+// Event is something that happened which someone might want to hear about.
+type Event struct {
+	Kind    string
+	Title   string
+	Message string
+	Time    time.Time
+
+	// Details for templates and webhooks, e.g. the bill name and amount.
+	Data map[string]interface{}
+}
+
+// Message is an event rendered for a sink.
+type Message struct {
+	Event   *Event
+	Subject string
+	Body    string
+}
+
+// Sink delivers messages somewhere, e.g. a webhook or email.
+type Sink interface {
+	Send(cb *CryptoBill, msg *Message) error
+}
+
+// Notifier sends events to the sinks configured for them.
+type Notifier struct {
+	Sinks  map[string]*NotifySink
+	Routes []NotifyRoute
+
+	// Attempts after the first failed one, with the delay doubling each time. They're made in
+	// the background, so a slow sink doesn't hold up a watch or payment.
+	Retries    int
+	RetryDelay time.Duration
+
+	// Body templates by event kind.
+	Templates map[string]*template.Template
+
+	retrying sync.WaitGroup
+	mu       sync.Mutex
+	failed   error
+}
+
+// NotifySink is a sink with optional templates overriding the notifier's.
+type NotifySink struct {
+	Sink
+	Subject  *template.Template
+	Template *template.Template
+}
+
+// NotifyRoute sends events of the given kinds to the named sinks. No kinds means every kind.
+type NotifyRoute struct {
+	Events []string
+	Sinks  []string
+}
+
+func (r *NotifyRoute) matches(kind string) bool {
+	if len(r.Events) == 0 {
+		return true
+	}
+	for _, e := range r.Events {
+		if e == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// NotifyConfig is the contents of notify.json in the config directory.
+type NotifyConfig struct {
+	Sinks  map[string]SinkConfig
+	Routes []NotifyRoute
+
+	Retries int
+
+	// e.g. "5s". Defaults to 2s.
+	RetryDelay string
+
+	// Body templates by event kind, using text/template with the Event, e.g. "{{.Title}}: {{.Message}}".
+	Templates map[string]string
+}
+
+// SinkConfig configures one sink. Type chooses which of the other fields are used.
+type SinkConfig struct {
+	// webhook, smtp, desktop, command or stdout.
+	Type string
+
+	// Templates overriding the notifier's for this sink. Subject is used for email and desktop
+	// notifications.
+	Subject  string
+	Template string
+
+	// webhook
+	URL     string
+	Headers map[string]string
+
+	// smtp
+	Addr     string
+	From     string
+	To       []string
+	Username string
+	Password string
+
+	// command: the message body is written to its stdin.
+	Command []string
+}
+
+// LoadNotifier builds the notifier from notify.json in dir. Without one, events go nowhere.
+func LoadNotifier(dir string) (*Notifier, error) {
+	n := &Notifier{
+		Sinks:      map[string]*NotifySink{},
+		RetryDelay: 2 * time.Second,
+		Templates:  map[string]*template.Template{},
+	}
+
+	path := filepath.Join(dir, "notify.json")
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return n, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read "+path)
+	}
+
+	config := NotifyConfig{}
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode json from "+path)
+	}
+
+	err = n.apply(&config)
+	if err != nil {
+		return nil, errors.Wrap(err, path)
+	}
+
+	return n, nil
+}
+
+func (n *Notifier) apply(config *NotifyConfig) error {
+	n.Retries = config.Retries
+	n.Routes = config.Routes
+
+	if config.RetryDelay != "" {
+		delay, err := time.ParseDuration(config.RetryDelay)
+		if err != nil {
+			return errors.Wrap(err, "retry delay")
+		}
+		n.RetryDelay = delay
+	}
+
+	for kind, text := range config.Templates {
+		tmpl, err := template.New(kind).Parse(text)
+		if err != nil {
+			return errors.Wrap(err, kind+" template")
+		}
+		n.Templates[kind] = tmpl
+	}
+
+	for name, sc := range config.Sinks {
+		sink, err := newSink(&sc)
+		if err != nil {
+			return errors.Wrap(err, "sink "+name)
+		}
+		n.Sinks[name] = sink
+	}
+
+	for _, route := range n.Routes {
+		for _, name := range route.Sinks {
+			if n.Sinks[name] == nil {
+				return errors.New("route to unknown sink " + name)
+			}
+		}
+		for _, kind := range route.Events {
+			if !validEventKind(kind) {
+				return errors.Errorf("unknown event %q, use one of %v", kind, strings.Join(EventKinds, ", "))
+			}
+		}
+	}
+
+	return nil
+}
+
+func validEventKind(kind string) bool {
+	for _, k := range EventKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func newSink(sc *SinkConfig) (*NotifySink, error) {
+	ns := &NotifySink{}
+	var err error
+
+	if sc.Subject != "" {
+		ns.Subject, err = template.New("subject").Parse(sc.Subject)
+		if err != nil {
+			return nil, errors.Wrap(err, "subject")
+		}
+	}
+	if sc.Template != "" {
+		ns.Template, err = template.New("template").Parse(sc.Template)
+		if err != nil {
+			return nil, errors.Wrap(err, "template")
+		}
+	}
+
+	switch sc.Type {
+	case "webhook":
+		if sc.URL == "" {
+			return nil, errors.New("webhook needs a URL")
+		}
+		ns.Sink = &WebhookSink{URL: sc.URL, Headers: sc.Headers}
+	case "smtp":
+		if sc.Addr == "" || sc.From == "" || len(sc.To) == 0 {
+			return nil, errors.New("smtp needs Addr, From and To")
+		}
+		ns.Sink = &SMTPSink{Addr: sc.Addr, From: sc.From, To: sc.To, Username: sc.Username, Password: sc.Password}
+	case "desktop":
+		ns.Sink = &DesktopSink{}
+	case "command":
+		if len(sc.Command) == 0 {
+			return nil, errors.New("command sink needs a Command")
+		}
+		ns.Sink = &CommandSink{Command: sc.Command}
+	case "stdout":
+		ns.Sink = &CommandSink{}
+	default:
+		return nil, errors.Errorf("unknown type %q, use webhook, smtp, desktop, command or stdout", sc.Type)
+	}
+
+	return ns, nil
+}
+
+var (
+	defaultSubject  = template.Must(template.New("subject").Parse("{{.Title}}"))
+	defaultTemplate = template.Must(template.New("template").Parse("{{.Message}}"))
+)
+
+func (n *Notifier) render(sink *NotifySink, e *Event) (*Message, error) {
+	subject := defaultSubject
+	if sink.Subject != nil {
+		subject = sink.Subject
+	}
+
+	body := defaultTemplate
+	if tmpl, ok := n.Templates[e.Kind]; ok {
+		body = tmpl
+	}
+	if sink.Template != nil {
+		body = sink.Template
+	}
+
+	msg := &Message{Event: e}
+	buf := &bytes.Buffer{}
+	err := subject.Execute(buf, e)
+	if err != nil {
+		return nil, errors.Wrap(err, "subject")
+	}
+	msg.Subject = buf.String()
+
+	buf.Reset()
+	err = body.Execute(buf, e)
+	if err != nil {
+		return nil, errors.Wrap(err, "template")
+	}
+	msg.Body = buf.String()
+
+	return msg, nil
+}
+
+// Notify sends the event to every sink routed for its kind. A sink failing doesn't stop the
+// others. Failures are retried in the background if Retries is set, and only reported by Wait.
+func (n *Notifier) Notify(cb *CryptoBill, e *Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	sent := map[string]bool{}
+	var errs error
+	for _, route := range n.Routes {
+		if !route.matches(e.Kind) {
+			continue
+		}
+
+		for _, name := range route.Sinks {
+			if sent[name] {
+				continue
+			}
+			sent[name] = true
+
+			err := n.send(cb, name, n.Sinks[name], e)
+			if err != nil {
+				errs = multierror.Append(errs, errors.Wrap(err, name))
+			}
+		}
+	}
+
+	return errs
+}
+
+func (n *Notifier) send(cb *CryptoBill, name string, sink *NotifySink, e *Event) error {
+	msg, err := n.render(sink, e)
+	if err != nil {
+		return err
+	}
+
+	err = sink.Send(cb, msg)
+	if err == nil || n.Retries == 0 {
+		return err
+	}
+
+	cb.log().Warn("notify failed, retrying", "sink", name, "event", e.Kind, "error", err)
+	n.retrying.Add(1)
+	go func() {
+		defer n.retrying.Done()
+
+		err := n.retry(cb, sink, msg)
+		if err != nil {
+			cb.log().Error("notify failed", "sink", name, "event", e.Kind, "error", err)
+			n.mu.Lock()
+			n.failed = multierror.Append(n.failed, errors.Wrap(err, name))
+			n.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+func (n *Notifier) retry(cb *CryptoBill, sink *NotifySink, msg *Message) error {
+	var err error
+	delay := n.RetryDelay
+	for attempt := 0; attempt < n.Retries; attempt++ {
+		time.Sleep(delay)
+		delay *= 2
+
+		err = sink.Send(cb, msg)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// Wait waits for retries to finish, and returns the sinks that failed every attempt since the
+// last Wait.
+func (n *Notifier) Wait() error {
+	n.retrying.Wait()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	err := n.failed
+	n.failed = nil
+	return err
+}
+
+func (cb *CryptoBill) notifier() (*Notifier, error) {
+	if cb.Notifier == nil {
+		dir, err := cb.configDir()
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := LoadNotifier(dir)
+		if err != nil {
+			return nil, errors.Wrap(err, "load notifier")
+		}
+		cb.Notifier = n
+	}
+
+	return cb.Notifier, nil
+}
+
+// Notify sends an event with the notifier from the config directory.
+func (cb *CryptoBill) Notify(e *Event) error {
+	n, err := cb.notifier()
+	if err != nil {
+		return err
+	}
+
+	return n.Notify(cb, e)
+}
+
+// WaitNotify is Notifier.Wait for the notifier from the config directory, if it was used.
+func (cb *CryptoBill) WaitNotify() error {
+	if cb.Notifier == nil {
+		return nil
+	}
+
+	return cb.Notifier.Wait()
+}
+
+// NewAlertEvent describes a watch alert.
+func NewAlertEvent(a *Alert) *Event {
+	q := a.Markup.Quote
+	return &Event{
+		Kind:    EventAlert,
+		Title:   fmt.Sprintf("%v %v markup is %.2f%%", q.Service.ShortName(), q.Pair.Crypto, a.Markup.Percent),
+		Message: a.String(),
+		Time:    a.At,
+		Data: map[string]interface{}{
+			"bill":         a.Bill,
+			"rule":         a.Rule.String(),
+			"service":      q.Service.ShortName(),
+			"crypto":       q.Pair.Crypto,
+			"cryptoAmount": q.Conversion.Crypto,
+			"fiat":         q.Pair.Fiat,
+			"fiatAmount":   q.Conversion.Fiat,
+			"markup":       a.Markup.Percent,
+		},
+	}
+}
+
+// NewDueEvent describes a scheduled bill coming up to its pay-by time.
+func NewDueEvent(d *DueBill) *Event {
+	data := map[string]interface{}{
+		"bill":  d.Bill.Name,
+		"due":   d.Due.Format(DateLayout),
+		"payBy": d.PayBy,
+	}
+
+	amount := ""
+	if s := d.Bill.Schedule; s != nil && s.Amount != 0 {
+		amount = fmt.Sprintf(" for %.2f %v", s.Amount, s.Fiat)
+		data["amount"] = s.Amount
+		data["fiat"] = s.Fiat
+	}
+
+	return &Event{
+		Kind:    EventDue,
+		Title:   d.Bill.Name + " is due " + d.Due.Format(DateLayout),
+		Message: fmt.Sprintf("%v is due %v%v. Pay by %v.", d.Bill.Name, d.Due.Format(DateLayout), amount, d.PayBy.Format("2006-01-02 15:04 MST")),
+		Data:    data,
+	}
+}
+
+// NewPaymentEvent describes a payment being prepared, held for approval, failing, or being
+// received and paid.
+func NewPaymentEvent(p *Payment) *Event {
+	data := map[string]interface{}{
+		"id":         p.ID,
+		"bill":       p.Bill,
+		"service":    p.Service,
+		"crypto":     p.Crypto,
+		"fiat":       p.Fiat,
+		"fiatAmount": p.FiatAmount,
+		"status":     p.Status,
+	}
+
+	if p.Status == PaymentFailed {
+		data["error"] = p.Error
+		return &Event{
+			Kind:    EventPayment,
+			Title:   "Payment for " + p.Bill + " failed",
+			Message: fmt.Sprintf("Paying %.2f %v for %v with %v failed: %v", p.FiatAmount, p.Fiat, p.Bill, p.Service, p.Error),
+			Time:    p.Created,
+			Data:    data,
+		}
+	}
+
+	if p.Status == PaymentHeld {
+		return &Event{
+			Kind:    EventPayment,
+			Title:   "Payment for " + p.Bill + " needs approval",
+			Message: fmt.Sprintf("Paying %.2f %v for %v with %v is held. Run \"cryptobill approve %v\" to pay it.", p.FiatAmount, p.Fiat, p.Bill, p.Service, p.ID),
+			Time:    p.Created,
+			Data:    data,
+		}
+	}
+
+	data["address"] = p.Address
+	data["cryptoAmount"] = p.CryptoAmount
+	switch p.Status {
+	case PaymentReceived, PaymentPaid:
+		return &Event{
+			Kind:    EventPayment,
+			Title:   "Payment for " + p.Bill + " " + p.Status,
+			Message: fmt.Sprintf("%v has %v the %v %v for %.2f %v to %v.", p.Service, p.Status, p.CryptoAmount, p.Crypto, p.FiatAmount, p.Fiat, p.Bill),
+			Time:    time.Now(),
+			Data:    data,
+		}
+	}
+
+	return &Event{
+		Kind:  EventPayment,
+		Title: "Payment for " + p.Bill + " prepared",
+		Message: fmt.Sprintf("Send %v %v to %v to pay %.2f %v for %v with %v.",
+			p.CryptoAmount, p.Crypto, p.Address, p.FiatAmount, p.Fiat, p.Bill, p.Service),
+		Time: p.Created,
+		Data: data,
+	}
+}
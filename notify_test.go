@@ -0,0 +1,200 @@
+package cryptobill
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func testEvent() *Event {
+	return &Event{
+		Kind:    EventAlert,
+		Title:   "BTC is cheap",
+		Message: "PBC BTC markup is 0.5%",
+		Time:    time.Date(2018, 11, 1, 9, 0, 0, 0, time.UTC),
+		Data:    map[string]interface{}{"service": "PBC"},
+	}
+}
+
+func testNotifier(t *testing.T, config *NotifyConfig) *Notifier {
+	t.Helper()
+
+	n := &Notifier{Sinks: map[string]*NotifySink{}, Templates: map[string]*template.Template{}}
+	err := n.apply(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestWebhookSink(t *testing.T) {
+	var got webhookPayload
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		err := json.NewDecoder(r.Body).Decode(&got)
+		if err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	n := testNotifier(t, &NotifyConfig{
+		Sinks: map[string]SinkConfig{
+			"hook": {Type: "webhook", URL: server.URL, Headers: map[string]string{"Authorization": "Bearer x"}, Template: "{{.Title}}!"},
+		},
+		Routes: []NotifyRoute{{Events: []string{EventAlert}, Sinks: []string{"hook"}}},
+	})
+
+	err := n.Notify(testCryptoBill(t), testEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Bearer x" || got.Kind != EventAlert || got.Title != "BTC is cheap" || got.Text != "BTC is cheap!" || got.Data["service"] != "PBC" {
+		t.Errorf("got %+v with %q", got, auth)
+	}
+
+	// Not routed.
+	got = webhookPayload{}
+	err = n.Notify(testCryptoBill(t), &Event{Kind: EventDue, Title: "due"})
+	if err != nil || got.Kind != "" {
+		t.Errorf("due event was sent: %+v, %v", got, err)
+	}
+}
+
+// fakeSMTP accepts one message and returns what was sent after DATA.
+func fakeSMTP(t *testing.T) (string, <-chan string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	data := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+			case "EHLO", "HELO", "MAIL", "RCPT":
+				reply("250 OK")
+			case "DATA":
+				reply("354 go ahead")
+				var body strings.Builder
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					body.WriteString(line)
+				}
+				data <- body.String()
+				reply("250 OK")
+			case "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("502 unknown")
+			}
+		}
+	}()
+
+	return l.Addr().String(), data
+}
+
+func TestSMTPSink(t *testing.T) {
+	addr, data := fakeSMTP(t)
+	n := testNotifier(t, &NotifyConfig{
+		Sinks: map[string]SinkConfig{
+			"mail": {Type: "smtp", Addr: addr, From: "cb@example.com", To: []string{"me@example.com"}},
+		},
+		Routes: []NotifyRoute{{Sinks: []string{"mail"}}},
+	})
+
+	e := testEvent()
+	e.Title = "BTC is cheap\r\nBcc: someone@example.com"
+	err := n.Notify(testCryptoBill(t), e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := <-data
+	for _, want := range []string{
+		"From: cb@example.com\r\n",
+		"To: me@example.com\r\n",
+		"Subject: BTC is cheap Bcc: someone@example.com\r\n",
+		"\r\n\r\nPBC BTC markup is 0.5%\r\n",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("missing %q in:\n%s", want, msg)
+		}
+	}
+	if strings.Contains(msg, "\r\nBcc:") {
+		t.Errorf("subject added a header:\n%s", msg)
+	}
+}
+
+func TestNotifyRetries(t *testing.T) {
+	var calls, failures int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= atomic.LoadInt32(&failures) {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	n := testNotifier(t, &NotifyConfig{
+		Sinks:      map[string]SinkConfig{"hook": {Type: "webhook", URL: server.URL}},
+		Routes:     []NotifyRoute{{Sinks: []string{"hook"}}},
+		Retries:    2,
+		RetryDelay: "1ms",
+	})
+	cb := testCryptoBill(t)
+
+	// The first attempt fails, and the retry is made in the background.
+	atomic.StoreInt32(&failures, 2)
+	err := n.Notify(cb, testEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = n.Wait()
+	if err != nil || atomic.LoadInt32(&calls) != 3 {
+		t.Errorf("got %v after %v calls, want success on the third", err, calls)
+	}
+
+	// Every attempt fails.
+	atomic.StoreInt32(&calls, 0)
+	atomic.StoreInt32(&failures, 10)
+	err = n.Notify(cb, testEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = n.Wait()
+	if err == nil || !strings.Contains(err.Error(), "503") || atomic.LoadInt32(&calls) != 3 {
+		t.Errorf("got %v after %v calls, want a failure after 3", err, calls)
+	}
+	if err := n.Wait(); err != nil {
+		t.Errorf("failure reported twice: %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package cryptobill
+
+import (
+	"github.com/pkg/errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Option changes a CryptoBill made by NewCryptoBill. An option that can't be applied returns an
+// error, which NewCryptoBill returns.
+type Option func(cb *CryptoBill) error
+
+// WithHTTPClient makes every service request through client, e.g. to record them or add a proxy.
+// Give it a NewProviderTransport to keep the status checks, retries and circuit breaking.
+func WithHTTPClient(client *http.Client) Option {
+	return func(cb *CryptoBill) error {
+		cb.HttpClient = client
+		return nil
+	}
+}
+
+// WithServices quotes and pays through only these services, in this order. Use Register to add
+// aliases or more services later.
+func WithServices(services ...Service) Option {
+	return func(cb *CryptoBill) error {
+		return errors.Wrap(cb.setServices(services), "services")
+	}
+}
+
+// WithOracle takes reference prices from oracle instead of BitcoinAverage.
+func WithOracle(oracle Oracle) Option {
+	return func(cb *CryptoBill) error {
+		cb.Oracle = oracle
+		return nil
+	}
+}
+
+// WithFX converts quotes for services that price in another fiat with fx instead of Frankfurter.
+func WithFX(fx FXSource) Option {
+	return func(cb *CryptoBill) error {
+		cb.FX = fx
+		return nil
+	}
+}
+
+// WithClock makes payments and policy limits use now instead of the system time.
+func WithClock(now func() time.Time) Option {
+	return func(cb *CryptoBill) error {
+		cb.Clock = now
+		return nil
+	}
+}
+
+// WithConfigDir keeps bills, payments and other state in dir instead of DefaultConfigDir.
+func WithConfigDir(dir string) Option {
+	return func(cb *CryptoBill) error {
+		cb.ConfigDir = dir
+		return nil
+	}
+}
+
+// WithStore keeps bills and payments in store instead of files in the config directory.
+func WithStore(store Store) Option {
+	return func(cb *CryptoBill) error {
+		cb.Store = store
+		return nil
+	}
+}
+
+// WithLogger logs what the library does, and the provider transport's retries, to logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(cb *CryptoBill) error {
+		cb.Logger = logger
+		return nil
+	}
+}
+
+// WithTracer puts the tracer underneath the provider transport, so every attempt is traced.
+func WithTracer(tracer *Tracer) Option {
+	return func(cb *CryptoBill) error {
+		if pt, ok := cb.HttpClient.Transport.(*ProviderTransport); ok {
+			tracer.Transport = pt.Transport
+			pt.Transport = tracer
+			return nil
+		}
+
+		tracer.Transport = cb.HttpClient.Transport
+		cb.HttpClient.Transport = tracer
+		return nil
+	}
+}
+
+// WithMetrics collects request latencies, errors, quotes and reference prices into metrics, for
+// MetricsHandler.
+func WithMetrics(metrics *Metrics) Option {
+	return func(cb *CryptoBill) error {
+		cb.Metrics = metrics
+		return nil
+	}
+}
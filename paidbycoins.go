@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"github.com/alecthomas/repr"
 	"github.com/nu7hatch/gouuid"
 	"github.com/pkg/errors"
 	"io"
@@ -29,32 +28,55 @@ func (*PaidByCoins) ShortName() string {
 }
 
 func (*PaidByCoins) Website() string {
-	panic("implement me")
+	return "https://paidbycoins.com"
+}
+
+// Currencies are named by ShortForm, e.g. "BTC", or failing that by Type, e.g. "BitcoinCash".
+var paidByCoinsPairs = mustPairFormat("{crypto}", "AUD", map[string]Currency{
+	"Bitcoin":     "BTC",
+	"BitcoinCash": "BCH",
+	"Ethereum":    "ETH",
+	"Litecoin":    "LTC",
+	"Ripple":      "XRP",
+})
+
+// Fiats is only AUD, which the exchange rates are in and bills are paid in.
+func (pbc *PaidByCoins) Fiats() []Currency {
+	return []Currency{"AUD"}
 }
 
 func (pbc *PaidByCoins) Quote(cb *CryptoBill, info *FiatInfo) ([]QuoteResult, error) {
+	if info.Fiat != "AUD" {
+		return nil, newProviderError(Unsupported, pbc.ShortName(), "fiat", "only quotes in AUD, not %v", info.Fiat)
+	}
+
 	currencies, err := pbc.getCurrencies(cb)
 	if err != nil {
 		return nil, errors.Wrapf(err, "get currencies %+v", info)
 	}
 
+	reader := newPairReader(pbc.ShortName(), paidByCoinsPairs, info.Fiat)
+	defer reader.Log(cb.log())
+
 	var results []QuoteResult
 	for _, currency := range currencies.Items.CurrencyDetails {
-		crypto, err := NewCurrencyFromString(currency.ShortForm)
-		if err != nil {
-			fmt.Println(err.Error())
+		pair, ok := reader.Parse(currency.ShortForm, currency.Type)
+		if !ok {
 			continue
 		}
 
-		exch, err := pbc.exchangeRate(cb, crypto)
+		exch, err := pbc.exchangeRate(cb, pair.Crypto)
 		if err != nil {
 			return nil, err
 		}
+		if !reader.Rate(currency.ShortForm, exch.Price) {
+			continue
+		}
 
-		finalAmount := info.Amount / Amount(exch.Price)
+		finalAmount := currency.cost(info.Amount) / Amount(exch.Price)
 		result := QuoteResult{
 			Service:    pbc,
-			Pair:       Pair{info.Fiat, crypto},
+			Pair:       pair,
 			Conversion: Conversion{info.Amount, finalAmount},
 		}
 		results = append(results, result)
@@ -63,61 +85,44 @@ func (pbc *PaidByCoins) Quote(cb *CryptoBill, info *FiatInfo) ([]QuoteResult, er
 	return results, nil
 }
 
-// TODO: Refactor PayBPAY and PayEFT to reuse same code
 func (pbc *PaidByCoins) PayBPAY(cb *CryptoBill, bpay *PayBPAY) (*PayResult, error) {
-	exchResp, err := pbc.exchangeRate(cb, bpay.Crypto)
-	if err != nil {
-		return nil, errors.Wrap(err, "exchangeRate")
-	}
-
-	currencies, err := pbc.getCurrencies(cb)
-	if err != nil {
-		return nil, errors.Wrap(err, "getCurrencies")
-	}
-
-	var currencyDetail *CurrencyDetail
-	for _, c := range currencies.Items.CurrencyDetails {
-		if strings.EqualFold(c.ShortForm, string(bpay.Crypto)) {
-			currencyDetail = &c
-			break
-		}
-	}
-	if currencyDetail == nil {
-		return nil, errors.New("unknown crypto currency: " + string(bpay.Crypto))
-	}
-
-	txReq, err := newTxReq(exchResp, &bpay.FiatInfo, currencyDetail, bpay.Auth)
-	if err != nil {
-		return nil, errors.Wrap(err, "newTxReq")
-	}
-
-	err = pbc.fillBillerName(cb, bpay)
+	err := pbc.fillBillerName(cb, bpay)
 	if err != nil {
 		return nil, errors.Wrap(err, "fill biller name")
 	}
 
-	txReq.BillerCode = bpay.Code
-	txReq.BillerName = bpay.Name
-	txReq.RefCode = bpay.Account
+	return pbc.pay(cb, &bpay.PayInfoService, newBPAYPreview(pbc, bpay), func(txReq *TransactionAddRequest) {
+		txReq.BillerCode = bpay.Code
+		txReq.BillerName = bpay.Name
+		txReq.RefCode = bpay.Account
+	})
+}
 
-	txAddResp, err := pbc.transactionAdd(cb, txReq)
+func (pbc *PaidByCoins) PayEFT(cb *CryptoBill, eft *PayEFT) (*PayResult, error) {
+	err := pbc.fillBSBName(cb, eft)
 	if err != nil {
-		return nil, errors.Wrap(err, "transactionAdd")
+		return nil, errors.Wrap(err, "fill bsb name")
 	}
 
-	repr.Println(txAddResp)
-
-	// TODO payResult := pbc.makePayResult(txAddResp)
-
-	return nil, nil
+	return pbc.pay(cb, &eft.PayInfoService, newEFTPreview(pbc, eft), func(txReq *TransactionAddRequest) {
+		txReq.BSB = eft.BSB
+		txReq.BSBName = eft.BSBName
+		txReq.AccountNo = eft.AccountNumber
+		txReq.AccountName = eft.AccountName
+		txReq.Description = eft.Remitter
+	})
 }
 
-// TODO: Refactor PayBPAY and PayEFT to reuse same code
-func (pbc *PaidByCoins) PayEFT(cb *CryptoBill, eft *PayEFT) (*PayResult, error) {
-	exchResp, err := pbc.exchangeRate(cb, eft.Crypto)
+// pay builds the transaction, with fill adding who is being paid, and adds it once the preview
+// has been confirmed.
+func (pbc *PaidByCoins) pay(cb *CryptoBill, info *PayInfoService, preview *PayPreview, fill func(*TransactionAddRequest)) (*PayResult, error) {
+	exchResp, err := pbc.exchangeRate(cb, info.Crypto)
 	if err != nil {
 		return nil, errors.Wrap(err, "exchangeRate")
 	}
+	if exchResp.Price <= 0 {
+		return nil, newProviderError(Unavailable, pbc.ShortName(), "crypto", "no rate for %v", info.Crypto)
+	}
 
 	currencies, err := pbc.getCurrencies(cb)
 	if err != nil {
@@ -126,41 +131,36 @@ func (pbc *PaidByCoins) PayEFT(cb *CryptoBill, eft *PayEFT) (*PayResult, error)
 
 	var currencyDetail *CurrencyDetail
 	for _, c := range currencies.Items.CurrencyDetails {
-		if strings.EqualFold(c.ShortForm, string(eft.Crypto)) {
+		if strings.EqualFold(c.ShortForm, string(info.Crypto)) {
 			currencyDetail = &c
 			break
 		}
 	}
 	if currencyDetail == nil {
-		return nil, errors.New("unknown crypto currency: " + string(eft.Crypto))
+		return nil, newProviderError(Unsupported, pbc.ShortName(), "crypto", "%v isn't taken", info.Crypto)
 	}
 
-	txReq, err := newTxReq(exchResp, &eft.FiatInfo, currencyDetail, eft.Auth)
+	txReq, err := newTxReq(exchResp, &info.FiatInfo, currencyDetail, info.Auth)
 	if err != nil {
 		return nil, errors.Wrap(err, "newTxReq")
 	}
+	fill(txReq)
 
-	err = pbc.fillBSBName(cb, eft)
+	preview.Rate = Amount(exchResp.Price)
+	preview.CryptoAmount = currencyDetail.cost(info.Amount) / preview.Rate
+	preview.Fee = Amount(currencyDetail.TransactionCharge)
+	preview.FeePercent = currencyDetail.feePercent()
+	err = cb.confirm(preview)
 	if err != nil {
-		return nil, errors.Wrap(err, "fill bsb name")
+		return nil, err
 	}
 
-	txReq.BSB = eft.BSB
-	txReq.BSBName = eft.BSBName
-	txReq.AccountNo = eft.AccountNumber
-	txReq.AccountName = eft.AccountName
-	txReq.Description = eft.Remitter
-
 	txAddResp, err := pbc.transactionAdd(cb, txReq)
 	if err != nil {
 		return nil, errors.Wrap(err, "transactionAdd")
 	}
 
-	repr.Println(txAddResp)
-
-	// TODO payResult := pbc.makePayResult(txAddResp)
-
-	return nil, nil
+	return &PayResult{Address: txAddResp.ToAddress, Amount: Amount(txAddResp.TotalAmount)}, nil
 }
 
 type VerifyEmailResponse struct {
@@ -174,6 +174,7 @@ func (pbc *PaidByCoins) verifyEmail(cb *CryptoBill, email string) error {
 	if err != nil {
 		return errors.Wrap(err, "request")
 	}
+	defer resp.Body.Close()
 
 	verify := VerifyEmailResponse{}
 	err = json.NewDecoder(resp.Body).Decode(&verify)
@@ -182,11 +183,10 @@ func (pbc *PaidByCoins) verifyEmail(cb *CryptoBill, email string) error {
 	}
 
 	if verify.Message != "" {
-		return errors.New(verify.Message)
+		return messageError(pbc.ShortName(), verify.Message)
 	}
 
-	repr.Println(verify)
-
+	cb.log().Debug("verified email", "service", pbc.ShortName(), "verified", verify.IsVerified)
 	return nil
 }
 
@@ -211,6 +211,7 @@ func (pbc *PaidByCoins) verifyPin(cb *CryptoBill, email, pin string) error {
 	if err != nil {
 		return errors.Wrap(err, "request")
 	}
+	defer resp.Body.Close()
 
 	data, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
@@ -219,7 +220,7 @@ func (pbc *PaidByCoins) verifyPin(cb *CryptoBill, email, pin string) error {
 
 	state := string(data)
 	if state != "true" {
-		return errors.New("unexpected body: " + state)
+		return newProviderError(AuthRequired, pbc.ShortName(), "pin", "pin not accepted: %v", state)
 	}
 
 	return nil
@@ -245,12 +246,24 @@ type CurrencyDetail struct {
 	GSTPercent        float64
 }
 
+// feePercent is the brokerage with GST on top of it.
+func (c *CurrencyDetail) feePercent() float64 {
+	return c.BrokeragePercent * (1 + c.GSTPercent/100)
+}
+
+// cost is what paying an amount comes to in fiat once the fees are added, which is what the crypto
+// amount of a quote or order is worked out from.
+func (c *CurrencyDetail) cost(amount Amount) Amount {
+	return amount*Amount(1+c.feePercent()/100) + Amount(c.TransactionCharge)
+}
+
 func (pbc *PaidByCoins) getCurrencies(cb *CryptoBill) (*CurrenciesResponse, error) {
 	url := "https://api.paidbycoins.com/tran/details"
 	resp, err := pbc.request(cb, "GET", url, nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "request")
 	}
+	defer resp.Body.Close()
 
 	currencies := CurrenciesResponse{}
 	err = json.NewDecoder(resp.Body).Decode(&currencies)
@@ -259,7 +272,7 @@ func (pbc *PaidByCoins) getCurrencies(cb *CryptoBill) (*CurrenciesResponse, erro
 	}
 
 	if currencies.Message != "" {
-		return nil, errors.New(currencies.Message)
+		return nil, messageError(pbc.ShortName(), currencies.Message)
 	}
 
 	return &currencies, nil
@@ -275,6 +288,7 @@ func (pbc *PaidByCoins) orderBook(cb *CryptoBill, currency string) (*OrderBookRe
 	if err != nil {
 		return nil, errors.Wrap(err, "request")
 	}
+	defer resp.Body.Close()
 
 	book := &OrderBookResponse{}
 	err = json.NewDecoder(resp.Body).Decode(book)
@@ -299,6 +313,7 @@ func (pbc *PaidByCoins) exchangeRate(cb *CryptoBill, crypto Currency) (*Exchange
 	if err != nil {
 		return nil, errors.Wrap(err, "request")
 	}
+	defer resp.Body.Close()
 
 	exch := &ExchangeRateResponse{}
 	err = json.NewDecoder(resp.Body).Decode(exch)
@@ -358,6 +373,7 @@ func (pbc *PaidByCoins) transactionAdd(cb *CryptoBill, txReq *TransactionAddRequ
 	if err != nil {
 		return nil, errors.Wrap(err, "request")
 	}
+	defer resp.Body.Close()
 
 	exch := &TransactionAddResponse{}
 	err = json.NewDecoder(resp.Body).Decode(exch)
@@ -366,7 +382,7 @@ func (pbc *PaidByCoins) transactionAdd(cb *CryptoBill, txReq *TransactionAddRequ
 	}
 
 	if exch.Message != "" {
-		return nil, errors.New("msg: " + exch.Message)
+		return nil, messageError(pbc.ShortName(), exch.Message)
 	}
 
 	return exch, nil
@@ -378,11 +394,15 @@ func (pbc *PaidByCoins) fillBillerName(cb *CryptoBill, info *PayBPAY) error {
 	if err != nil {
 		return errors.Wrap(err, "request")
 	}
+	defer resp.Body.Close()
 
 	err = json.NewDecoder(resp.Body).Decode(&info.Name)
 	if err != nil {
 		return errors.Wrap(err, "decoding json from "+url)
 	}
+	if info.Name == "" {
+		return newProviderError(RejectedInput, pbc.ShortName(), "code", "unknown biller code %v", info.Code)
+	}
 
 	return nil
 }
@@ -393,11 +413,15 @@ func (pbc *PaidByCoins) fillBSBName(cb *CryptoBill, info *PayEFT) error {
 	if err != nil {
 		return errors.Wrap(err, "request")
 	}
+	defer resp.Body.Close()
 
 	err = json.NewDecoder(resp.Body).Decode(&info.BSBName)
 	if err != nil {
 		return errors.Wrap(err, "decoding json from "+url)
 	}
+	if info.BSBName == "" {
+		return newProviderError(RejectedInput, pbc.ShortName(), "bsb", "unknown BSB %v", info.BSB)
+	}
 
 	return nil
 }
@@ -410,7 +434,7 @@ func newTxReq(exchResp *ExchangeRateResponse, fiatInfo *FiatInfo, currencyDetail
 		return nil, errors.Wrap(err, "uuid")
 	}
 
-	totalAmount := float64(fiatInfo.Amount) / exchResp.Price
+	totalAmount := float64(currencyDetail.cost(fiatInfo.Amount)) / exchResp.Price
 
 	tranReq := &TransactionAddRequest{
 		SessionID: sessionId.String(),
@@ -445,7 +469,3 @@ func (pbc *PaidByCoins) request(cb *CryptoBill, method, url string, body io.Read
 
 	return cb.HttpClient.Do(req)
 }
-
-func (pbc *PaidByCoins) makePayResult(response *TransactionAddResponse) interface{} {
-	panic("asdf")
-}
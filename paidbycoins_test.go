@@ -0,0 +1,310 @@
+package cryptobill
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func pbcPayInfo(crypto Currency) PayInfoService {
+	return PayInfoService{
+		PayInfo: PayInfo{FiatInfo: FiatInfo{Amount: 100, Fiat: "AUD"}, Crypto: crypto},
+		Service: "PBC",
+		Auth:    testEmail(),
+	}
+}
+
+// sentTransaction decodes the transaction sent to /tran/add.
+func sentTransaction(t *testing.T, r *Replayer) *TransactionAddRequest {
+	t.Helper()
+
+	for _, req := range r.Requests() {
+		if strings.HasSuffix(req.URL, "/tran/add") {
+			tx := &TransactionAddRequest{}
+			err := json.Unmarshal([]byte(req.Body), tx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return tx
+		}
+	}
+
+	t.Fatal("no transaction was added")
+	return nil
+}
+
+func TestPaidByCoinsQuote(t *testing.T) {
+	cb, _ := replayCryptoBill(t, "paidbycoins_quote")
+	info := FiatInfo{Amount: 100, Fiat: "AUD"}
+
+	results, err := NewPaidByCoins().Quote(cb, &info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	amounts := quoteAmounts(t, results, "PBC", info)
+	if len(amounts) != 2 {
+		t.Fatalf("got quotes for %v, want BTC and ETH", amounts)
+	}
+	// 1.5% brokerage with 10% GST on top.
+	assertAmount(t, "BTC", amounts["BTC"], 101.65/8962.5)
+	assertAmount(t, "ETH", amounts["ETH"], 101.65/284.1)
+}
+
+func TestPaidByCoinsQuoteZeroRate(t *testing.T) {
+	cb, _ := replayCryptoBill(t, "paidbycoins_quote_zero")
+	var logs bytes.Buffer
+	cb.Logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	info := FiatInfo{Amount: 100, Fiat: "AUD"}
+
+	results, err := NewPaidByCoins().Quote(cb, &info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	amounts := quoteAmounts(t, results, "PBC", info)
+	if len(amounts) != 1 {
+		t.Fatalf("got quotes for %v, want BTC", amounts)
+	}
+	assertAmount(t, "BTC", amounts["BTC"], 101.65/8962.5)
+	if !strings.Contains(logs.String(), `"key":"ETH","reason":"bad_rate"`) {
+		t.Errorf("skipped ETH wasn't logged: %s", logs.String())
+	}
+}
+
+func TestPaidByCoinsQuoteMessage(t *testing.T) {
+	cb, _ := replayCryptoBill(t, "paidbycoins_quote_message")
+
+	_, err := NewPaidByCoins().Quote(cb, &FiatInfo{Amount: 100, Fiat: "AUD"})
+	if err == nil || !strings.Contains(err.Error(), "Service temporarily unavailable") {
+		t.Fatalf("expected the service's message as an error, got %v", err)
+	}
+	assertKind(t, err, Unavailable, "")
+}
+
+func TestPaidByCoinsPayBPAY(t *testing.T) {
+	skipRecordingOrder(t)
+	cb, r := replayCryptoBill(t, "paidbycoins_pay_bpay")
+	bpay := &PayBPAY{PayInfoService: pbcPayInfo("BTC"), BPAY: BPAY{Code: 23796, Account: "1234567897"}}
+
+	result, err := NewPaidByCoins().PayBPAY(cb, bpay)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Address != "3Jq5kVvYbSNBqTQ9H1ZcPLGqnvTZdP8vTq" || result.Amount != 0.01134 {
+		t.Errorf("got %+v", result)
+	}
+	if bpay.Name != "ORIGIN ENERGY" {
+		t.Errorf("biller name = %q", bpay.Name)
+	}
+
+	tx := sentTransaction(t, r)
+	if tx.BillerCode != 23796 || tx.BillerName != "ORIGIN ENERGY" || tx.RefCode != "1234567897" {
+		t.Errorf("wrong biller in %+v", tx)
+	}
+	if tx.EnteredAmount != 100 || tx.EnteredCurrency != "AUD" || tx.TotalAmount != "0.01134" {
+		t.Errorf("wrong amounts in %+v", tx)
+	}
+	if tx.CurrencyType != "Bitcoin" || tx.QuoteExchgID != 40211 || tx.Email != Redacted {
+		t.Errorf("wrong quote or email in %+v", tx)
+	}
+}
+
+func TestPaidByCoinsPayEFT(t *testing.T) {
+	skipRecordingOrder(t)
+	cb, r := replayCryptoBill(t, "paidbycoins_pay_eft")
+	eft := &PayEFT{
+		PayInfoService: pbcPayInfo("ETH"),
+		EFT:            EFT{BSB: "062-000", AccountNumber: "12345678", AccountName: "J Smith", Remitter: "rent"},
+	}
+
+	result, err := NewPaidByCoins().PayEFT(cb, eft)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Address != "0x52908400098527886E0F7030069857D2E4169EE7" || result.Amount != 0.3578 {
+		t.Errorf("got %+v", result)
+	}
+	if eft.BSBName != "CBA SYDNEY" {
+		t.Errorf("bsb name = %q", eft.BSBName)
+	}
+
+	tx := sentTransaction(t, r)
+	if tx.BSB != "062-000" || tx.BSBName != "CBA SYDNEY" || tx.AccountNo != "12345678" || tx.AccountName != "J Smith" || tx.Description != "rent" {
+		t.Errorf("wrong account in %+v", tx)
+	}
+	if tx.CurrencyType != "Ethereum" || tx.TotalAmount != "0.35780" {
+		t.Errorf("wrong quote in %+v", tx)
+	}
+}
+
+func TestPaidByCoinsPayConfirm(t *testing.T) {
+	skipRecordingOrder(t)
+	cb, r := replayCryptoBill(t, "paidbycoins_pay_bpay")
+	bpay := &PayBPAY{PayInfoService: pbcPayInfo("BTC"), BPAY: BPAY{Code: 23796, Account: "1234567897"}}
+
+	var preview *PayPreview
+	cb.Confirm = func(p *PayPreview) error {
+		preview = p
+		return nil
+	}
+
+	result, err := NewPaidByCoins().PayBPAY(cb, bpay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview == nil {
+		t.Fatal("payment wasn't confirmed")
+	}
+
+	// What was confirmed is what was ordered, fees and all.
+	tx := sentTransaction(t, r)
+	got := fmt.Sprintf("%.5f", preview.CryptoAmount)
+	if got != tx.TotalAmount || got != fmt.Sprintf("%.5f", result.Amount) {
+		t.Errorf("confirmed %v, ordered %v and charged %v", got, tx.TotalAmount, result.Amount)
+	}
+	if preview.Rate != Amount(tx.CurrencyExchRate) || preview.FiatAmount != Amount(tx.EnteredAmount) {
+		t.Errorf("preview %+v doesn't match the order %+v", preview, tx)
+	}
+	cost := preview.FiatAmount*Amount(1+preview.FeePercent/100) + preview.Fee
+	assertAmount(t, "cost", preview.CryptoAmount*preview.Rate, cost)
+}
+
+func TestPaidByCoinsPayRejected(t *testing.T) {
+	skipRecordingOrder(t)
+	cb, _ := replayCryptoBill(t, "paidbycoins_pay_rejected")
+	bpay := &PayBPAY{PayInfoService: pbcPayInfo("BTC"), BPAY: BPAY{Code: 23796, Account: "1234567897"}}
+
+	_, err := NewPaidByCoins().PayBPAY(cb, bpay)
+	if err == nil || !strings.Contains(err.Error(), "Please verify your email address") {
+		t.Fatalf("expected the service's message as an error, got %v", err)
+	}
+	assertKind(t, err, AuthRequired, "")
+}
+
+func TestPaidByCoinsPayDeclined(t *testing.T) {
+	cb, r := replayCryptoBill(t, "paidbycoins_pay_declined")
+	bpay := &PayBPAY{PayInfoService: pbcPayInfo("BTC"), BPAY: BPAY{Code: 23796, Account: "1234567897"}}
+
+	var preview *PayPreview
+	cb.Confirm = func(p *PayPreview) error {
+		preview = p
+		return ErrNotConfirmed
+	}
+
+	_, err := NewPaidByCoins().PayBPAY(cb, bpay)
+	if errors.Cause(err) != ErrNotConfirmed {
+		t.Fatalf("expected ErrNotConfirmed, got %v", err)
+	}
+
+	if preview == nil || preview.Payee != "ORIGIN ENERGY" || preview.Rate != 8962.5 {
+		t.Fatalf("got preview %+v", preview)
+	}
+	assertAmount(t, "fee percent", Amount(preview.FeePercent), 1.65)
+	for _, req := range r.Requests() {
+		if strings.HasSuffix(req.URL, "/tran/add") {
+			t.Error("a declined payment was still added")
+		}
+	}
+}
+
+// openBodies counts response bodies that haven't been closed yet.
+type openBodies struct {
+	next http.RoundTripper
+	open int32
+}
+
+type countedBody struct {
+	io.ReadCloser
+	o *openBodies
+}
+
+func (b *countedBody) Close() error {
+	atomic.AddInt32(&b.o.open, -1)
+	return b.ReadCloser.Close()
+}
+
+func (o *openBodies) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := o.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt32(&o.open, 1)
+	resp.Body = &countedBody{resp.Body, o}
+	return resp, nil
+}
+
+func TestPaidByCoinsClosesBodies(t *testing.T) {
+	skipRecordingOrder(t)
+	cb, _ := replayCryptoBill(t, "paidbycoins_pay_bpay")
+	bodies := &openBodies{next: cb.HttpClient.Transport}
+	cb.HttpClient.Transport = bodies
+	bpay := &PayBPAY{PayInfoService: pbcPayInfo("BTC"), BPAY: BPAY{Code: 23796, Account: "1234567897"}}
+
+	_, err := NewPaidByCoins().PayBPAY(cb, bpay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bodies.open != 0 {
+		t.Errorf("%v response bodies left open", bodies.open)
+	}
+}
+
+func TestPaidByCoinsOrderBook(t *testing.T) {
+	cb, _ := replayCryptoBill(t, "paidbycoins_order_book")
+
+	book, err := NewPaidByCoins().(*PaidByCoins).orderBook(cb, "Bitcoin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.HighestBuy != 8890.02 {
+		t.Errorf("highest buy = %v", book.HighestBuy)
+	}
+}
+
+func TestPaidByCoinsVerifyEmail(t *testing.T) {
+	cb, _ := replayCryptoBill(t, "paidbycoins_verify_email")
+
+	err := NewPaidByCoins().(*PaidByCoins).verifyEmail(cb, testEmail())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cb, _ = replayCryptoBill(t, "paidbycoins_verify_email_message")
+	err = NewPaidByCoins().(*PaidByCoins).verifyEmail(cb, testEmail())
+	perr, ok := AsProviderError(err)
+	if !ok || perr.Message != "Invalid email address" {
+		t.Fatalf("expected the service's message as an error, got %v", err)
+	}
+	assertKind(t, err, AuthRequired, "")
+}
+
+func TestPaidByCoinsVerifyPin(t *testing.T) {
+	cb, r := replayCryptoBill(t, "paidbycoins_verify_pin")
+	pbc := NewPaidByCoins().(*PaidByCoins)
+
+	err := pbc.verifyPin(cb, testEmail(), "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = pbc.verifyPin(cb, testEmail(), "0000")
+	if err == nil {
+		t.Error("expected a wrong pin to fail")
+	}
+
+	body := r.Requests()[0].Body
+	if !strings.Contains(body, `"Pin":"1234"`) || strings.Contains(body, "@") {
+		t.Errorf("unexpected body %v", body)
+	}
+}
@@ -0,0 +1,234 @@
+package cryptobill
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"log/slog"
+	"regexp"
+)
+
+// PairFormat is how a service names the pair each rate is for, e.g. "{fiat}_{crypto}" for
+// "AUD_BTC", "{crypto}Rate" for "BTCRate", or just "{crypto}".
+type PairFormat struct {
+	Pattern string
+
+	// The fiat of every rate when the pattern has no {fiat}.
+	Fiat Currency
+
+	// The service's symbols for currencies that cryptobill names differently, e.g. XBT: BTC.
+	Symbols map[string]Currency
+
+	key *regexp.Regexp
+
+	// Which submatch of key is the crypto and which is the fiat, or zero if not in the pattern.
+	cryptoMatch, fiatMatch int
+}
+
+var pairPlaceholder = regexp.MustCompile(`\{(crypto|fiat)\}`)
+
+// NewPairFormat checks the pattern, which needs a {crypto}. Without a {fiat}, fiat should be given.
+func NewPairFormat(pattern string, fiat Currency, symbols map[string]Currency) (*PairFormat, error) {
+	f := &PairFormat{Pattern: pattern, Fiat: fiat, Symbols: symbols}
+
+	re := "^"
+	last := 0
+	for i, loc := range pairPlaceholder.FindAllStringSubmatchIndex(pattern, -1) {
+		re += regexp.QuoteMeta(pattern[last:loc[0]]) + "(.+?)"
+		if pattern[loc[2]:loc[3]] == "crypto" {
+			f.cryptoMatch = i + 1
+		} else {
+			f.fiatMatch = i + 1
+		}
+		last = loc[1]
+	}
+	re += regexp.QuoteMeta(pattern[last:]) + "$"
+
+	if f.cryptoMatch == 0 {
+		return nil, errors.Errorf("key %q has no {crypto}", pattern)
+	}
+	f.key = regexp.MustCompile(re)
+	return f, nil
+}
+
+func mustPairFormat(pattern string, fiat Currency, symbols map[string]Currency) *PairFormat {
+	f, err := NewPairFormat(pattern, fiat, symbols)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// HasFiat is whether the pattern names the fiat.
+func (f *PairFormat) HasFiat() bool {
+	return f.fiatMatch > 0
+}
+
+// Parse returns the pair key names. The error is a *PairError.
+func (f *PairFormat) Parse(key string) (Pair, error) {
+	match := f.key.FindStringSubmatch(key)
+	if match == nil {
+		return Pair{}, &PairError{Key: key, Reason: MalformedPair}
+	}
+
+	fiat := string(f.Fiat)
+	if f.fiatMatch > 0 {
+		fiat = match[f.fiatMatch]
+	}
+	return f.Pair(key, fiat, match[f.cryptoMatch])
+}
+
+// Pair returns the pair for symbols given separately, such as fields of a list item. An empty fiat
+// is f.Fiat. key is only for the error.
+func (f *PairFormat) Pair(key, fiat, crypto string) (Pair, error) {
+	if fiat == "" {
+		fiat = string(f.Fiat)
+	}
+
+	var pair Pair
+	var err error
+	pair.Fiat, err = f.currency(key, fiat)
+	if err != nil {
+		return Pair{}, err
+	}
+	pair.Crypto, err = f.currency(key, crypto)
+	if err != nil {
+		return Pair{}, err
+	}
+	return pair, nil
+}
+
+func (f *PairFormat) currency(key, symbol string) (Currency, error) {
+	if c, ok := f.Symbols[symbol]; ok {
+		symbol = string(c)
+	}
+
+	c, err := NewCurrencyFromString(symbol)
+	if err != nil {
+		return "", &PairError{Key: key, Reason: UnknownCurrency, Symbol: symbol}
+	}
+	return c, nil
+}
+
+// PairSkipReason is why a rate wasn't quoted.
+type PairSkipReason string
+
+const (
+	// The key isn't in the service's format.
+	MalformedPair PairSkipReason = "malformed"
+
+	// cryptobill doesn't know one of the currencies.
+	UnknownCurrency PairSkipReason = "unknown_currency"
+
+	// The rate is for a fiat other than the one being quoted.
+	OtherFiat PairSkipReason = "other_fiat"
+
+	// The rate is zero or negative, so it can't be divided by.
+	BadRate PairSkipReason = "bad_rate"
+)
+
+// PairError is a rate key that couldn't be used, and why.
+type PairError struct {
+	Key    string
+	Reason PairSkipReason
+
+	// The currency that wasn't known, or was the other fiat.
+	Symbol string
+}
+
+func (e *PairError) Error() string {
+	s := fmt.Sprintf("%q: %v", e.Key, e.Reason)
+	if e.Symbol != "" {
+		s += " " + e.Symbol
+	}
+	return s
+}
+
+// PairReader reads the pairs of a service's rates in one fiat, keeping the keys it skipped.
+type PairReader struct {
+	Service string
+	Format  *PairFormat
+
+	// Only pairs in this fiat are read.
+	Fiat Currency
+
+	Skipped []*PairError
+}
+
+func newPairReader(service string, format *PairFormat, fiat Currency) *PairReader {
+	return &PairReader{Service: service, Format: format, Fiat: fiat}
+}
+
+// Parse returns the pair key names, or false if it was skipped. If several keys are given, such as
+// a symbol and a name, the first that parses is used.
+func (r *PairReader) Parse(keys ...string) (Pair, bool) {
+	first := &PairError{Reason: MalformedPair}
+	for i, key := range keys {
+		pair, err := r.Format.Parse(key)
+		if err == nil {
+			return r.keep(key, pair)
+		}
+		if i == 0 {
+			first = err.(*PairError)
+		}
+	}
+
+	r.skip(first)
+	return Pair{}, false
+}
+
+// Pair is Parse for symbols given separately.
+func (r *PairReader) Pair(key, fiat, crypto string) (Pair, bool) {
+	pair, err := r.Format.Pair(key, fiat, crypto)
+	if err != nil {
+		r.skip(err.(*PairError))
+		return Pair{}, false
+	}
+	return r.keep(key, pair)
+}
+
+// Rate reports whether the rate for key is positive, skipping it as a BadRate if not.
+func (r *PairReader) Rate(key string, rate float64) bool {
+	if rate > 0 {
+		return true
+	}
+	r.skip(&PairError{Key: key, Reason: BadRate})
+	return false
+}
+
+func (r *PairReader) keep(key string, pair Pair) (Pair, bool) {
+	if pair.Fiat != r.Fiat {
+		r.skip(&PairError{Key: key, Reason: OtherFiat, Symbol: string(pair.Fiat)})
+		return Pair{}, false
+	}
+	return pair, true
+}
+
+func (r *PairReader) skip(err *PairError) {
+	r.Skipped = append(r.Skipped, err)
+}
+
+// Quotes returns the quotes made from the pairs read, or says the fiat isn't supported if there
+// were only rates in other fiats.
+func (r *PairReader) Quotes(quotes []QuoteResult) ([]QuoteResult, error) {
+	if len(quotes) > 0 {
+		return quotes, nil
+	}
+	for _, err := range r.Skipped {
+		if err.Reason == OtherFiat {
+			return nil, newProviderError(Unsupported, r.Service, "fiat", "no rates in %v", r.Fiat)
+		}
+	}
+	return quotes, nil
+}
+
+// Log logs each skipped key. Rates in other fiats are expected, so they're only logged at debug.
+func (r *PairReader) Log(logger *slog.Logger) {
+	for _, err := range r.Skipped {
+		level := slog.LevelInfo
+		if err.Reason == OtherFiat {
+			level = slog.LevelDebug
+		}
+		logger.Log(context.Background(), level, "skipping pair", "service", r.Service, "key", err.Key, "reason", err.Reason, "symbol", err.Symbol)
+	}
+}
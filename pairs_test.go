@@ -0,0 +1,110 @@
+package cryptobill
+
+import (
+	"testing"
+)
+
+func TestPairFormatParse(t *testing.T) {
+	symbols := map[string]Currency{"XBT": "BTC", "BitcoinCash": "BCH"}
+
+	for _, tc := range []struct {
+		pattern string
+		fiat    Currency
+		key     string
+		want    Pair
+		reason  PairSkipReason
+		symbol  string
+	}{
+		{"{fiat}_{crypto}", "", "AUD_BTC", Pair{"AUD", "BTC"}, "", ""},
+		{"{fiat}_{crypto}", "", "usd_eth", Pair{"USD", "ETH"}, "", ""},
+		{"{fiat}_{crypto}", "", "AUD", Pair{}, MalformedPair, ""},
+		{"{fiat}_{crypto}", "", "AUD_", Pair{}, MalformedPair, ""},
+		{"{fiat}_{crypto}", "", "AUD_NOPE", Pair{}, UnknownCurrency, "NOPE"},
+		{"{fiat}_{crypto}", "", "XYZ_BTC", Pair{}, UnknownCurrency, "XYZ"},
+		{"{crypto}Rate", "AUD", "BTCRate", Pair{"AUD", "BTC"}, "", ""},
+		{"{crypto}Rate", "AUD", "XBTRate", Pair{"AUD", "BTC"}, "", ""},
+		{"{crypto}Rate", "AUD", "Rate", Pair{}, MalformedPair, ""},
+		{"{crypto}Rate", "AUD", "BTCPrice", Pair{}, MalformedPair, ""},
+		{"{crypto}", "AUD", "BitcoinCash", Pair{"AUD", "BCH"}, "", ""},
+		{"{crypto}.{fiat}", "", "ltc.aud", Pair{"AUD", "LTC"}, "", ""},
+	} {
+		f, err := NewPairFormat(tc.pattern, tc.fiat, symbols)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pair, err := f.Parse(tc.key)
+		if tc.reason == "" {
+			if err != nil || pair != tc.want {
+				t.Errorf("%v %q = %v, %v, want %v", tc.pattern, tc.key, pair, err, tc.want)
+			}
+			continue
+		}
+
+		perr, ok := err.(*PairError)
+		if !ok || perr.Reason != tc.reason || perr.Symbol != tc.symbol || perr.Key != tc.key {
+			t.Errorf("%v %q = %v, %#v, want %v %q", tc.pattern, tc.key, pair, err, tc.reason, tc.symbol)
+		}
+	}
+
+	_, err := NewPairFormat("{fiat}_rate", "", nil)
+	if err == nil {
+		t.Error("expected an error for a pattern without {crypto}")
+	}
+}
+
+func TestPairReader(t *testing.T) {
+	r := newPairReader("LROS", livingRoomPairs, "AUD")
+
+	var read []Pair
+	for _, key := range []string{"AUD_BTC", "USD_BTC", "AUD", "AUD_NOPE", "AUD_ETH"} {
+		if pair, ok := r.Parse(key); ok {
+			read = append(read, pair)
+		}
+	}
+	if len(read) != 2 || read[0] != (Pair{"AUD", "BTC"}) || read[1] != (Pair{"AUD", "ETH"}) {
+		t.Errorf("read %v", read)
+	}
+
+	want := []PairError{
+		{Key: "USD_BTC", Reason: OtherFiat, Symbol: "USD"},
+		{Key: "AUD", Reason: MalformedPair},
+		{Key: "AUD_NOPE", Reason: UnknownCurrency, Symbol: "NOPE"},
+	}
+	if len(r.Skipped) != len(want) {
+		t.Fatalf("skipped %v, want %v", r.Skipped, want)
+	}
+	for i, skipped := range r.Skipped {
+		if *skipped != want[i] {
+			t.Errorf("skipped %v, want %v", *skipped, want[i])
+		}
+	}
+
+	// PBC names currencies by symbol, or failing that by type.
+	r = newPairReader("PBC", paidByCoinsPairs, "AUD")
+	pair, ok := r.Parse("BCC", "BitcoinCash")
+	if !ok || pair != (Pair{"AUD", "BCH"}) || len(r.Skipped) != 0 {
+		t.Errorf("got %v, %v, skipped %v", pair, ok, r.Skipped)
+	}
+	_, ok = r.Parse("NOPE", "Nopecoin")
+	if ok || len(r.Skipped) != 1 || r.Skipped[0].Key != "NOPE" || r.Skipped[0].Reason != UnknownCurrency {
+		t.Errorf("got %v, skipped %v", ok, r.Skipped)
+	}
+}
+
+func TestPairReaderQuotes(t *testing.T) {
+	r := newPairReader("LROS", livingRoomPairs, "NZD")
+	r.Parse("AUD_BTC")
+
+	// Only rates in other fiats means the fiat isn't supported.
+	_, err := r.Quotes(nil)
+	assertKind(t, err, Unsupported, "fiat")
+
+	// Nothing at all is just no quotes.
+	r = newPairReader("LROS", livingRoomPairs, "NZD")
+	r.Parse("AUD_NOPE")
+	quotes, err := r.Quotes(nil)
+	if err != nil || len(quotes) != 0 {
+		t.Errorf("got %v, %v", quotes, err)
+	}
+}
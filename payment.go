@@ -0,0 +1,413 @@
+package cryptobill
+
+import (
+	"encoding/json"
+	"github.com/nu7hatch/gouuid"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Payment statuses.
+const (
+	// The service gave an address to send the crypto to.
+	PaymentPrepared = "prepared"
+
+	// The service refused or couldn't be reached.
+	PaymentFailed = "failed"
+
+	// The policy wants the payment approved before the service is asked.
+	PaymentHeld = "held"
+
+	// The service is being asked. It counts towards the policy's limits, and can't be approved
+	// again meanwhile.
+	PaymentSubmitting = "submitting"
+
+	// Reported by services that track payments: the crypto has arrived, and the bill has been paid.
+	PaymentReceived = "received"
+	PaymentPaid     = "paid"
+)
+
+// ErrNoSuchPayment is returned when a payment ID isn't known.
+var ErrNoSuchPayment = errors.New("no such payment")
+
+// Payment is a record of asking a service to pay a bill.
+type Payment struct {
+	ID           string    `json:"id"`
+	Bill         string    `json:"bill"`
+	Service      string    `json:"service"`
+	Crypto       Currency  `json:"crypto"`
+	Fiat         Currency  `json:"fiat"`
+	FiatAmount   Amount    `json:"fiatAmount"`
+	CryptoAmount Amount    `json:"cryptoAmount,omitempty"`
+	Address      string    `json:"address,omitempty"`
+	Status       string    `json:"status"`
+	Error        string    `json:"error,omitempty"`
+	ErrorKind    ErrorKind `json:"errorKind,omitempty"`
+	Created      time.Time `json:"created"`
+}
+
+func (cb *CryptoBill) paymentsPath() (string, error) {
+	dir, err := cb.stateDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "payments.json"), nil
+}
+
+// Pay checks the policy, then asks the service to pay the named bill and records the payment. A
+// payment that needs approving is recorded as held instead. A payment that fails is still recorded
+// and returned along with the error, but one refused by the policy or stopped by Confirm isn't
+// recorded.
+func (cb *CryptoBill) Pay(name string, info *PayInfoService) (*Payment, error) {
+	bill, err := cb.GetBill(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "get bill")
+	}
+
+	payment, err := cb.newPayment(name, info)
+	if err != nil {
+		return nil, err
+	}
+
+	check, err := cb.newPolicyCheck(payment)
+	if err != nil {
+		return nil, err
+	}
+
+	// Checked and recorded under the lock, so payments made at the same time can't all fit under
+	// a limit that only has room for one.
+	approval := false
+	err = cb.updatePayments(func(payments []*Payment) ([]*Payment, error) {
+		approval, err = check.evaluate(payment, payments)
+		if err != nil {
+			return nil, err
+		}
+
+		payment.Status = PaymentSubmitting
+		if approval {
+			payment.Status = PaymentHeld
+		}
+		return append(payments, payment), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if approval {
+		cb.log().Info("payment held for approval", "id", payment.ID, "bill", payment.Bill, "service", payment.Service)
+		return payment, nil
+	}
+
+	return cb.submitPayment(bill, payment, info, "")
+}
+
+// CheckPolicy reports whether paying the named bill is allowed, and if so, whether it would be held
+// for approval. Nothing is recorded.
+func (cb *CryptoBill) CheckPolicy(name string, info *PayInfoService) (bool, error) {
+	payment, err := cb.newPayment(name, info)
+	if err != nil {
+		return false, err
+	}
+
+	return cb.checkPolicy(payment)
+}
+
+func (cb *CryptoBill) newPayment(name string, info *PayInfoService) (*Payment, error) {
+	// Aliases are recorded by short name, so the policy and history see one name per service.
+	s, err := cb.Service(info.Service)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, errors.Wrap(err, "uuid")
+	}
+
+	return &Payment{
+		ID:         id.String(),
+		Bill:       name,
+		Service:    s.ShortName(),
+		Crypto:     info.Crypto,
+		Fiat:       info.Fiat,
+		FiatAmount: info.Amount,
+		Created:    cb.now(),
+	}, nil
+}
+
+// Approve pays a held payment, after checking the rest of the policy again. auth is taken from the
+// vault if empty.
+func (cb *CryptoBill) Approve(id, auth string) (*Payment, error) {
+	payment, err := cb.GetPayment(id)
+	if err != nil {
+		return nil, err
+	}
+
+	bill, err := cb.GetBill(payment.Bill)
+	if err != nil {
+		return nil, errors.Wrap(err, "get bill")
+	}
+
+	check, err := cb.newPolicyCheck(payment)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only one approval gets to move it from held to submitting.
+	err = cb.updatePayments(func(payments []*Payment) ([]*Payment, error) {
+		for _, p := range payments {
+			if p.ID != id {
+				continue
+			}
+			if p.Status != PaymentHeld {
+				return nil, errors.Errorf("payment %v is %v, only held payments can be approved", id, p.Status)
+			}
+
+			_, err := check.evaluate(p, payments)
+			if err != nil {
+				return nil, err
+			}
+
+			p.Status = PaymentSubmitting
+			payment = p
+			return payments, nil
+		}
+		return nil, errors.Wrap(ErrNoSuchPayment, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	info := &PayInfoService{
+		PayInfo: PayInfo{FiatInfo: FiatInfo{Amount: payment.FiatAmount, Fiat: payment.Fiat}, Crypto: payment.Crypto},
+		Service: payment.Service,
+		Auth:    auth,
+	}
+	return cb.submitPayment(bill, payment, info, PaymentHeld)
+}
+
+// submitPayment asks the service to pay and saves the outcome. If nothing was sent to the service,
+// the payment goes back to its previous status, or is removed if it had none.
+func (cb *CryptoBill) submitPayment(bill *Bill, payment *Payment, info *PayInfoService, previous string) (*Payment, error) {
+	var result *PayResult
+	var err error
+	switch bill.Type() {
+	case "BPAY":
+		result, err = cb.payBPAY(&PayBPAY{PayInfoService: *info, BPAY: bill.BPAY})
+		err = errors.Wrap(err, "pay bpay")
+	case "EFT":
+		result, err = cb.payEFT(&PayEFT{PayInfoService: *info, EFT: bill.EFT})
+		err = errors.Wrap(err, "pay eft")
+	default:
+		err = errors.New("bill error, could not find data")
+	}
+
+	// Nothing was sent to the service, so there's nothing to record.
+	switch errors.Cause(err) {
+	case ErrNotConfirmed, ErrDryRun:
+		undoErr := cb.undoPayment(payment, previous)
+		if undoErr != nil {
+			cb.log().Error("couldn't undo payment", "id", payment.ID, "error", undoErr)
+		}
+		return nil, err
+	}
+	if err == nil && result == nil {
+		err = errors.New(payment.Service + " didn't say where to send the crypto")
+	}
+
+	if err != nil {
+		payment.Status = PaymentFailed
+		payment.Error = err.Error()
+		if perr, ok := AsProviderError(err); ok {
+			payment.ErrorKind = perr.Kind
+		}
+		cb.log().Info("payment failed", "id", payment.ID, "bill", payment.Bill, "service", payment.Service, "kind", payment.ErrorKind, "error", err)
+	} else {
+		payment.Status = PaymentPrepared
+		payment.Address = result.Address
+		payment.CryptoAmount = result.Amount
+		cb.log().Info("payment prepared", "id", payment.ID, "bill", payment.Bill, "service", payment.Service)
+	}
+
+	saveErr := cb.savePayment(payment)
+	if err != nil {
+		return payment, err
+	}
+
+	return payment, errors.Wrap(saveErr, "save payment")
+}
+
+// undoPayment puts the payment back to status, or removes it if status is empty.
+func (cb *CryptoBill) undoPayment(payment *Payment, status string) error {
+	if status != "" {
+		payment.Status = status
+		return cb.savePayment(payment)
+	}
+
+	return cb.updatePayments(func(payments []*Payment) ([]*Payment, error) {
+		var kept []*Payment
+		for _, p := range payments {
+			if p.ID != payment.ID {
+				kept = append(kept, p)
+			}
+		}
+		return kept, nil
+	})
+}
+
+// savePayment adds the payment, or replaces it if it's already recorded.
+func (cb *CryptoBill) savePayment(payment *Payment) error {
+	return cb.updatePayments(func(payments []*Payment) ([]*Payment, error) {
+		for i, p := range payments {
+			if p.ID == payment.ID {
+				payments[i] = payment
+				return payments, nil
+			}
+		}
+		return append(payments, payment), nil
+	})
+}
+
+// TrackPayment asks the service what has happened to a payment that is under way, and saves the
+// new status. Payments to services that aren't a PaymentTracker are returned unchanged.
+func (cb *CryptoBill) TrackPayment(id string) (*Payment, error) {
+	payment, err := cb.GetPayment(id)
+	if err != nil {
+		return nil, err
+	}
+	if payment.Status != PaymentPrepared && payment.Status != PaymentReceived {
+		return payment, nil
+	}
+
+	s, err := cb.Service(payment.Service)
+	if err != nil {
+		return nil, err
+	}
+	tracker, ok := s.(PaymentTracker)
+	if !ok {
+		return payment, nil
+	}
+
+	start := time.Now()
+	status, err := tracker.PaymentStatus(cb, payment)
+	err = classify(s.ShortName(), err)
+	cb.observe(s.ShortName(), "status", start, err)
+	if err != nil {
+		return nil, errors.Wrap(err, "payment status")
+	}
+	if status == payment.Status {
+		return payment, nil
+	}
+
+	payment.Status = status
+	return payment, errors.Wrap(cb.savePayment(payment), "save payment")
+}
+
+// Payments returns every recorded payment, newest first.
+func (cb *CryptoBill) Payments() ([]*Payment, error) {
+	var payments []*Payment
+	err := cb.withPayments(false, func(loaded []*Payment) ([]*Payment, error) {
+		payments = loaded
+		return loaded, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(payments, func(i, j int) bool {
+		return payments[i].Created.After(payments[j].Created)
+	})
+	return payments, nil
+}
+
+func (cb *CryptoBill) GetPayment(id string) (*Payment, error) {
+	payments, err := cb.Payments()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range payments {
+		if p.ID == id {
+			return p, nil
+		}
+	}
+
+	return nil, errors.Wrap(ErrNoSuchPayment, id)
+}
+
+func (cb *CryptoBill) updatePayments(fn func([]*Payment) ([]*Payment, error)) error {
+	return cb.withPayments(true, fn)
+}
+
+// withPayments loads the payments and passes them to fn. If save is set, they're loaded under lock
+// and the payments fn returns are written back before it's released.
+func (cb *CryptoBill) withPayments(save bool, fn func([]*Payment) ([]*Payment, error)) error {
+	if cb.Store != nil {
+		return cb.Store.WithPayments(save, fn)
+	}
+
+	path, err := cb.paymentsPath()
+	if err != nil {
+		return errors.Wrap(err, "payments path")
+	}
+
+	if !save {
+		payments, err := loadPayments(path)
+		if err != nil {
+			return err
+		}
+		_, err = fn(payments)
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return errors.Wrap(err, "mkdir")
+	}
+
+	unlock, err := lockPath(path)
+	if err != nil {
+		return errors.Wrap(err, "lock payments")
+	}
+	defer unlock()
+
+	payments, err := loadPayments(path)
+	if err != nil {
+		return err
+	}
+
+	payments, err = fn(payments)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(payments, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encode json")
+	}
+
+	return writeFileAtomic(path, data, 0600)
+}
+
+func loadPayments(path string) ([]*Payment, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read payments")
+	}
+
+	var payments []*Payment
+	err = json.Unmarshal(data, &payments)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode json from "+path)
+	}
+
+	return payments, nil
+}
@@ -0,0 +1,261 @@
+package cryptobill
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PolicyRule limits payments. Zero values mean no limit.
+type PolicyRule struct {
+	// Most fiat that may be paid in a calendar day or month, counting held payments.
+	DailyLimit   Amount
+	MonthlyLimit Amount
+
+	// Highest markup over the reference price, in percent.
+	MaxMarkup float64
+
+	// Allowed services and coins, e.g. ["PBC"] and ["BTC", "ETH"].
+	Services []string
+	Cryptos  []string
+
+	// Hold payments, or payments over an amount, until they are approved.
+	RequireApproval bool
+	ApprovalOver    Amount
+}
+
+// Policy is checked before a service is asked to pay. The top-level rule covers all payments
+// together and each bill's rule covers payments to that bill. Both have to pass.
+type Policy struct {
+	PolicyRule
+
+	// Limits are in this currency. Defaults to AUD.
+	Fiat Currency
+
+	Bills map[string]PolicyRule
+}
+
+// PolicyError lists the ways a payment breaks the policy.
+type PolicyError struct {
+	Violations []string
+}
+
+func (e *PolicyError) Error() string {
+	return "against policy: " + strings.Join(e.Violations, "; ")
+}
+
+// LoadPolicy reads policy.json from dir. A missing file allows everything.
+func LoadPolicy(dir string) (*Policy, error) {
+	p := &Policy{Fiat: "AUD"}
+
+	path := filepath.Join(dir, "policy.json")
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read "+path)
+	}
+
+	err = json.Unmarshal(data, p)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode json from "+path)
+	}
+
+	return p, nil
+}
+
+func (cb *CryptoBill) policy() (*Policy, error) {
+	if cb.Policy == nil {
+		dir, err := cb.configDir()
+		if err != nil {
+			return nil, err
+		}
+
+		p, err := LoadPolicy(dir)
+		if err != nil {
+			return nil, errors.Wrap(err, "load policy")
+		}
+		cb.Policy = p
+	}
+
+	return cb.Policy, nil
+}
+
+// checkPolicy returns a *PolicyError if the payment isn't allowed, and whether it needs approving
+// first. Earlier payments count towards the limits, except the payment itself.
+func (cb *CryptoBill) checkPolicy(payment *Payment) (bool, error) {
+	check, err := cb.newPolicyCheck(payment)
+	if err != nil {
+		return false, err
+	}
+
+	payments, err := cb.Payments()
+	if err != nil {
+		return false, err
+	}
+
+	return check.evaluate(payment, payments)
+}
+
+// policyCheck is what checking a payment needs besides the earlier payments. It's made before
+// locking the payments, since finding the markup means asking the service for a quote.
+type policyCheck struct {
+	policy *Policy
+	cal    *Calendar
+	now    time.Time
+
+	markup    float64
+	markupErr error
+}
+
+func (cb *CryptoBill) newPolicyCheck(payment *Payment) (*policyCheck, error) {
+	p, err := cb.policy()
+	if err != nil {
+		return nil, err
+	}
+
+	cal, err := cb.calendar()
+	if err != nil {
+		return nil, err
+	}
+
+	check := &policyCheck{policy: p, cal: cal, now: cb.now().In(cal.Location)}
+
+	// One quote is enough for both rules.
+	if p.MaxMarkup > 0 || p.Bills[payment.Bill].MaxMarkup > 0 {
+		check.markup, check.markupErr = cb.paymentMarkup(payment)
+	}
+
+	return check, nil
+}
+
+// evaluate checks the payment against payments, the ones recorded so far.
+func (c *policyCheck) evaluate(payment *Payment, payments []*Payment) (bool, error) {
+	p := c.policy
+	rules := []PolicyRule{p.PolicyRule}
+	if rule, ok := p.Bills[payment.Bill]; ok {
+		rules = append(rules, rule)
+	}
+
+	var violations []string
+	approval := false
+	for i, rule := range rules {
+		scope := "all bills"
+		var history []*Payment
+		for _, earlier := range payments {
+			if earlier.ID == payment.ID {
+				continue
+			}
+			if i == 0 || earlier.Bill == payment.Bill {
+				history = append(history, earlier)
+			}
+		}
+		if i > 0 {
+			scope = "bill '" + payment.Bill + "'"
+		}
+
+		if len(rule.Services) > 0 && !containsFold(rule.Services, payment.Service) {
+			violations = append(violations, fmt.Sprintf("%v isn't allowed for %v", payment.Service, scope))
+		}
+		if len(rule.Cryptos) > 0 && !containsFold(rule.Cryptos, string(payment.Crypto)) {
+			violations = append(violations, fmt.Sprintf("%v isn't allowed for %v", payment.Crypto, scope))
+		}
+
+		if rule.DailyLimit > 0 || rule.MonthlyLimit > 0 {
+			if payment.Fiat != p.Fiat {
+				violations = append(violations, fmt.Sprintf("limits are in %v, not %v", p.Fiat, payment.Fiat))
+			} else {
+				day, month := spent(history, p.Fiat, c.now)
+				if rule.DailyLimit > 0 && day+payment.FiatAmount > rule.DailyLimit {
+					violations = append(violations, fmt.Sprintf("over the daily limit of %.2f %v for %v, %.2f already paid today", rule.DailyLimit, p.Fiat, scope, day))
+				}
+				if rule.MonthlyLimit > 0 && month+payment.FiatAmount > rule.MonthlyLimit {
+					violations = append(violations, fmt.Sprintf("over the monthly limit of %.2f %v for %v, %.2f already paid this month", rule.MonthlyLimit, p.Fiat, scope, month))
+				}
+			}
+		}
+
+		if rule.MaxMarkup > 0 {
+			if c.markupErr != nil {
+				violations = append(violations, "couldn't check the markup: "+c.markupErr.Error())
+			} else if c.markup > rule.MaxMarkup {
+				violations = append(violations, fmt.Sprintf("markup of %.3f%% is over %.3f%% for %v", c.markup, rule.MaxMarkup, scope))
+			}
+		}
+
+		if rule.RequireApproval || (rule.ApprovalOver > 0 && payment.FiatAmount > rule.ApprovalOver) {
+			approval = true
+		}
+	}
+
+	if len(violations) > 0 {
+		return false, &PolicyError{Violations: violations}
+	}
+	return approval, nil
+}
+
+// spent adds up the payments in fiat on the day and in the month of now. Failed payments don't
+// count, but ones still being submitted do.
+func spent(payments []*Payment, fiat Currency, now time.Time) (day, month Amount) {
+	for _, p := range payments {
+		if p.Status == PaymentFailed || p.Fiat != fiat {
+			continue
+		}
+
+		created := p.Created.In(now.Location())
+		if created.Year() != now.Year() || created.Month() != now.Month() {
+			continue
+		}
+		month += p.FiatAmount
+		if created.Day() == now.Day() {
+			day += p.FiatAmount
+		}
+	}
+	return day, month
+}
+
+// paymentMarkup quotes the payment's service to find its markup over the reference price.
+func (cb *CryptoBill) paymentMarkup(payment *Payment) (float64, error) {
+	s, err := cb.Service(payment.Service)
+	if err != nil {
+		return 0, err
+	}
+
+	quotes, err := cb.quote(s, &FiatInfo{Amount: payment.FiatAmount, Fiat: payment.Fiat})
+	if err != nil {
+		return 0, errors.Wrap(err, "quote")
+	}
+
+	for _, q := range quotes {
+		if q.Pair.Crypto != payment.Crypto {
+			continue
+		}
+
+		reference, err := cb.ReferencePrice(q.Pair)
+		if err != nil {
+			return 0, errors.Wrap(err, "reference price")
+		}
+		if reference <= 0 {
+			// The markup would be -100%, which passes any limit.
+			return 0, errors.Errorf("no reference price for %v", q.Pair.Crypto)
+		}
+		return NewMarkup(q, reference).Percent, nil
+	}
+
+	return 0, errors.New(payment.Service + " has no quote for " + string(payment.Crypto))
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,235 @@
+package cryptobill
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func policyCryptoBill(t *testing.T, policy *Policy) (*CryptoBill, *time.Time) {
+	t.Helper()
+
+	config := &SandboxConfig{
+		Fiat:      "AUD",
+		Reference: map[Currency]Amount{"BTC": 10000, "ETH": 300},
+		Services: map[string]FakeServiceConfig{
+			"CHEAP": {Markup: 1},
+			"DEAR":  {Markup: 5},
+		},
+	}
+	services, err := config.NewServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2018, 11, 14, 12, 0, 0, 0, time.UTC)
+	cb := testCryptoBill(t,
+		WithServices(services...),
+		WithOracle(&FixedOracle{Fiat: "AUD", Prices: config.Reference}),
+		WithConfigDir(t.TempDir()),
+		WithClock(func() time.Time { return now }),
+	)
+	if policy.Fiat == "" {
+		policy.Fiat = "AUD"
+	}
+	cb.Policy = policy
+
+	for _, bill := range []*Bill{
+		{Name: "power", BPAY: BPAY{Code: 23796, Account: "79927398713"}},
+		{Name: "rent", EFT: EFT{BSB: "062000", AccountNumber: "12345678", AccountName: "Landlord"}},
+	} {
+		err := cb.AddBill(bill, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return cb, &now
+}
+
+func payFor(cb *CryptoBill, bill, service string, crypto Currency, amount Amount) (*Payment, error) {
+	return cb.Pay(bill, &PayInfoService{
+		PayInfo: PayInfo{FiatInfo: FiatInfo{Amount: amount, Fiat: "AUD"}, Crypto: crypto},
+		Service: service,
+		Auth:    "me@example.com",
+	})
+}
+
+// assertPolicy checks err is a *PolicyError mentioning each of want, or nil if want is empty.
+func assertPolicy(t *testing.T, what string, err error, want ...string) {
+	t.Helper()
+
+	if len(want) == 0 {
+		if err != nil {
+			t.Errorf("%v: %v", what, err)
+		}
+		return
+	}
+
+	perr, ok := err.(*PolicyError)
+	if !ok {
+		t.Errorf("%v: got %v, want a policy error", what, err)
+		return
+	}
+	if len(perr.Violations) != len(want) {
+		t.Errorf("%v: got %v, want %v", what, perr.Violations, want)
+	}
+	for _, w := range want {
+		if !strings.Contains(perr.Error(), w) {
+			t.Errorf("%v: %v doesn't mention %q", what, perr, w)
+		}
+	}
+}
+
+func TestPolicyLimits(t *testing.T) {
+	cb, now := policyCryptoBill(t, &Policy{
+		PolicyRule: PolicyRule{DailyLimit: 100, MonthlyLimit: 250},
+		Bills:      map[string]PolicyRule{"power": {MonthlyLimit: 120}},
+	})
+
+	_, err := payFor(cb, "power", "CHEAP", "BTC", 80)
+	assertPolicy(t, "first", err)
+	_, err = payFor(cb, "power", "CHEAP", "BTC", 30)
+	assertPolicy(t, "same day", err, "daily limit of 100.00 AUD for all bills, 80.00 already paid today")
+
+	*now = now.AddDate(0, 0, 1)
+	_, err = payFor(cb, "power", "CHEAP", "BTC", 50)
+	assertPolicy(t, "bill's month", err, "monthly limit of 120.00 AUD for bill 'power', 80.00 already paid this month")
+	_, err = payFor(cb, "rent", "CHEAP", "BTC", 90)
+	assertPolicy(t, "other bill", err)
+
+	*now = now.AddDate(0, 0, 1)
+	_, err = payFor(cb, "rent", "CHEAP", "BTC", 90)
+	assertPolicy(t, "month", err, "monthly limit of 250.00 AUD for all bills, 170.00 already paid this month")
+
+	// Next month starts again.
+	*now = time.Date(2018, 12, 1, 12, 0, 0, 0, time.UTC)
+	_, err = payFor(cb, "rent", "CHEAP", "BTC", 90)
+	assertPolicy(t, "next month", err)
+
+	// Limits are only in one fiat.
+	_, err = cb.Pay("rent", &PayInfoService{
+		PayInfo: PayInfo{FiatInfo: FiatInfo{Amount: 1, Fiat: "USD"}, Crypto: "BTC"},
+		Service: "CHEAP",
+	})
+	assertPolicy(t, "fiat", err, "limits are in AUD, not USD")
+}
+
+func TestPolicyConcurrentPayments(t *testing.T) {
+	cb, _ := policyCryptoBill(t, &Policy{PolicyRule: PolicyRule{DailyLimit: 100}})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := payFor(cb, "power", "CHEAP", "BTC", 60)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	paid := 0
+	for err := range errs {
+		if err == nil {
+			paid++
+		} else if _, ok := err.(*PolicyError); !ok {
+			t.Error(err)
+		}
+	}
+	if paid != 1 {
+		t.Errorf("%v payments fit under the limit, want 1", paid)
+	}
+}
+
+func TestPolicyRoutes(t *testing.T) {
+	cb, _ := policyCryptoBill(t, &Policy{
+		PolicyRule: PolicyRule{Services: []string{"cheap"}},
+		Bills:      map[string]PolicyRule{"rent": {Cryptos: []string{"BTC"}}},
+	})
+
+	_, err := payFor(cb, "power", "DEAR", "BTC", 10)
+	assertPolicy(t, "service", err, "DEAR isn't allowed for all bills")
+	_, err = payFor(cb, "rent", "DEAR", "ETH", 10)
+	assertPolicy(t, "both", err, "DEAR isn't allowed for all bills", "ETH isn't allowed for bill 'rent'")
+	_, err = payFor(cb, "power", "CHEAP", "ETH", 10)
+	assertPolicy(t, "other bill", err)
+
+	payments, err := cb.Payments()
+	if err != nil || len(payments) != 1 {
+		t.Errorf("got %v payments, %v, want refused ones left out", len(payments), err)
+	}
+}
+
+func TestPolicyMarkup(t *testing.T) {
+	cb, _ := policyCryptoBill(t, &Policy{PolicyRule: PolicyRule{MaxMarkup: 2}})
+
+	_, err := payFor(cb, "power", "CHEAP", "BTC", 10)
+	assertPolicy(t, "cheap", err)
+	_, err = payFor(cb, "power", "DEAR", "BTC", 10)
+	assertPolicy(t, "dear", err, "is over 2.000%")
+
+	// Without a reference price the markup can't be checked, rather than being -100%.
+	cb.Oracle = &FixedOracle{Fiat: "AUD", Prices: map[Currency]Amount{"BTC": 0}}
+	_, err = payFor(cb, "power", "DEAR", "BTC", 10)
+	assertPolicy(t, "no reference", err, "couldn't check the markup")
+}
+
+func TestPolicyApproval(t *testing.T) {
+	cb, _ := policyCryptoBill(t, &Policy{
+		PolicyRule: PolicyRule{ApprovalOver: 100, DailyLimit: 500},
+		Bills:      map[string]PolicyRule{"rent": {RequireApproval: true}},
+	})
+
+	payment, err := payFor(cb, "power", "CHEAP", "BTC", 50)
+	if err != nil || payment.Status != PaymentPrepared {
+		t.Fatalf("got %+v, %v, want it paid", payment, err)
+	}
+
+	held, err := payFor(cb, "power", "CHEAP", "BTC", 150)
+	if err != nil || held.Status != PaymentHeld || held.Address != "" {
+		t.Fatalf("got %+v, %v, want it held", held, err)
+	}
+	rent, err := payFor(cb, "rent", "CHEAP", "BTC", 10)
+	if err != nil || rent.Status != PaymentHeld {
+		t.Fatalf("got %+v, %v, want it held", rent, err)
+	}
+
+	// Held payments count towards the limits.
+	_, err = payFor(cb, "power", "CHEAP", "BTC", 300)
+	assertPolicy(t, "limit", err, "210.00 already paid today")
+
+	// Only one of several approvals at once pays.
+	var wg sync.WaitGroup
+	approved := make(chan *Payment, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p, err := cb.Approve(held.ID, "me@example.com")
+			if err == nil {
+				approved <- p
+			} else if !strings.Contains(err.Error(), "only held payments can be approved") {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(approved)
+
+	var paid []*Payment
+	for p := range approved {
+		paid = append(paid, p)
+	}
+	if len(paid) != 1 || paid[0].Status != PaymentPrepared || paid[0].Address == "" {
+		t.Fatalf("approved %+v, want one prepared payment", paid)
+	}
+
+	got, err := cb.GetPayment(held.ID)
+	if err != nil || got.Status != PaymentPrepared {
+		t.Errorf("saved %+v, %v", got, err)
+	}
+}
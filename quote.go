@@ -1,18 +1,96 @@
 package cryptobill
 
-import "github.com/hashicorp/go-multierror"
+import (
+	"context"
+	"github.com/hashicorp/go-multierror"
+	"sync"
+	"time"
+)
+
+// ServiceQuotes is one service's answer to a quote.
+type ServiceQuotes struct {
+	Service Service
+	Quotes  []QuoteResult
+	Err     error
+}
+
+// QuoteStream asks every service for quotes at once and sends each service's results as they
+// arrive. The channel is closed once every service has answered, or once they've all stopped after
+// ctx is done. Requests still under way are cancelled with ctx, and nothing more is sent.
+func (cb *CryptoBill) QuoteStream(ctx context.Context, info *FiatInfo) <-chan ServiceQuotes {
+	return cb.quoteStream(ctx, info, cb.Services())
+}
+
+func (cb *CryptoBill) quoteStream(ctx context.Context, info *FiatInfo, services []Service) <-chan ServiceQuotes {
+	out := make(chan ServiceQuotes)
+	scoped := cb.withContext(ctx)
+
+	var wg sync.WaitGroup
+	for _, s := range services {
+		wg.Add(1)
+		go func(s Service) {
+			defer wg.Done()
+			if ctx.Err() != nil {
+				return
+			}
+
+			quotes, err := scoped.quote(s, info)
+			if err != nil {
+				cb.log().Info("quote failed", "service", s.ShortName(), "error", err)
+			} else {
+				cb.log().Debug("quoted", "service", s.ShortName(), "quotes", len(quotes))
+			}
+
+			// A select picks at random when both are ready, so check first.
+			if ctx.Err() != nil {
+				return
+			}
+			select {
+			case out <- ServiceQuotes{Service: s, Quotes: quotes, Err: err}:
+			case <-ctx.Done():
+			}
+		}(s)
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+// quote asks one service for quotes, converted to info.Fiat if need be, and records how it went in
+// cb.Metrics.
+func (cb *CryptoBill) quote(s Service, info *FiatInfo) ([]QuoteResult, error) {
+	start := time.Now()
+	quotes, err := cb.quoteIn(s, info)
+	err = classify(s.ShortName(), err)
+	cb.observe(s.ShortName(), "quote", start, err)
+	if err == nil {
+		cb.Metrics.observeQuotes(quotes, cb.now())
+	}
+	return quotes, err
+}
 
 func (cb *CryptoBill) Quote(info *FiatInfo) ([]QuoteResult, error) {
+	services := cb.Services()
+	byService := map[Service]ServiceQuotes{}
+	for sq := range cb.quoteStream(context.Background(), info, services) {
+		byService[sq.Service] = sq
+	}
+
+	// Keep the results in the order the services were registered.
 	var results []QuoteResult
 	var errors error
-	for _, s := range Services {
-		result, err := s.Quote(cb, info)
-		if err != nil {
-			errors = multierror.Append(errors, err)
+	for _, s := range services {
+		sq := byService[s]
+		if sq.Err != nil {
+			errors = multierror.Append(errors, sq.Err)
 			continue
 		}
 
-		results = append(results, result...)
+		results = append(results, sq.Quotes...)
 	}
 	return results, errors
 }
@@ -0,0 +1,60 @@
+package cryptobill
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimiter is an http.RoundTripper which spaces out requests to each host by at least
+// Interval, so long-running commands don't hammer the services.
+type RateLimiter struct {
+	Transport http.RoundTripper
+	Interval  time.Duration
+
+	mu   sync.Mutex
+	next map[string]time.Time
+}
+
+// NewRateLimiter wraps transport, or http.DefaultTransport if it's nil.
+func NewRateLimiter(transport http.RoundTripper, interval time.Duration) *RateLimiter {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	return &RateLimiter{
+		Transport: transport,
+		Interval:  interval,
+		next:      map[string]time.Time{},
+	}
+}
+
+func (rl *RateLimiter) RoundTrip(req *http.Request) (*http.Response, error) {
+	wait := rl.reserve(req.URL.Host)
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+
+	return rl.Transport.RoundTrip(req)
+}
+
+// reserve books the next slot for host and returns how long to wait for it.
+func (rl *RateLimiter) reserve(host string) time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	slot := rl.next[host]
+	if slot.Before(now) {
+		slot = now
+	}
+	rl.next[host] = slot.Add(rl.Interval)
+
+	return slot.Sub(now)
+}
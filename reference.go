@@ -0,0 +1,74 @@
+package cryptobill
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Oracle gives the market price that markups are worked out against.
+type Oracle interface {
+	ReferencePrice(cb *CryptoBill, pair Pair) (Amount, error)
+}
+
+// BitcoinAverage is the default Oracle, using the global ticker from bitcoinaverage.com.
+type BitcoinAverage struct{}
+
+type BitcoinAverageResponse struct {
+	Last float64
+}
+
+func (BitcoinAverage) ReferencePrice(cb *CryptoBill, pair Pair) (Amount, error) {
+	symbol := string(pair.Crypto + pair.Fiat)
+	req, err := http.NewRequest("GET", "https://apiv2.bitcoinaverage.com/indices/global/ticker/"+symbol, nil)
+
+	if err != nil {
+		return 0, errors.Wrap(err, "request builder")
+	}
+
+	resp, err := cb.HttpClient.Do(req)
+	if err != nil {
+		return 0, errors.Wrap(err, "server request")
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, errors.Wrap(err, "reading body")
+	}
+
+	decoded := BitcoinAverageResponse{}
+	err = json.Unmarshal(body, &decoded)
+	if err != nil {
+		return 0, errors.Wrap(err, "decoding body to json: "+string(body))
+	}
+
+	if decoded.Last <= 0 {
+		return 0, errors.Errorf("no price for %v: %v", symbol, string(body))
+	}
+
+	return Amount(decoded.Last), nil
+}
+
+// ReferencePrice returns the market price of one unit of the crypto in the fiat currency, which
+// markups are worked out against.
+func (cb *CryptoBill) ReferencePrice(pair Pair) (Amount, error) {
+	oracle := cb.Oracle
+	if oracle == nil {
+		oracle = BitcoinAverage{}
+	}
+
+	start := time.Now()
+	price, err := oracle.ReferencePrice(cb, pair)
+	if err == nil && price <= 0 {
+		// Markups against it would all be -100%.
+		err = errors.Errorf("reference price for %v%v is %v", pair.Crypto, pair.Fiat, price)
+	}
+	cb.observe("reference", "price", start, err)
+	if err == nil {
+		cb.Metrics.observeReference(pair, price)
+	}
+	return price, err
+}
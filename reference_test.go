@@ -0,0 +1,20 @@
+package cryptobill
+
+import (
+	"testing"
+)
+
+func TestReferencePriceZero(t *testing.T) {
+	// A zero price would make every markup -100%, so it's an error rather than a price.
+	cb, _ := replayCryptoBill(t, "bitcoinaverage_zero")
+	_, err := cb.ReferencePrice(Pair{Fiat: "AUD", Crypto: "BTC"})
+	if err == nil {
+		t.Error("expected an error for a zero price from bitcoinaverage")
+	}
+
+	cb = testCryptoBill(t, WithOracle(&FixedOracle{Fiat: "AUD", Prices: map[Currency]Amount{"BTC": 0}}))
+	_, err = cb.ReferencePrice(Pair{Fiat: "AUD", Crypto: "BTC"})
+	if err == nil {
+		t.Error("expected an error for a zero price from any oracle")
+	}
+}
@@ -0,0 +1,147 @@
+package cryptobill
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+	"sync"
+)
+
+// DefaultServices returns new instances of every real service, in the order they're quoted.
+func DefaultServices() []Service {
+	return []Service{
+		NewLivingRoom(),
+		NewPaidByCoins(),
+		NewBit2Bill(),
+	}
+}
+
+// Other names the default services can be looked up by.
+var defaultAliases = map[string][]string{
+	"LROS": {"livingroom", "livingroomofsatoshi"},
+	"PBC":  {"paidbycoins"},
+	"B2B":  {"bit2bill"},
+}
+
+// registry holds a CryptoBill's services. The zero value has none. It's shared by the copies
+// withContext makes.
+type registry struct {
+	mu       sync.RWMutex
+	services []Service
+
+	// Lower cased alias to short name.
+	aliases map[string]string
+}
+
+// Register adds a service, which can then be looked up by its short name or any of the aliases.
+// A service with the same short name is replaced where it stands, so services can be wrapped
+// without changing the order they're quoted in.
+func (cb *CryptoBill) Register(s Service, aliases ...string) error {
+	r := cb.services
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name := s.ShortName()
+	for _, alias := range aliases {
+		existing, ok := r.aliases[strings.ToLower(alias)]
+		if ok && !strings.EqualFold(existing, name) {
+			return errors.Errorf("alias %v is already used by %v", alias, existing)
+		}
+		if r.index(alias) >= 0 {
+			return errors.Errorf("alias %v is the name of another service", alias)
+		}
+	}
+
+	if i := r.index(name); i >= 0 {
+		r.services[i] = s
+	} else {
+		r.services = append(r.services, s)
+	}
+
+	if r.aliases == nil {
+		r.aliases = map[string]string{}
+	}
+	for _, alias := range aliases {
+		r.aliases[strings.ToLower(alias)] = name
+	}
+	return nil
+}
+
+// Unregister removes a service and its aliases. It reports whether there was one to remove.
+func (cb *CryptoBill) Unregister(name string) bool {
+	r := cb.services
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.lookup(name)
+	if s == nil {
+		return false
+	}
+
+	short := s.ShortName()
+	i := r.index(short)
+	r.services = append(r.services[:i], r.services[i+1:]...)
+	for alias, name := range r.aliases {
+		if name == short {
+			delete(r.aliases, alias)
+		}
+	}
+	return true
+}
+
+// Service looks up a service by short name or alias, ignoring case.
+func (cb *CryptoBill) Service(name string) (Service, error) {
+	r := cb.services
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	s := r.lookup(name)
+	if s == nil {
+		return nil, errors.New("unknown service: " + name)
+	}
+	return s, nil
+}
+
+// Services returns the registered services in the order they're quoted.
+func (cb *CryptoBill) Services() []Service {
+	r := cb.services
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]Service(nil), r.services...)
+}
+
+// setServices replaces every service, keeping the default aliases of any that are registered.
+func (cb *CryptoBill) setServices(services []Service) error {
+	r := cb.services
+	r.mu.Lock()
+	r.services = nil
+	r.aliases = nil
+	r.mu.Unlock()
+
+	for _, s := range services {
+		err := cb.Register(s, defaultAliases[s.ShortName()]...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *registry) index(name string) int {
+	for i, s := range r.services {
+		if strings.EqualFold(s.ShortName(), name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *registry) lookup(name string) Service {
+	if short, ok := r.aliases[strings.ToLower(name)]; ok {
+		name = short
+	}
+	if i := r.index(name); i >= 0 {
+		return r.services[i]
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package cryptobill
+
+import (
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// wrapped stands in for a service wrapped by an embedder, e.g. to log its calls.
+type wrapped struct {
+	Service
+}
+
+func testCryptoBill(t *testing.T, opts ...Option) *CryptoBill {
+	t.Helper()
+
+	cb, err := NewCryptoBill(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cb
+}
+
+func fakeServices(t *testing.T, names ...string) []Service {
+	t.Helper()
+
+	config := &SandboxConfig{
+		Fiat:      "AUD",
+		Reference: map[Currency]Amount{"BTC": 10000},
+		Services:  map[string]FakeServiceConfig{},
+	}
+	for _, name := range names {
+		config.Services[name] = FakeServiceConfig{}
+	}
+
+	services, err := config.NewServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return services
+}
+
+func shortNames(services []Service) []string {
+	var names []string
+	for _, s := range services {
+		names = append(names, s.ShortName())
+	}
+	return names
+}
+
+func TestRegistryDefaults(t *testing.T) {
+	cb := testCryptoBill(t)
+
+	for name, want := range map[string]string{"pbc": "PBC", "PaidByCoins": "PBC", "livingroom": "LROS", "B2B": "B2B"} {
+		s, err := cb.Service(name)
+		if err != nil {
+			t.Errorf("%v: %v", name, err)
+			continue
+		}
+		if s.ShortName() != want {
+			t.Errorf("%v found %v, want %v", name, s.ShortName(), want)
+		}
+	}
+
+	_, err := cb.Service("nope")
+	if err == nil {
+		t.Error("expected an error for an unknown service")
+	}
+
+	// Everything a service describes itself with is safe to ask for generically.
+	for _, s := range cb.Services() {
+		if s.Name() == "" || s.ShortName() == "" {
+			t.Errorf("%v doesn't describe itself", s.ShortName())
+		}
+		s.Website()
+	}
+	for name, want := range map[string]string{"PBC": "https://paidbycoins.com", "B2B": "https://www.bit2bill.com.au"} {
+		if s, _ := cb.Service(name); s.Website() != want {
+			t.Errorf("%v website = %q, want %q", name, s.Website(), want)
+		}
+	}
+}
+
+func TestRegistryRegister(t *testing.T) {
+	fakes := fakeServices(t, "A", "B", "C")
+	cb := testCryptoBill(t, WithServices(fakes...))
+
+	err := cb.Register(wrapped{fakes[1]}, "bee")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := shortNames(cb.Services()); len(got) != 3 || got[1] != "B" {
+		t.Fatalf("services = %v, want B replaced in place", got)
+	}
+	s, err := cb.Service("bee")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.(wrapped); !ok {
+		t.Errorf("bee found %T, want the wrapped service", s)
+	}
+
+	if err := cb.Register(fakes[0], "bee"); err == nil {
+		t.Error("expected an error for an alias used by another service")
+	}
+	if err := cb.Register(fakes[0], "c"); err == nil {
+		t.Error("expected an error for an alias that names another service")
+	}
+
+	if !cb.Unregister("bee") {
+		t.Fatal("expected bee to be unregistered")
+	}
+	if got := shortNames(cb.Services()); len(got) != 2 || got[0] != "A" || got[1] != "C" {
+		t.Errorf("services = %v, want A and C", got)
+	}
+	if _, err := cb.Service("bee"); err == nil {
+		t.Error("expected the alias to go with the service")
+	}
+	if cb.Unregister("bee") {
+		t.Error("expected nothing to unregister")
+	}
+}
+
+func TestRegistryIsolated(t *testing.T) {
+	one := testCryptoBill(t, WithServices(fakeServices(t, "ONE")...))
+	two := testCryptoBill(t, WithServices(fakeServices(t, "TWO", "THREE")...))
+
+	results, err := two.Quote(&FiatInfo{Amount: 100, Fiat: "AUD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(results); got != 2 {
+		t.Errorf("got %v quotes, want one from each of TWO's services", got)
+	}
+
+	if _, err := one.Service("TWO"); err == nil {
+		t.Error("services registered on one CryptoBill leaked into another")
+	}
+	if _, err := testCryptoBill(t).Service("ONE"); err == nil {
+		t.Error("services registered on one CryptoBill leaked into a new one")
+	}
+}
+
+func TestWithClock(t *testing.T) {
+	now := time.Date(2019, 3, 1, 9, 0, 0, 0, time.UTC)
+	cb := testCryptoBill(t, WithClock(func() time.Time { return now }), WithConfigDir(t.TempDir()))
+
+	info := &PayInfoService{Service: "paidbycoins"}
+	payment, err := cb.newPayment("rent", info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !payment.Created.Equal(now) {
+		t.Errorf("created %v, want %v", payment.Created, now)
+	}
+	if payment.Service != "PBC" {
+		t.Errorf("service = %v, want the alias recorded as PBC", payment.Service)
+	}
+}
+
+func TestWithServicesCollision(t *testing.T) {
+	// PBC's alias is the name of the other service.
+	_, err := NewCryptoBill(WithServices(fakeServices(t, "PBC", "PAIDBYCOINS")...))
+	if err == nil {
+		t.Fatal("expected an error for an alias that names another service")
+	}
+}
+
+func TestWithStore(t *testing.T) {
+	store := &MemoryStore{}
+	dir := t.TempDir()
+	cb := testCryptoBill(t, WithStore(store), WithConfigDir(dir))
+
+	err := cb.SaveBills(Bills{"rent": {Name: "rent", BPAY: BPAY{Code: 23796, Account: "1234567897"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cb.savePayment(&Payment{ID: "1", Bill: "rent", Status: PaymentPaid})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("wrote %v files to the config dir, want them all in the store", len(files))
+	}
+
+	// Another CryptoBill sharing the store sees the same records.
+	other := testCryptoBill(t, WithStore(store))
+	bill, err := other.GetBill("rent")
+	if err != nil || bill.BPAY.Code != 23796 {
+		t.Errorf("got bill %+v, %v", bill, err)
+	}
+	payment, err := other.GetPayment("1")
+	if err != nil || payment.Status != PaymentPaid {
+		t.Errorf("got payment %+v, %v", payment, err)
+	}
+
+	// Nothing is kept when fn fails, even if it changed what it was given.
+	err = other.updatePayments(func(payments []*Payment) ([]*Payment, error) {
+		payments[0].Status = PaymentFailed
+		return payments, errors.New("failed")
+	})
+	if err == nil {
+		t.Fatal("expected the error from fn")
+	}
+	if payment, _ := cb.GetPayment("1"); payment.Status != PaymentPaid {
+		t.Errorf("status = %v, want the failed update left out", payment.Status)
+	}
+}
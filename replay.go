@@ -0,0 +1,251 @@
+package cryptobill
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces secrets in fixtures.
+const Redacted = "REDACTED"
+
+// Headers that are always redacted, including API keys set through AdapterConfig.Headers.
+var secretHeader = regexp.MustCompile(`(?i)auth|cookie|key|token|secret|password|passphrase|session`)
+
+// Interaction is a request and the response it got.
+type Interaction struct {
+	Request  FixtureRequest  `json:"request"`
+	Response FixtureResponse `json:"response"`
+}
+
+type FixtureRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+type FixtureResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body"`
+}
+
+// Replayer is an http.RoundTripper that either records each request and response to a fixture
+// file, or replays the responses from one so that adapters can be tested without the network.
+//
+// Requests are matched on method and URL, in the order they were recorded. Secrets are redacted
+// before anything is saved or matched.
+type Replayer struct {
+	Path string
+
+	// Talk to Transport and record, rather than replaying.
+	Record bool
+
+	// Used when recording. Defaults to http.DefaultTransport.
+	Transport http.RoundTripper
+
+	// Replaced with Redacted wherever they appear, e.g. email addresses and passwords.
+	Secrets []string
+
+	mu           sync.Mutex
+	interactions []*Interaction
+	used         []bool
+	requests     []FixtureRequest
+}
+
+// NewReplayer reads the fixture at path, unless recording.
+func NewReplayer(path string, record bool, secrets ...string) (*Replayer, error) {
+	r := &Replayer{Path: path, Record: record, Secrets: secrets}
+	if record {
+		return r, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read fixture")
+	}
+
+	err = json.Unmarshal(data, &r.interactions)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode json from "+path)
+	}
+	r.used = make([]bool, len(r.interactions))
+
+	return r, nil
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "read request body")
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	recorded := FixtureRequest{
+		Method: req.Method,
+		URL:    r.scrub(req.URL.String()),
+		Header: r.scrubHeader(req.Header),
+		Body:   r.scrub(string(body)),
+	}
+
+	r.mu.Lock()
+	r.requests = append(r.requests, recorded)
+	r.mu.Unlock()
+
+	if r.Record {
+		return r.record(req, recorded)
+	}
+	return r.replay(req, recorded)
+}
+
+func (r *Replayer) record(req *http.Request, recorded FixtureRequest) (*http.Response, error) {
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "read response body")
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interactions = append(r.interactions, &Interaction{
+		Request: recorded,
+		Response: FixtureResponse{
+			Status: resp.StatusCode,
+			Header: r.scrubHeader(resp.Header),
+			Body:   r.scrub(string(body)),
+		},
+	})
+	r.used = append(r.used, true)
+
+	return resp, nil
+}
+
+func (r *Replayer) replay(req *http.Request, recorded FixtureRequest) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, in := range r.interactions {
+		if r.used[i] || in.Request.Method != recorded.Method || in.Request.URL != recorded.URL {
+			continue
+		}
+		r.used[i] = true
+
+		header := http.Header{}
+		for k, v := range in.Response.Header {
+			header[k] = v
+		}
+		return &http.Response{
+			Status:        http.StatusText(in.Response.Status),
+			StatusCode:    in.Response.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          ioutil.NopCloser(strings.NewReader(in.Response.Body)),
+			ContentLength: int64(len(in.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+
+	return nil, errors.Errorf("no recorded response for %v %v in %v, re-record the fixture", recorded.Method, recorded.URL, r.Path)
+}
+
+// Requests returns every request made so far, redacted.
+func (r *Replayer) Requests() []FixtureRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]FixtureRequest(nil), r.requests...)
+}
+
+// Unused returns the recorded requests that haven't been replayed.
+func (r *Replayer) Unused() []FixtureRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var unused []FixtureRequest
+	for i, in := range r.interactions {
+		if !r.used[i] {
+			unused = append(unused, in.Request)
+		}
+	}
+	return unused
+}
+
+// Save writes what was recorded to Path. It does nothing when replaying.
+func (r *Replayer) Save() error {
+	if !r.Record {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data, err := json.MarshalIndent(r.interactions, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encode json")
+	}
+
+	return writeFileAtomic(r.Path, append(data, '\n'), 0644)
+}
+
+func (r *Replayer) scrub(s string) string {
+	return redactSecrets(s, r.Secrets)
+}
+
+func (r *Replayer) scrubHeader(h http.Header) http.Header {
+	return redactHeader(h, r.scrub)
+}
+
+func redactSecrets(s string, secrets []string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			s = strings.Replace(s, secret, Redacted, -1)
+			s = strings.Replace(s, url.QueryEscape(secret), Redacted, -1)
+		}
+	}
+	return s
+}
+
+// redactHeader scrubs every value, and hides the values of headers named like secretHeader
+// entirely.
+func redactHeader(h http.Header, scrub func(string) string) http.Header {
+	if len(h) == 0 {
+		return nil
+	}
+
+	scrubbed := http.Header{}
+	for k, values := range h {
+		if secretHeader.MatchString(k) {
+			scrubbed.Set(k, Redacted)
+			continue
+		}
+		for _, v := range values {
+			scrubbed.Add(k, scrub(v))
+		}
+	}
+	return scrubbed
+}
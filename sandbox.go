@@ -0,0 +1,403 @@
+package cryptobill
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// PaymentTracker is implemented by services that can say what has happened to a payment since it
+// was prepared.
+type PaymentTracker interface {
+	PaymentStatus(cb *CryptoBill, p *Payment) (string, error)
+}
+
+// SandboxConfig is read from sandbox.json in the config directory. Anything not given is taken
+// from DefaultSandboxConfig.
+type SandboxConfig struct {
+	// Seeds the failures, so a run can be repeated. Zero picks a new seed each run.
+	Seed int64
+
+	// The fiat that Reference prices are in.
+	Fiat Currency
+
+	// Fixed reference price of each crypto.
+	Reference map[Currency]Amount
+
+	// Fixed exchange rates: how much of each other fiat one unit of Fiat buys.
+	FX map[Currency]Amount
+
+	Services map[string]FakeServiceConfig
+}
+
+type FakeServiceConfig struct {
+	// Percent over the reference price.
+	Markup float64
+
+	// A fixed charge in fiat and a percentage of the amount, added before converting.
+	Fee        Amount
+	FeePercent float64
+
+	// Coins it quotes. Defaults to every coin with a reference price.
+	Cryptos []Currency
+
+	// How long each call takes, e.g. "500ms".
+	Latency string
+
+	// Chance from 0 to 1 of a quote or payment failing.
+	QuoteFailRate float64
+	PayFailRate   float64
+
+	// Statuses a prepared payment moves through.
+	Lifecycle []LifecycleStep
+}
+
+// LifecycleStep is a status a payment reaches some time after it was prepared.
+type LifecycleStep struct {
+	Status string
+	After  string
+}
+
+// DefaultSandboxConfig has fakes named after the real services, so scripts work unchanged.
+func DefaultSandboxConfig() *SandboxConfig {
+	lifecycle := []LifecycleStep{
+		{Status: PaymentReceived, After: "1m"},
+		{Status: PaymentPaid, After: "5m"},
+	}
+
+	return &SandboxConfig{
+		Fiat:      "AUD",
+		Reference: map[Currency]Amount{"BTC": 9000, "ETH": 300, "LTC": 80},
+		FX:        map[Currency]Amount{"USD": 0.65, "EUR": 0.6, "GBP": 0.5, "NZD": 1.1},
+		Services: map[string]FakeServiceConfig{
+			"PBC":  {Markup: 3.5, FeePercent: 0.5, Lifecycle: lifecycle},
+			"LROS": {Markup: 6, Cryptos: []Currency{"BTC", "ETH"}, Lifecycle: lifecycle},
+			"B2B":  {Markup: 4.5, Fee: 1.5, Lifecycle: lifecycle},
+		},
+	}
+}
+
+// LoadSandboxConfig reads sandbox.json from dir, if there is one.
+func LoadSandboxConfig(dir string) (*SandboxConfig, error) {
+	config := DefaultSandboxConfig()
+
+	path := filepath.Join(dir, "sandbox.json")
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read "+path)
+	}
+
+	// Services given in the file replace the defaults rather than adding to them.
+	config.Services = nil
+	err = json.Unmarshal(data, config)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode json from "+path)
+	}
+
+	return config, nil
+}
+
+// EnableSandbox swaps the services for fakes, and the oracle and FX for fixed prices, as set up in
+// sandbox.json. Payments and quote history are kept in the sandbox directory inside the config
+// directory.
+func (cb *CryptoBill) EnableSandbox() error {
+	dir, err := cb.configDir()
+	if err != nil {
+		return err
+	}
+
+	config, err := LoadSandboxConfig(dir)
+	if err != nil {
+		return errors.Wrap(err, "load sandbox")
+	}
+
+	services, err := config.NewServices()
+	if err != nil {
+		return errors.Wrap(err, dir)
+	}
+
+	err = cb.setServices(services)
+	if err != nil {
+		return errors.Wrap(err, "sandbox services")
+	}
+	cb.Oracle = &FixedOracle{Fiat: config.Fiat, Prices: config.Reference}
+	cb.FX = &FixedFX{Base: config.Fiat, Rates: config.FX}
+	cb.Notifier = sandboxNotifier()
+	cb.sandbox = true
+	return nil
+}
+
+// sandboxNotifier prints every event to stderr, marked as from the sandbox, rather than sending it
+// to the sinks in notify.json.
+func sandboxNotifier() *Notifier {
+	return &Notifier{
+		Sinks: map[string]*NotifySink{
+			"sandbox": {
+				Sink:     &CommandSink{Out: os.Stderr},
+				Template: template.Must(template.New("sandbox").Parse("[sandbox] {{.Kind}}: {{.Message}}")),
+			},
+		},
+		Routes:    []NotifyRoute{{Sinks: []string{"sandbox"}}},
+		Templates: map[string]*template.Template{},
+	}
+}
+
+// NewServices makes the fake services, in name order.
+func (config *SandboxConfig) NewServices() ([]Service, error) {
+	var names []string
+	for name := range config.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	// Each fake draws from its own source, so the order they're called in doesn't change what
+	// each of them draws.
+	var services []Service
+	for i, name := range names {
+		random := &lockedRand{rand: rand.New(rand.NewSource(seed + int64(i)))}
+		fake, err := newFakeService(name, config, random)
+		if err != nil {
+			return nil, errors.Wrap(err, "sandbox service "+name)
+		}
+		services = append(services, fake)
+	}
+	return services, nil
+}
+
+// FixedOracle gives the same reference prices every time. Prices in other fiats are converted
+// with cb.FX.
+type FixedOracle struct {
+	Fiat   Currency
+	Prices map[Currency]Amount
+}
+
+func (o *FixedOracle) ReferencePrice(cb *CryptoBill, pair Pair) (Amount, error) {
+	price, ok := o.Prices[pair.Crypto]
+	if !ok {
+		return 0, errors.Errorf("no sandbox price for %v in %v", pair.Crypto, pair.Fiat)
+	}
+	if pair.Fiat == o.Fiat {
+		return price, nil
+	}
+
+	rate, err := cb.FXRate(o.Fiat, pair.Fiat)
+	if err != nil {
+		return 0, errors.Wrapf(err, "no sandbox price for %v in %v", pair.Crypto, pair.Fiat)
+	}
+	return price * rate, nil
+}
+
+// FakeService quotes and pays in-process at a fixed markup over a FixedOracle.
+type FakeService struct {
+	FakeServiceConfig
+
+	name      string
+	oracle    *FixedOracle
+	latency   time.Duration
+	lifecycle []time.Duration
+	random    *lockedRand
+}
+
+func newFakeService(name string, config *SandboxConfig, random *lockedRand) (*FakeService, error) {
+	f := &FakeService{
+		FakeServiceConfig: config.Services[name],
+		name:              name,
+		oracle:            &FixedOracle{Fiat: config.Fiat, Prices: config.Reference},
+		random:            random,
+	}
+
+	if f.Latency != "" {
+		latency, err := time.ParseDuration(f.Latency)
+		if err != nil {
+			return nil, errors.Wrap(err, "latency")
+		}
+		f.latency = latency
+	}
+
+	for _, step := range f.Lifecycle {
+		after, err := time.ParseDuration(step.After)
+		if err != nil {
+			return nil, errors.Wrap(err, "lifecycle "+step.Status)
+		}
+		f.lifecycle = append(f.lifecycle, after)
+	}
+
+	if len(f.Cryptos) == 0 {
+		for crypto := range config.Reference {
+			f.Cryptos = append(f.Cryptos, crypto)
+		}
+		sort.Slice(f.Cryptos, func(i, j int) bool { return f.Cryptos[i] < f.Cryptos[j] })
+	}
+
+	return f, nil
+}
+
+func (f *FakeService) Name() string {
+	return "Sandbox " + f.name
+}
+
+func (f *FakeService) ShortName() string {
+	return f.name
+}
+
+func (f *FakeService) Website() string {
+	return ""
+}
+
+// Fiats is the fiat of the sandbox reference prices.
+func (f *FakeService) Fiats() []Currency {
+	return []Currency{f.oracle.Fiat}
+}
+
+// rate is how much fiat one unit of crypto pays for. The markup makes it less than the reference.
+func (f *FakeService) rate(cb *CryptoBill, pair Pair) (Amount, error) {
+	reference, err := f.oracle.ReferencePrice(cb, pair)
+	if err != nil {
+		return 0, err
+	}
+	return reference / Amount(1+f.Markup/100), nil
+}
+
+func (f *FakeService) cost(amount Amount) Amount {
+	return amount*Amount(1+f.FeePercent/100) + f.Fee
+}
+
+func (f *FakeService) Quote(cb *CryptoBill, info *FiatInfo) ([]QuoteResult, error) {
+	err := f.wait(cb)
+	if err != nil {
+		return nil, err
+	}
+	if f.random.chance(f.QuoteFailRate) {
+		return nil, newProviderError(Unavailable, f.name, "", "sandbox quote failed")
+	}
+	if info.Fiat != f.oracle.Fiat {
+		return nil, newProviderError(Unsupported, f.name, "fiat", "only quotes in %v, not %v", f.oracle.Fiat, info.Fiat)
+	}
+
+	var results []QuoteResult
+	for _, crypto := range f.Cryptos {
+		pair := Pair{info.Fiat, crypto}
+		rate, err := f.rate(cb, pair)
+		if err != nil {
+			return nil, errors.Wrap(err, f.name)
+		}
+
+		results = append(results, QuoteResult{
+			Service:    f,
+			Pair:       pair,
+			Conversion: Conversion{info.Amount, f.cost(info.Amount) / rate},
+		})
+	}
+	return results, nil
+}
+
+func (f *FakeService) PayBPAY(cb *CryptoBill, bpay *PayBPAY) (*PayResult, error) {
+	bpay.Name = fmt.Sprintf("SANDBOX BILLER %v", bpay.Code)
+	return f.pay(cb, &bpay.PayInfoService, newBPAYPreview(f, bpay))
+}
+
+func (f *FakeService) PayEFT(cb *CryptoBill, eft *PayEFT) (*PayResult, error) {
+	eft.BSBName = "SANDBOX BANK " + eft.BSB
+	return f.pay(cb, &eft.PayInfoService, newEFTPreview(f, eft))
+}
+
+func (f *FakeService) pay(cb *CryptoBill, info *PayInfoService, preview *PayPreview) (*PayResult, error) {
+	err := f.wait(cb)
+	if err != nil {
+		return nil, err
+	}
+
+	rate, err := f.rate(cb, Pair{info.Fiat, info.Crypto})
+	if err != nil {
+		return nil, &ProviderError{Kind: Unsupported, Service: f.name, Field: "crypto", Err: err}
+	}
+
+	preview.Rate = rate
+	preview.CryptoAmount = f.cost(info.Amount) / rate
+	preview.Fee = f.Fee
+	preview.FeePercent = f.FeePercent
+	err = cb.confirm(preview)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.random.chance(f.PayFailRate) {
+		return nil, newProviderError(Unavailable, f.name, "", "sandbox payment failed")
+	}
+
+	return &PayResult{
+		Address: fmt.Sprintf("sandbox-%v-%v-%08x", f.name, info.Crypto, f.random.uint32()),
+		Amount:  preview.CryptoAmount,
+	}, nil
+}
+
+// wait takes as long as a real service might, unless the request is cancelled.
+func (f *FakeService) wait(cb *CryptoBill) error {
+	if f.latency <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(f.latency)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-cb.context().Done():
+		return cb.context().Err()
+	}
+}
+
+// PaymentStatus moves the payment through the lifecycle by how long ago it was prepared.
+func (f *FakeService) PaymentStatus(cb *CryptoBill, p *Payment) (string, error) {
+	err := f.wait(cb)
+	if err != nil {
+		return "", err
+	}
+
+	status := PaymentPrepared
+	age := cb.now().Sub(p.Created)
+	for i, after := range f.lifecycle {
+		if age >= after {
+			status = f.Lifecycle[i].Status
+		}
+	}
+	return status, nil
+}
+
+// lockedRand is a fake's source of failures, which may be drawn from several goroutines.
+type lockedRand struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func (r *lockedRand) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rand.Float64() < p
+}
+
+func (r *lockedRand) uint32() uint32 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rand.Uint32()
+}
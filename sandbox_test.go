@@ -0,0 +1,191 @@
+package cryptobill
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSandboxQuoteMarkup(t *testing.T) {
+	config := &SandboxConfig{
+		Fiat:      "AUD",
+		Reference: map[Currency]Amount{"BTC": 10000},
+		Services:  map[string]FakeServiceConfig{"FAKE": {Markup: 4, Fee: 2}},
+	}
+	services, err := config.NewServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cb := testCryptoBill(t)
+	cb.Oracle = &FixedOracle{Fiat: config.Fiat, Prices: config.Reference}
+
+	results, err := services[0].Quote(cb, &FiatInfo{Amount: 98, Fiat: "AUD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %v quotes, want 1", len(results))
+	}
+
+	// 98 AUD plus the 2 AUD fee, at 4% over 10000 AUD per BTC.
+	assertAmount(t, "crypto", results[0].Conversion.Crypto, 0.0104)
+
+	reference, err := cb.ReferencePrice(results[0].Pair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertAmount(t, "markup", Amount(NewMarkup(results[0], reference).Percent), 100*(1.04*100/98-1))
+
+	_, err = services[0].Quote(cb, &FiatInfo{Amount: 98, Fiat: "USD"})
+	assertKind(t, err, Unsupported, "fiat")
+}
+
+func TestSandboxFailures(t *testing.T) {
+	config := &SandboxConfig{
+		Seed:      1,
+		Fiat:      "AUD",
+		Reference: map[Currency]Amount{"BTC": 10000},
+		Services:  map[string]FakeServiceConfig{"FAKE": {QuoteFailRate: 1}},
+	}
+	services, err := config.NewServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = services[0].Quote(testCryptoBill(t), &FiatInfo{Amount: 100, Fiat: "AUD"})
+	if err == nil {
+		t.Error("expected the quote to fail")
+	}
+}
+
+func TestSandboxLifecycle(t *testing.T) {
+	config := &SandboxConfig{
+		Fiat:      "AUD",
+		Reference: map[Currency]Amount{"BTC": 10000},
+		Services: map[string]FakeServiceConfig{"FAKE": {Lifecycle: []LifecycleStep{
+			{Status: PaymentReceived, After: "1m"},
+			{Status: PaymentPaid, After: "5m"},
+		}}},
+	}
+	services, err := config.NewServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := services[0].(PaymentTracker)
+
+	for _, tc := range []struct {
+		age  time.Duration
+		want string
+	}{
+		{0, PaymentPrepared},
+		{2 * time.Minute, PaymentReceived},
+		{time.Hour, PaymentPaid},
+	} {
+		status, err := tracker.PaymentStatus(testCryptoBill(t), &Payment{Created: time.Now().Add(-tc.age)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != tc.want {
+			t.Errorf("after %v status = %v, want %v", tc.age, status, tc.want)
+		}
+	}
+}
+
+func TestSandboxSeed(t *testing.T) {
+	config := &SandboxConfig{
+		Seed:      7,
+		Fiat:      "AUD",
+		Reference: map[Currency]Amount{"BTC": 10000},
+		Services:  map[string]FakeServiceConfig{"A": {QuoteFailRate: 0.5}, "B": {QuoteFailRate: 0.5}},
+	}
+	cb := testCryptoBill(t)
+	cb.Oracle = &FixedOracle{Fiat: config.Fiat, Prices: config.Reference}
+
+	// Which quotes fail is the same whatever order the services are asked in.
+	failures := func(order ...int) map[string]string {
+		services, err := config.NewServices()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := map[string]string{}
+		for i := 0; i < 20; i++ {
+			for _, n := range order {
+				s := services[n]
+				_, err := s.Quote(cb, &FiatInfo{Amount: 100, Fiat: "AUD"})
+				if err != nil {
+					got[s.ShortName()] += "x"
+				} else {
+					got[s.ShortName()] += "."
+				}
+			}
+		}
+		return got
+	}
+
+	first, second := failures(0, 1), failures(1, 0)
+	if first["A"] != second["A"] || first["B"] != second["B"] {
+		t.Errorf("got %v then %v", first, second)
+	}
+	if !strings.Contains(first["A"], "x") || !strings.Contains(first["A"], ".") {
+		t.Errorf("A = %v, want some failures", first["A"])
+	}
+}
+
+func TestEnableSandbox(t *testing.T) {
+	hooked := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hooked++
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"notify.json":  `{"Sinks": {"hook": {"Type": "webhook", "URL": "` + server.URL + `"}}, "Routes": [{"Sinks": ["hook"]}]}`,
+		"sandbox.json": `{"Services": {"FAKE": {}}}`,
+	} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cb := testCryptoBill(t, WithConfigDir(dir))
+	err := cb.EnableSandbox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	cb.Notifier.Sinks["sandbox"].Sink.(*CommandSink).Out = &out
+
+	err = cb.AddBill(&Bill{Name: "power", BPAY: BPAY{Code: 23796, Account: "79927398713"}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing in the vault, and none needed.
+	payment, err := cb.Pay("power", &PayInfoService{
+		PayInfo: PayInfo{FiatInfo: FiatInfo{Amount: 100, Fiat: "AUD"}, Crypto: "BTC"},
+		Service: "FAKE",
+	})
+	if err != nil || payment.Status != PaymentPrepared {
+		t.Fatalf("got %+v, %v", payment, err)
+	}
+
+	err = cb.Notify(NewPaymentEvent(payment))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hooked != 0 {
+		t.Error("sandbox event was sent to the webhook")
+	}
+	if !strings.Contains(out.String(), "[sandbox] payment: Send ") {
+		t.Errorf("got %q, want the event marked as from the sandbox", out.String())
+	}
+}
@@ -0,0 +1,325 @@
+package cryptobill
+
+import (
+	"github.com/pkg/errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DateLayout is how dates are written in bills and on the command line.
+const DateLayout = "2006-01-02"
+
+// Schedule is when and how much a bill needs paying.
+type Schedule struct {
+	// The first due date. Later ones are worked out from Every.
+	Due string
+
+	// How often the bill repeats, e.g. "monthly", "quarterly" or "2w". Empty for a one-off bill.
+	Every string `json:",omitempty"`
+
+	Amount Amount
+	Fiat   Currency
+
+	// The amount changes from bill to bill, so Amount is only an estimate used for quotes.
+	Variable bool `json:",omitempty"`
+
+	// Only quote with these when preparing payments. Empty means any.
+	Crypto  Currency `json:",omitempty"`
+	Service string   `json:",omitempty"`
+
+	// How many days before the pay-by time to start preparing quotes.
+	LeadDays int
+
+	// The due date of the last occurrence that was paid. Earlier occurrences aren't shown as due.
+	Paid string `json:",omitempty"`
+}
+
+var everyAliases = map[string]string{
+	"weekly":      "1w",
+	"fortnightly": "2w",
+	"monthly":     "1m",
+	"quarterly":   "3m",
+	"yearly":      "1y",
+	"annually":    "1y",
+}
+
+var everyRE = regexp.MustCompile(`^([0-9]+)([dwmy])$`)
+
+// interval parses Every into the years, months and days between occurrences.
+func (s *Schedule) interval() (years, months, days int, err error) {
+	every := strings.ToLower(strings.TrimSpace(s.Every))
+	if alias, ok := everyAliases[every]; ok {
+		every = alias
+	}
+
+	match := everyRE.FindStringSubmatch(every)
+	if match == nil {
+		return 0, 0, 0, errors.Errorf("can't understand %q, use e.g. monthly, quarterly, 2w, 3m or 1y", s.Every)
+	}
+
+	n, _ := strconv.Atoi(match[1])
+	if n == 0 {
+		return 0, 0, 0, errors.New("repeat interval can't be zero")
+	}
+
+	switch match[2] {
+	case "d":
+		return 0, 0, n, nil
+	case "w":
+		return 0, 0, n * 7, nil
+	case "m":
+		return 0, n, 0, nil
+	default:
+		return n, 0, 0, nil
+	}
+}
+
+// Validate checks the dates and recurrence rule can be understood.
+func (s *Schedule) Validate() error {
+	_, err := time.Parse(DateLayout, s.Due)
+	if err != nil {
+		return errors.Errorf("due date %q should look like %v", s.Due, DateLayout)
+	}
+
+	if s.Paid != "" {
+		_, err = time.Parse(DateLayout, s.Paid)
+		if err != nil {
+			return errors.Errorf("paid date %q should look like %v", s.Paid, DateLayout)
+		}
+	}
+
+	if s.Every != "" {
+		_, _, _, err = s.interval()
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.Amount < 0 {
+		return errors.New("amount can't be negative")
+	}
+
+	if s.LeadDays < 0 {
+		return errors.New("lead days can't be negative")
+	}
+
+	return nil
+}
+
+// Occurrences returns the unpaid due dates up to and including until, at midnight in loc.
+func (s *Schedule) Occurrences(until time.Time, loc *time.Location) ([]time.Time, error) {
+	err := s.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	first, _ := time.ParseInLocation(DateLayout, s.Due, loc)
+	var paid time.Time
+	if s.Paid != "" {
+		paid, _ = time.ParseInLocation(DateLayout, s.Paid, loc)
+	}
+
+	if s.Every == "" {
+		if first.After(until) || !first.After(paid) {
+			return nil, nil
+		}
+		return []time.Time{first}, nil
+	}
+
+	years, months, days, _ := s.interval()
+
+	var dates []time.Time
+	for n := 0; ; n++ {
+		due := addClamped(first, n*years, n*months, n*days)
+		if due.After(until) {
+			break
+		}
+		if due.After(paid) {
+			dates = append(dates, due)
+		}
+	}
+
+	return dates, nil
+}
+
+// addClamped is like AddDate, except that adding months to the 31st lands on the last day of a
+// shorter month rather than spilling into the next one.
+func addClamped(t time.Time, years, months, days int) time.Time {
+	if days != 0 {
+		return t.AddDate(years, months, days)
+	}
+
+	target := time.Date(t.Year()+years, t.Month()+time.Month(months), 1, 0, 0, 0, 0, t.Location())
+	last := target.AddDate(0, 1, -1).Day()
+	day := t.Day()
+	if day > last {
+		day = last
+	}
+
+	return time.Date(target.Year(), target.Month(), day, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+}
+
+// DueBill is one occurrence of a scheduled bill.
+type DueBill struct {
+	Bill  *Bill
+	Due   time.Time
+	PayBy time.Time
+
+	// When to start preparing quotes, LeadDays before PayBy.
+	PrepareFrom time.Time
+}
+
+// Upcoming returns the unpaid occurrences of scheduled bills which are due before now plus
+// window, including overdue ones, ordered by pay-by time.
+func (cb *CryptoBill) Upcoming(now time.Time, window time.Duration) ([]DueBill, error) {
+	bills, err := cb.LoadBills()
+	if err != nil {
+		return nil, errors.Wrap(err, "load bills")
+	}
+
+	cal, err := cb.calendar()
+	if err != nil {
+		return nil, err
+	}
+
+	var due []DueBill
+	for _, name := range bills.Names() {
+		bill := bills[name]
+		if bill == nil || bill.Schedule == nil {
+			continue
+		}
+
+		dates, err := bill.Schedule.Occurrences(now.Add(window), cal.Location)
+		if err != nil {
+			return nil, errors.Wrap(err, name)
+		}
+
+		for _, date := range dates {
+			payBy := cal.PayBy(date)
+			due = append(due, DueBill{
+				Bill:        bill,
+				Due:         date,
+				PayBy:       payBy,
+				PrepareFrom: payBy.AddDate(0, 0, -bill.Schedule.LeadDays),
+			})
+		}
+	}
+
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].PayBy.Before(due[j].PayBy)
+	})
+
+	return due, nil
+}
+
+// SetSchedule replaces the bill's schedule, or removes it if schedule is nil.
+func (cb *CryptoBill) SetSchedule(name string, schedule *Schedule) error {
+	if schedule != nil {
+		err := schedule.Validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	return cb.EditBill(name, func(bill *Bill) error {
+		bill.Schedule = schedule
+		return nil
+	})
+}
+
+// MarkPaid records that the occurrence due on the given date has been paid, so it and earlier
+// ones are no longer shown as due.
+func (cb *CryptoBill) MarkPaid(name string, due time.Time) error {
+	return cb.EditBill(name, func(bill *Bill) error {
+		if bill.Schedule == nil {
+			return errors.New(name + " has no schedule")
+		}
+
+		bill.Schedule.Paid = due.Format(DateLayout)
+		return nil
+	})
+}
+
+// PreparedQuote is a quote fetched ahead of a bill's pay-by time.
+type PreparedQuote struct {
+	DueBill
+	Quotes []QuoteResult
+
+	// Set if no service could quote.
+	Err error
+}
+
+// How far ahead PrepareQuotes looks. No bill needs preparing more than a year ahead.
+var prepareHorizon = 366 * 24 * time.Hour
+
+// PrepareQuotes quotes the earliest unpaid occurrence of each bill once its PrepareFrom time has
+// passed, using the schedule's amount and preferred service and coin. Overdue bills are included.
+// Bills with the same amount share one round of quotes.
+func (cb *CryptoBill) PrepareQuotes(now time.Time) ([]PreparedQuote, error) {
+	upcoming, err := cb.Upcoming(now, prepareHorizon)
+	if err != nil {
+		return nil, err
+	}
+
+	type round struct {
+		quotes []QuoteResult
+		err    error
+	}
+	rounds := map[FiatInfo]round{}
+	seen := map[string]bool{}
+
+	var prepared []PreparedQuote
+	for _, due := range upcoming {
+		// Upcoming is in pay-by order, so the first is the earliest.
+		if seen[due.Bill.Name] || now.Before(due.PrepareFrom) {
+			continue
+		}
+		seen[due.Bill.Name] = true
+
+		schedule := due.Bill.Schedule
+		if schedule.Amount == 0 {
+			// Nothing to quote until there is at least an estimate.
+			prepared = append(prepared, PreparedQuote{DueBill: due})
+			continue
+		}
+
+		info := FiatInfo{Amount: schedule.Amount, Fiat: schedule.Fiat}
+		r, ok := rounds[info]
+		if !ok {
+			r.quotes, r.err = cb.Quote(&info)
+			rounds[info] = r
+		}
+
+		// Some services failing is fine as long as others answered.
+		quotes, err := r.quotes, r.err
+		if len(quotes) == 0 && err != nil {
+			prepared = append(prepared, PreparedQuote{DueBill: due, Err: err})
+			continue
+		}
+
+		var matching []QuoteResult
+		for _, q := range quotes {
+			if schedule.Crypto != "" && q.Pair.Crypto != schedule.Crypto {
+				continue
+			}
+			if schedule.Service != "" && !strings.EqualFold(q.Service.ShortName(), schedule.Service) {
+				continue
+			}
+			matching = append(matching, q)
+		}
+
+		sort.Slice(matching, func(i, j int) bool {
+			if matching[i].Pair.Crypto == matching[j].Pair.Crypto {
+				return matching[i].Conversion.Crypto < matching[j].Conversion.Crypto
+			}
+			return matching[i].Pair.Crypto < matching[j].Pair.Crypto
+		})
+
+		prepared = append(prepared, PreparedQuote{DueBill: due, Quotes: matching})
+	}
+
+	return prepared, nil
+}
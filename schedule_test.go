@@ -0,0 +1,52 @@
+package cryptobill
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingService struct {
+	Service
+	quotes int32
+}
+
+func (s *countingService) Quote(cb *CryptoBill, info *FiatInfo) ([]QuoteResult, error) {
+	atomic.AddInt32(&s.quotes, 1)
+	return s.Service.Quote(cb, info)
+}
+
+func TestPrepareQuotesOncePerBill(t *testing.T) {
+	counter := &countingService{Service: fakeServices(t, "A")[0]}
+	cb := testCryptoBill(t, WithServices(counter), WithConfigDir(t.TempDir()))
+
+	// Weeks overdue, and two bills for the same amount.
+	for _, name := range []string{"gym", "pool"} {
+		err := cb.AddBill(&Bill{Name: name, BPAY: BPAY{Code: 23796, Account: "79927398713"}}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cb.SetSchedule(name, &Schedule{Due: "2018-01-01", Every: "weekly", Amount: 20, Fiat: "AUD"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	prepared, err := cb.PrepareQuotes(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(prepared) != 2 {
+		t.Fatalf("got %v prepared quotes, want one per bill", len(prepared))
+	}
+	for _, pq := range prepared {
+		if pq.Due.Format(DateLayout) != "2018-01-01" || len(pq.Quotes) != 1 {
+			t.Errorf("%v: due %v with %v quotes", pq.Bill.Name, pq.Due.Format(DateLayout), len(pq.Quotes))
+		}
+	}
+	if counter.quotes != 1 {
+		t.Errorf("quoted %v times, want once", counter.quotes)
+	}
+}
@@ -0,0 +1,163 @@
+package cryptobill
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// WebhookSink POSTs the event as JSON.
+type WebhookSink struct {
+	URL     string
+	Headers map[string]string
+}
+
+type webhookPayload struct {
+	Kind    string                 `json:"kind"`
+	Title   string                 `json:"title"`
+	Message string                 `json:"message"`
+	Text    string                 `json:"text"`
+	Time    time.Time              `json:"time"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+}
+
+func (s *WebhookSink) Send(cb *CryptoBill, msg *Message) error {
+	payload, err := json.Marshal(&webhookPayload{
+		Kind:    msg.Event.Kind,
+		Title:   msg.Subject,
+		Message: msg.Event.Message,
+		Text:    msg.Body,
+		Time:    msg.Event.Time,
+		Data:    msg.Event.Data,
+	})
+	if err != nil {
+		return errors.Wrap(err, "encode json")
+	}
+
+	req, err := http.NewRequest("POST", s.URL, bytes.NewReader(payload))
+	if err != nil {
+		return errors.Wrap(err, "request builder")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := cb.HttpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "server request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errors.Errorf("webhook returned %v: %s", resp.Status, bytes.TrimSpace(body))
+	}
+
+	return nil
+}
+
+// SMTPSink emails the message.
+type SMTPSink struct {
+	// host:port
+	Addr string
+
+	From string
+	To   []string
+
+	// Leave empty for servers that don't need a login.
+	Username string
+	Password string
+}
+
+func (s *SMTPSink) Send(cb *CryptoBill, msg *Message) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host := strings.Split(s.Addr, ":")[0]
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	body := &bytes.Buffer{}
+	fmt.Fprintf(body, "From: %v\r\n", s.From)
+	fmt.Fprintf(body, "To: %v\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(body, "Subject: %v\r\n", headerValue(msg.Subject))
+	fmt.Fprintf(body, "Date: %v\r\n", msg.Event.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(body, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(body, "\r\n%v\r\n", strings.Replace(msg.Body, "\n", "\r\n", -1))
+
+	err := smtp.SendMail(s.Addr, auth, s.From, s.To, body.Bytes())
+	if err != nil {
+		return errors.Wrap(err, "send mail")
+	}
+
+	return nil
+}
+
+// headerValue keeps a templated value on one line, so it can't add headers of its own.
+func headerValue(s string) string {
+	return strings.Join(strings.Fields(strings.NewReplacer("\r", " ", "\n", " ").Replace(s)), " ")
+}
+
+// DesktopSink pops up a notification with notify-send, or osascript on macOS.
+type DesktopSink struct{}
+
+func (s *DesktopSink) Send(cb *CryptoBill, msg *Message) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %q with title %q", msg.Body, msg.Subject)
+		cmd = exec.Command("osascript", "-e", script)
+	default:
+		cmd = exec.Command("notify-send", "--app-name=cryptobill", msg.Subject, msg.Body)
+	}
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "%v: %s", cmd.Args[0], bytes.TrimSpace(out))
+	}
+
+	return nil
+}
+
+// CommandSink runs a command with the message body on its stdin, and the event kind and subject
+// in $CRYPTOBILL_EVENT and $CRYPTOBILL_SUBJECT. Without a command, the message is printed to Out,
+// or stdout.
+type CommandSink struct {
+	Command []string
+	Out     io.Writer
+}
+
+func (s *CommandSink) Send(cb *CryptoBill, msg *Message) error {
+	if len(s.Command) == 0 {
+		out := s.Out
+		if out == nil {
+			out = os.Stdout
+		}
+		_, err := fmt.Fprintf(out, "%v %v\n", msg.Event.Time.Format(time.RFC3339), msg.Body)
+		return err
+	}
+
+	cmd := exec.Command(s.Command[0], s.Command[1:]...)
+	cmd.Stdin = strings.NewReader(msg.Body + "\n")
+	cmd.Env = append(os.Environ(),
+		"CRYPTOBILL_EVENT="+msg.Event.Kind,
+		"CRYPTOBILL_SUBJECT="+msg.Subject,
+	)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "%v: %s", s.Command[0], bytes.TrimSpace(out))
+	}
+
+	return nil
+}
@@ -0,0 +1,268 @@
+package cryptobill
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store keeps bills and payments somewhere other than files in the config directory, e.g. a
+// database, or memory in tests. Each method passes the stored records to fn and, if save is set,
+// stores the ones fn returns, without another call changing them in between. Nothing is stored if
+// fn returns an error.
+//
+// EnableSandbox doesn't change the store, so give a sandboxed CryptoBill a store of its own.
+type Store interface {
+	WithBills(save bool, fn func(Bills) (Bills, error)) error
+	WithPayments(save bool, fn func([]*Payment) ([]*Payment, error)) error
+}
+
+// MemoryStore is a Store that keeps everything in memory. The zero value is empty and ready to use.
+type MemoryStore struct {
+	mu sync.Mutex
+
+	// Kept encoded, so that fn can't change the stored records unless they're saved.
+	bills    []byte
+	payments []byte
+}
+
+func (s *MemoryStore) WithBills(save bool, fn func(Bills) (Bills, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := Bills{}
+	err := decodeStored(s.bills, &entries)
+	if err != nil {
+		return err
+	}
+
+	entries, err = fn(entries)
+	if err != nil || !save {
+		return err
+	}
+
+	s.bills, err = json.Marshal(entries)
+	return errors.Wrap(err, "encode bills")
+}
+
+func (s *MemoryStore) WithPayments(save bool, fn func([]*Payment) ([]*Payment, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var payments []*Payment
+	err := decodeStored(s.payments, &payments)
+	if err != nil {
+		return err
+	}
+
+	payments, err = fn(payments)
+	if err != nil || !save {
+		return err
+	}
+
+	s.payments, err = json.Marshal(payments)
+	return errors.Wrap(err, "encode payments")
+}
+
+func decodeStored(data []byte, v interface{}) error {
+	if data == nil {
+		return nil
+	}
+	return errors.Wrap(json.Unmarshal(data, v), "decode stored json")
+}
+
+// How many backups of a file are kept in its backups directory.
+var backupsToKeep = 20
+
+// How long to wait for another cryptobill process to release a lock.
+var lockTimeout = 10 * time.Second
+
+// writeFileAtomic writes data to a temporary file next to path, fsyncs it and renames it over path,
+// so a crash leaves either the old or the new contents, never a truncated file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return errors.Wrap(err, "mkdir "+dir)
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "create temp file")
+	}
+	// Only has an effect if something below failed before the rename.
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "write temp file")
+	}
+
+	err = tmp.Sync()
+	if err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "fsync temp file")
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return errors.Wrap(err, "close temp file")
+	}
+
+	err = os.Chmod(tmp.Name(), perm)
+	if err != nil {
+		return errors.Wrap(err, "chmod temp file")
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return errors.Wrap(err, "rename temp file")
+	}
+
+	syncDir(dir)
+
+	return nil
+}
+
+// syncDir makes a rename durable. Not every platform can fsync a directory, so errors are ignored.
+func syncDir(dir string) {
+	fp, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	fp.Sync()
+	fp.Close()
+}
+
+// backupFile copies path into a "backups" directory next to it, stamped with the current time,
+// and prunes the oldest backups of the same file beyond backupsToKeep. Nothing happens if path
+// doesn't exist yet.
+func backupFile(path string) error {
+	src, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "open "+path)
+	}
+	defer src.Close()
+
+	dir := filepath.Join(filepath.Dir(path), "backups")
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return errors.Wrap(err, "mkdir "+dir)
+	}
+
+	name := backupPrefix(path) + time.Now().UTC().Format("20060102T150405.000000000") + filepath.Ext(path)
+	dst, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return errors.Wrap(err, "create backup")
+	}
+
+	_, err = io.Copy(dst, src)
+	if err != nil {
+		dst.Close()
+		return errors.Wrap(err, "copy backup")
+	}
+
+	err = dst.Close()
+	if err != nil {
+		return errors.Wrap(err, "close backup")
+	}
+
+	backups, err := listBackups(path)
+	if err != nil {
+		return err
+	}
+
+	for len(backups) > backupsToKeep {
+		err = os.Remove(backups[0])
+		if err != nil {
+			return errors.Wrap(err, "remove old backup")
+		}
+		backups = backups[1:]
+	}
+
+	return nil
+}
+
+func backupPrefix(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + "-"
+}
+
+// listBackups returns the paths of the backups of path, oldest first.
+func listBackups(path string) ([]string, error) {
+	dir := filepath.Join(filepath.Dir(path), "backups")
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read "+dir)
+	}
+
+	prefix := backupPrefix(path)
+	ext := filepath.Ext(path)
+
+	var backups []string
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), prefix) && strings.HasSuffix(f.Name(), ext) {
+			backups = append(backups, filepath.Join(dir, f.Name()))
+		}
+	}
+
+	// The timestamps sort lexically.
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// removeBackups deletes every copy of path that backupFile has made.
+func removeBackups(path string) error {
+	backups, err := listBackups(path)
+	if err != nil {
+		return err
+	}
+
+	for _, backup := range backups {
+		err = os.Remove(backup)
+		if err != nil {
+			return errors.Wrap(err, "remove backup")
+		}
+	}
+
+	return nil
+}
+
+// lockPath takes an advisory lock on path+".lock", waiting up to lockTimeout for another process
+// to let go of it. The returned function releases the lock.
+func lockPath(path string) (func(), error) {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, errors.Wrap(err, "mkdir")
+	}
+
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		unlock, err := tryLock(path + ".lock")
+		if err == nil {
+			return unlock, nil
+		}
+		if err != errLocked {
+			return nil, errors.Wrap(err, "lock "+path)
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New(path + " is locked by another cryptobill process")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+var errLocked = errors.New("locked")
@@ -0,0 +1,182 @@
+package cryptobill
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func fileCryptoBill(t *testing.T) (*CryptoBill, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Setenv(BillsPathEnv, "")
+	return testCryptoBill(t, WithConfigDir(dir)), dir
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "file.json")
+
+	for _, data := range []string{"first", "second"} {
+		err := writeFileAtomic(path, []byte(data), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != data {
+			t.Errorf("read %q, want %q", got, data)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	// The temporary file is renamed away.
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("got %v files, want only the one written", len(files))
+	}
+}
+
+func TestConcurrentBillUpdates(t *testing.T) {
+	cb, _ := fileCryptoBill(t)
+	err := cb.AddBill(&Bill{Name: "rent", BPAY: BPAY{Code: 1, Account: "0"}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each update reads and writes the file under the lock, so none of them is lost.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			err := cb.AddBill(&Bill{Name: fmt.Sprint("bill", i), BPAY: BPAY{Code: i + 100, Account: "1"}}, false)
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			err := cb.EditBill("rent", func(b *Bill) error {
+				b.BPAY.Code++
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	bills, err := cb.LoadBills()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bills) != 11 {
+		t.Errorf("got %v bills, want 11", len(bills))
+	}
+	if code := bills["rent"].BPAY.Code; code != 11 {
+		t.Errorf("rent's code = %v, want it edited 10 times", code)
+	}
+}
+
+func TestBillBackups(t *testing.T) {
+	defer func(keep int) { backupsToKeep = keep }(backupsToKeep)
+	backupsToKeep = 3
+
+	cb, dir := fileCryptoBill(t)
+	path := filepath.Join(dir, "bills.json")
+
+	// The first save has nothing to back up.
+	for i := 1; i <= 5; i++ {
+		err := cb.AddBill(&Bill{Name: "rent", BPAY: BPAY{Code: i, Account: "0"}}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		backups, err := listBackups(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := i - 1
+		if want > backupsToKeep {
+			want = backupsToKeep
+		}
+		if len(backups) != want {
+			t.Fatalf("after %v saves got %v backups, want %v", i, len(backups), want)
+		}
+	}
+
+	// The oldest are pruned, so the newest backup is the save before last.
+	backups, _ := listBackups(path)
+	bills, _, err := loadBills(backups[len(backups)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := bills["rent"].BPAY.Code; code != 4 {
+		t.Errorf("newest backup has code %v, want 4", code)
+	}
+	bills, _, err = loadBills(backups[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := bills["rent"].BPAY.Code; code != 2 {
+		t.Errorf("oldest backup has code %v, want 2", code)
+	}
+}
+
+func TestConfigPaths(t *testing.T) {
+	xdg := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", xdg)
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(ConfigDirEnv, "")
+	t.Setenv(BillsPathEnv, "")
+
+	cb := testCryptoBill(t)
+	assertPath := func(what string, want string) {
+		t.Helper()
+
+		got, err := cb.billsPath()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%v: bills path = %v, want %v", what, got, want)
+		}
+	}
+
+	if runtime.GOOS == "linux" {
+		assertPath("XDG", filepath.Join(xdg, "cryptobill", "bills.json"))
+	}
+
+	env := t.TempDir()
+	t.Setenv(ConfigDirEnv, env)
+	assertPath("env", filepath.Join(env, "bills.json"))
+
+	flag := t.TempDir()
+	cb.ConfigDir = flag
+	assertPath("flag", filepath.Join(flag, "bills.json"))
+
+	t.Setenv(BillsPathEnv, "/tmp/env-bills.json")
+	assertPath("bills env", "/tmp/env-bills.json")
+
+	cb.BillsPath = "/tmp/flag-bills.json"
+	assertPath("bills flag", "/tmp/flag-bills.json")
+}
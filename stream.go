@@ -0,0 +1,176 @@
+package cryptobill
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// How often the stream re-quotes unless the client asks otherwise, and how often it may ask for.
+var (
+	defaultStreamInterval = 30 * time.Second
+	minStreamInterval     = 5 * time.Second
+)
+
+// Markups changing by less than this, in percent, aren't sent as "markup" events.
+const markupResolution = 0.001
+
+// StreamQuote is a quote in the stream, with its markup if the reference price could be found.
+type StreamQuote struct {
+	QuoteRecord
+	Markup *float64 `json:"markup,omitempty"`
+}
+
+// StreamServiceQuotes is sent as a "quotes" event as each service answers.
+type StreamServiceQuotes struct {
+	Service string        `json:"service"`
+	Quotes  []StreamQuote `json:"quotes"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// StreamMarkup is sent as a "markup" event when a route's markup changes between rounds.
+type StreamMarkup struct {
+	Service  string   `json:"service"`
+	Crypto   Currency `json:"crypto"`
+	Markup   float64  `json:"markup"`
+	Previous *float64 `json:"previous,omitempty"`
+}
+
+// StreamTable is sent as a "table" event once every service has answered, cheapest first.
+type StreamTable struct {
+	Time   time.Time     `json:"time"`
+	Quotes []StreamQuote `json:"quotes"`
+	Errors []string      `json:"errors,omitempty"`
+}
+
+// quoteStream serves Server-Sent Events, re-quoting every interval until the client goes away.
+func (s *APIServer) quoteStream(w http.ResponseWriter, r *http.Request) error {
+	info, err := fiatInfoFromQuery(r)
+	if err != nil {
+		return badRequest(err)
+	}
+
+	interval := defaultStreamInterval
+	if v := r.URL.Query().Get("interval"); v != "" {
+		interval, err = time.ParseDuration(v)
+		if err != nil || interval < minStreamInterval {
+			return badRequest(errors.Errorf("interval should be a duration of at least %v", minStreamInterval))
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	send := func(event string, v interface{}) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return errors.Wrap(err, "encode json")
+		}
+
+		_, err = fmt.Fprintf(w, "event: %v\ndata: %s\n\n", event, data)
+		if err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	ctx := r.Context()
+	markups := map[string]float64{}
+	for {
+		err = s.streamRound(r, info, markups, send)
+		if err != nil {
+			// The client has gone, or can't be written to. Either way there's no one to tell.
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// streamRound quotes once, sending each service's quotes as they arrive followed by its markups
+// that changed since last round, then the full table.
+func (s *APIServer) streamRound(r *http.Request, info *FiatInfo, markups map[string]float64, send func(string, interface{}) error) error {
+	references := map[Currency]Amount{}
+	table := StreamTable{Time: s.cb.now(), Quotes: []StreamQuote{}}
+
+	for sq := range s.cb.QuoteStream(r.Context(), info) {
+		var changed []StreamMarkup
+		event := StreamServiceQuotes{Service: sq.Service.ShortName(), Quotes: []StreamQuote{}}
+		if sq.Err != nil {
+			event.Error = sq.Err.Error()
+			table.Errors = append(table.Errors, event.Error)
+		}
+
+		for _, q := range sq.Quotes {
+			quote := StreamQuote{QuoteRecord: quoteRecord(q)}
+
+			reference, ok := references[q.Pair.Crypto]
+			if !ok {
+				// A failed lookup is remembered as zero so it isn't tried again this round.
+				reference, _ = s.cb.ReferencePrice(q.Pair)
+				references[q.Pair.Crypto] = reference
+			}
+			if reference != 0 {
+				quote.Reference = reference
+				markup := NewMarkup(q, reference).Percent
+				quote.Markup = &markup
+
+				key := event.Service + " " + string(q.Pair.Crypto)
+				previous, seen := markups[key]
+				markups[key] = markup
+				if !seen || math.Abs(previous-markup) >= markupResolution {
+					change := StreamMarkup{Service: event.Service, Crypto: q.Pair.Crypto, Markup: markup}
+					if seen {
+						change.Previous = &previous
+					}
+					changed = append(changed, change)
+				}
+			}
+
+			event.Quotes = append(event.Quotes, quote)
+		}
+
+		table.Quotes = append(table.Quotes, event.Quotes...)
+		err := send("quotes", &event)
+		if err != nil {
+			return err
+		}
+
+		for _, change := range changed {
+			err := send("markup", &change)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	sortStreamQuotes(table.Quotes)
+	return send("table", &table)
+}
+
+// sortStreamQuotes puts the cheapest first: by markup where known, otherwise by crypto amount.
+func sortStreamQuotes(quotes []StreamQuote) {
+	sort.SliceStable(quotes, func(i, j int) bool {
+		a, b := quotes[i], quotes[j]
+		if a.Markup != nil && b.Markup != nil {
+			return *a.Markup < *b.Markup
+		}
+		if (a.Markup == nil) != (b.Markup == nil) {
+			return a.Markup != nil
+		}
+		if a.Crypto != b.Crypto {
+			return a.Crypto < b.Crypto
+		}
+		return a.CryptoAmount < b.CryptoAmount
+	})
+}
@@ -0,0 +1,181 @@
+package cryptobill
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func streamCryptoBill(t *testing.T) *CryptoBill {
+	t.Helper()
+
+	config := &SandboxConfig{
+		Fiat:      "AUD",
+		Reference: map[Currency]Amount{"BTC": 10000, "ETH": 300},
+		Services: map[string]FakeServiceConfig{
+			"FAST": {Markup: 1},
+			"SLOW": {Markup: 2, Latency: "50ms"},
+			"DOWN": {QuoteFailRate: 1},
+		},
+	}
+	services, err := config.NewServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testCryptoBill(t,
+		WithServices(services...),
+		WithOracle(&FixedOracle{Fiat: "AUD", Prices: config.Reference}),
+		WithConfigDir(t.TempDir()),
+	)
+}
+
+func TestQuoteStream(t *testing.T) {
+	cb := streamCryptoBill(t)
+
+	var order []string
+	for sq := range cb.QuoteStream(context.Background(), &FiatInfo{Amount: 100, Fiat: "AUD"}) {
+		order = append(order, sq.Service.ShortName())
+		if sq.Service.ShortName() == "DOWN" {
+			if sq.Err == nil {
+				t.Error("expected DOWN to fail")
+			}
+		} else if sq.Err != nil || len(sq.Quotes) != 2 {
+			t.Errorf("%v: %v quotes, %v", sq.Service.ShortName(), len(sq.Quotes), sq.Err)
+		}
+	}
+
+	if len(order) != 3 || order[2] != "SLOW" {
+		t.Errorf("got %v, want every service with SLOW last", order)
+	}
+
+	// Nothing is sent once the caller has gone.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for sq := range cb.QuoteStream(ctx, &FiatInfo{Amount: 100, Fiat: "AUD"}) {
+		t.Errorf("got %v after cancelling", sq.Service.ShortName())
+	}
+
+	// Cancelling part way stops the slow service's request rather than waiting for it.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	start := time.Now()
+	for sq := range cb.QuoteStream(ctx, &FiatInfo{Amount: 100, Fiat: "AUD"}) {
+		cancel()
+		if sq.Service.ShortName() == "SLOW" {
+			t.Error("got SLOW after cancelling")
+		}
+	}
+	if took := time.Since(start); took >= 50*time.Millisecond {
+		t.Errorf("took %v to close, want SLOW's wait cut short", took)
+	}
+}
+
+// hangingService quotes from a server that doesn't answer.
+type hangingService struct {
+	Service
+	url string
+}
+
+func (s hangingService) Quote(cb *CryptoBill, info *FiatInfo) ([]QuoteResult, error) {
+	resp, err := cb.HttpClient.Get(s.url)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return nil, nil
+}
+
+func TestQuoteStreamCancelsRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	hanging := hangingService{Service: fakeServices(t, "HANG")[0], url: server.URL}
+	cb := testCryptoBill(t, WithServices(hanging))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	done := make(chan struct{})
+	go func() {
+		for range cb.QuoteStream(ctx, &FiatInfo{Amount: 100, Fiat: "AUD"}) {
+			t.Error("got a quote after cancelling")
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request carried on after cancelling")
+	}
+}
+
+func TestQuoteStreamEvents(t *testing.T) {
+	server := httptest.NewServer(NewAPIServer(streamCryptoBill(t), "token"))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", server.URL+"/api/v1/quotes/stream?amount=100&fiat=AUD", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer token")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// Each service's markups follow its quotes, before the next service's quotes.
+	var events []string
+	service := ""
+	scanner := bufio.NewScanner(resp.Body)
+	event := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "event: ") {
+			event = strings.TrimPrefix(line, "event: ")
+			continue
+		}
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+
+		var data struct {
+			Service string `json:"service"`
+			Crypto  string `json:"crypto"`
+		}
+		err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		switch event {
+		case "quotes":
+			service = data.Service
+			events = append(events, "quotes "+data.Service)
+		case "markup":
+			if data.Service != service {
+				t.Errorf("markup for %v after quotes from %v", data.Service, service)
+			}
+			events = append(events, "markup "+data.Service+" "+data.Crypto)
+		}
+		if event == "table" {
+			break
+		}
+	}
+
+	want := "quotes SLOW, markup SLOW BTC, markup SLOW ETH"
+	got := strings.Join(events, ", ")
+	if len(events) != 7 || !strings.HasSuffix(got, want) {
+		t.Errorf("got events %v, want 7 ending with %v", got, want)
+	}
+}
@@ -0,0 +1,302 @@
+package cryptobill
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracer is an http.RoundTripper which logs each request and response in full, and keeps them for
+// a HAR file, for seeing what a service actually sent back. Secrets, email addresses, and values
+// with names like "pin" or "token" are redacted first.
+type Tracer struct {
+	Transport http.RoundTripper
+
+	// Requests and responses are logged at debug level. If nil, nothing is logged.
+	Logger *slog.Logger
+
+	// Keep every exchange for WriteHAR.
+	HAR bool
+
+	// Replaced with Redacted wherever they appear.
+	Secrets []string
+
+	mu      sync.Mutex
+	entries []harEntry
+}
+
+// NewTracer wraps transport, or http.DefaultTransport if it's nil.
+func NewTracer(transport http.RoundTripper, secrets ...string) *Tracer {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	return &Tracer{Transport: transport, Secrets: secrets}
+}
+
+// Bodies longer than this are cut short in the log, but not in the HAR file.
+const maxLoggedBody = 64 << 10
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+(@|%40)[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+
+	// Names of JSON strings and query values that are secret.
+	secretNames = `[A-Za-z_]*(?i:pin|password|passphrase|token|secret|apikey|api_key|auth)[A-Za-z_]*`
+	secretName  = regexp.MustCompile(`^` + secretNames + `$`)
+	secretJSON  = regexp.MustCompile(`("` + secretNames + `"\s*:\s*)"[^"]*"`)
+	secretQuery = regexp.MustCompile(`([?&]` + secretNames + `=)[^&#\s]*`)
+)
+
+func (t *Tracer) scrub(s string) string {
+	s = redactSecrets(s, t.Secrets)
+	s = emailPattern.ReplaceAllString(s, Redacted)
+	s = secretJSON.ReplaceAllString(s, `$1"`+Redacted+`"`)
+	s = secretQuery.ReplaceAllString(s, `${1}`+Redacted)
+	return s
+}
+
+func (t *Tracer) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		reqBody, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "read request body")
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	url := t.scrub(req.URL.String())
+	t.log(req.Context(), "http request",
+		slog.String("method", req.Method),
+		slog.String("url", url),
+		slog.Any("header", redactHeader(req.Header, t.scrub)),
+		slog.String("body", t.scrub(truncate(reqBody))))
+
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	start := time.Now()
+	resp, err := transport.RoundTrip(req)
+	elapsed := time.Since(start)
+	if err != nil {
+		t.log(req.Context(), "http error",
+			slog.String("method", req.Method),
+			slog.String("url", url),
+			slog.Duration("elapsed", elapsed),
+			slog.String("error", t.scrub(err.Error())))
+		t.keep(req, reqBody, nil, nil, start, elapsed)
+		return nil, err
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "read response body")
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	t.log(req.Context(), "http response",
+		slog.String("method", req.Method),
+		slog.String("url", url),
+		slog.Int("status", resp.StatusCode),
+		slog.Duration("elapsed", elapsed),
+		slog.Any("header", redactHeader(resp.Header, t.scrub)),
+		slog.String("body", t.scrub(truncate(respBody))))
+	t.keep(req, reqBody, resp, respBody, start, elapsed)
+
+	return resp, nil
+}
+
+func (t *Tracer) log(ctx context.Context, msg string, attrs ...slog.Attr) {
+	if t.Logger != nil {
+		t.Logger.LogAttrs(ctx, slog.LevelDebug, msg, attrs...)
+	}
+}
+
+func truncate(body []byte) string {
+	if len(body) > maxLoggedBody {
+		return string(body[:maxLoggedBody]) + "..."
+	}
+	return string(body)
+}
+
+// The parts of HAR 1.2 that cryptobill fills in. See http://www.softwareishard.com/blog/har-12-spec/
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string        `json:"method"`
+	URL         string        `json:"url"`
+	HTTPVersion string        `json:"httpVersion"`
+	Cookies     []interface{} `json:"cookies"`
+	Headers     []harPair     `json:"headers"`
+	QueryString []harPair     `json:"queryString"`
+	PostData    *harPostData  `json:"postData,omitempty"`
+	HeadersSize int           `json:"headersSize"`
+	BodySize    int           `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int           `json:"status"`
+	StatusText  string        `json:"statusText"`
+	HTTPVersion string        `json:"httpVersion"`
+	Cookies     []interface{} `json:"cookies"`
+	Headers     []harPair     `json:"headers"`
+	Content     harContent    `json:"content"`
+	RedirectURL string        `json:"redirectURL"`
+	HeadersSize int           `json:"headersSize"`
+	BodySize    int           `json:"bodySize"`
+}
+
+type harPair struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// keep adds the exchange to the HAR entries, redacted. resp is nil if the request failed.
+func (t *Tracer) keep(req *http.Request, reqBody []byte, resp *http.Response, respBody []byte, start time.Time, elapsed time.Duration) {
+	if !t.HAR {
+		return
+	}
+
+	ms := float64(elapsed) / float64(time.Millisecond)
+	entry := harEntry{
+		StartedDateTime: start.Format("2006-01-02T15:04:05.000Z07:00"),
+		Time:            ms,
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         t.scrub(req.URL.String()),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []interface{}{},
+			Headers:     t.harHeaders(req.Header),
+			QueryString: []harPair{},
+			HeadersSize: -1,
+			BodySize:    len(reqBody),
+		},
+		Response: harResponse{
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []interface{}{},
+			Headers:     []harPair{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Timings: harTimings{Send: 0, Wait: ms, Receive: 0},
+	}
+
+	for k, values := range req.URL.Query() {
+		for _, v := range values {
+			if secretName.MatchString(k) {
+				v = Redacted
+			}
+			entry.Request.QueryString = append(entry.Request.QueryString, harPair{k, t.scrub(v)})
+		}
+	}
+	if len(reqBody) > 0 {
+		entry.Request.PostData = &harPostData{MimeType: req.Header.Get("Content-Type"), Text: t.scrub(string(reqBody))}
+	}
+
+	if resp == nil {
+		entry.Comment = "no response"
+	} else {
+		entry.Response.Status = resp.StatusCode
+		entry.Response.StatusText = strings.TrimPrefix(resp.Status, strconv.Itoa(resp.StatusCode)+" ")
+		entry.Response.Headers = t.harHeaders(resp.Header)
+		entry.Response.RedirectURL = resp.Header.Get("Location")
+		entry.Response.BodySize = len(respBody)
+		entry.Response.Content = harContent{
+			Size:     len(respBody),
+			MimeType: resp.Header.Get("Content-Type"),
+			Text:     t.scrub(string(respBody)),
+		}
+	}
+
+	t.mu.Lock()
+	t.entries = append(t.entries, entry)
+	t.mu.Unlock()
+}
+
+func (t *Tracer) harHeaders(h http.Header) []harPair {
+	pairs := []harPair{}
+	for k, values := range redactHeader(h, t.scrub) {
+		for _, v := range values {
+			pairs = append(pairs, harPair{k, v})
+		}
+	}
+	return pairs
+}
+
+// WriteHAR writes the exchanges kept so far as a HAR file, which browsers' developer tools and
+// other HAR viewers can open.
+func (t *Tracer) WriteHAR(w io.Writer) error {
+	t.mu.Lock()
+	entries := append([]harEntry{}, t.entries...)
+	t.mu.Unlock()
+
+	har := struct {
+		Log harLog `json:"log"`
+	}{harLog{Version: "1.2", Creator: harCreator{Name: "cryptobill", Version: "1"}, Entries: entries}}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return errors.Wrap(enc.Encode(har), "encode har")
+}
+
+// SaveHAR writes the HAR file to path.
+func (t *Tracer) SaveHAR(path string) error {
+	var buf bytes.Buffer
+	err := t.WriteHAR(&buf)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, buf.Bytes(), 0600)
+}
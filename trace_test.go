@@ -0,0 +1,95 @@
+package cryptobill
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTracer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cret"})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"Message": "", "Email": "me@example.com", "ApiToken": "t0ken", "Price": 9000}`))
+	}))
+	defer server.Close()
+
+	var logs bytes.Buffer
+	tracer := NewTracer(nil, "hunter2")
+	tracer.Logger = slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	tracer.HAR = true
+
+	cb := testCryptoBill(t, WithTracer(tracer))
+	req, err := http.NewRequest("POST", server.URL+"/tran/add?email=me%40example.com&pin=4321", strings.NewReader(`{"Pin": "4321", "Note": "hunter2"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer abc")
+	req.Header.Set("X-Api-Key", "sk_live_abc")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := cb.HttpClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "me@example.com") {
+		t.Errorf("tracing changed the response: %s", body)
+	}
+
+	var har bytes.Buffer
+	err = tracer.WriteHAR(&har)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, out := range map[string]string{"log": logs.String(), "har": har.String()} {
+		for _, secret := range []string{"me@example.com", "me%40example.com", "4321", "hunter2", "t0ken", "s3cret", "Bearer abc", "sk_live_abc"} {
+			if strings.Contains(out, secret) {
+				t.Errorf("%v contains %q:\n%s", name, secret, out)
+			}
+		}
+		if !strings.Contains(out, "9000") {
+			t.Errorf("%v is missing the response:\n%s", name, out)
+		}
+		if !strings.Contains(out, "X-Api-Key") || !strings.Contains(out, "application/json") {
+			t.Errorf("%v is missing the headers:\n%s", name, out)
+		}
+	}
+
+	var decoded struct {
+		Log struct {
+			Version string
+			Entries []struct {
+				Request struct {
+					Method   string
+					PostData struct{ Text string }
+				}
+				Response struct {
+					Status     int
+					StatusText string
+				}
+			}
+		}
+	}
+	err = json.Unmarshal(har.Bytes(), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Log.Version != "1.2" || len(decoded.Log.Entries) != 1 {
+		t.Fatalf("unexpected har:\n%s", har.String())
+	}
+	entry := decoded.Log.Entries[0]
+	if entry.Request.Method != "POST" || entry.Response.Status != 200 || entry.Response.StatusText != "OK" {
+		t.Errorf("wrong entry: %+v", entry)
+	}
+	if !strings.Contains(entry.Request.PostData.Text, `"Pin": "REDACTED"`) {
+		t.Errorf("post data = %q", entry.Request.PostData.Text)
+	}
+}
@@ -0,0 +1,303 @@
+package cryptobill
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HTTPError is returned by ProviderTransport for a response with a 4xx or 5xx status, instead of
+// leaving an error page for the service to fail to decode.
+type HTTPError struct {
+	StatusCode int
+	Status     string
+
+	// The start of the response, e.g. an error message or HTML page.
+	Body string
+
+	// From the Retry-After header, if there was one.
+	RetryAfter time.Duration
+}
+
+func (e *HTTPError) Error() string {
+	if e.Body == "" {
+		return e.Status
+	}
+	return e.Status + ": " + e.Body
+}
+
+// CircuitOpenError is returned without making a request while a host is failing.
+type CircuitOpenError struct {
+	Host  string
+	Until time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%v has been failing, not trying again until %v", e.Host, e.Until.Format("15:04:05"))
+}
+
+// ProviderTransport is an http.RoundTripper for calls to the services. It turns error statuses into
+// an *HTTPError, retries idempotent requests that fail in ways that might pass, and stops calling
+// a host for a while once its requests keep failing. Each service has its own host, so each gets
+// its own circuit.
+//
+// Requests other than GET, HEAD and OPTIONS, such as creating an order, are never retried, since
+// the first attempt may have worked.
+type ProviderTransport struct {
+	Transport http.RoundTripper
+
+	// Attempts after the first for idempotent requests.
+	Retries int
+
+	// The backoff doubles from MinBackoff up to MaxBackoff, with jitter.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// A Retry-After longer than this isn't waited for.
+	MaxRetryAfter time.Duration
+
+	// Requests to a host that fail in a row before its circuit opens, and how long it stays open.
+	FailureThreshold int
+	Cooldown         time.Duration
+
+	// Retries and circuits opening are logged as warnings. If nil, nothing is logged.
+	Logger *slog.Logger
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+	rand     *rand.Rand
+
+	// Replaced in tests.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+type circuit struct {
+	failures  int
+	openUntil time.Time
+
+	// Whether a request is being let through to see if the host is back.
+	probing bool
+}
+
+// NewProviderTransport wraps transport, or http.DefaultTransport if it's nil.
+func NewProviderTransport(transport http.RoundTripper) *ProviderTransport {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	return &ProviderTransport{
+		Transport:        transport,
+		Retries:          2,
+		MinBackoff:       500 * time.Millisecond,
+		MaxBackoff:       5 * time.Second,
+		MaxRetryAfter:    30 * time.Second,
+		FailureThreshold: 5,
+		Cooldown:         time.Minute,
+	}
+}
+
+func (t *ProviderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	err := t.allow(host)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.try(req)
+	if req.Context().Err() != nil {
+		// Given up on by the caller, which says nothing about the host.
+		t.release(host)
+	} else {
+		t.record(host, !failing(err))
+	}
+	return resp, err
+}
+
+// try makes the request, and again after a wait if it's idempotent and might work next time.
+func (t *ProviderTransport) try(req *http.Request) (*http.Response, error) {
+	retries := 0
+	if idempotent(req) {
+		retries = t.Retries
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := t.Transport.RoundTrip(req)
+		if err == nil {
+			resp, err = checkStatus(resp)
+		}
+		if attempt >= retries || !failing(err) || req.Context().Err() != nil {
+			return resp, err
+		}
+
+		wait := t.backoff(attempt)
+		if httpErr, ok := err.(*HTTPError); ok && httpErr.RetryAfter > 0 {
+			if httpErr.RetryAfter > t.MaxRetryAfter {
+				return resp, err
+			}
+			wait = httpErr.RetryAfter
+		}
+
+		if t.Logger != nil {
+			t.Logger.Warn("retrying", "host", req.URL.Host, "attempt", attempt+1, "wait", wait, "error", err)
+		}
+		err = t.wait(req.Context(), wait)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// backoff is a random wait between half and all of MinBackoff doubled for each attempt.
+func (t *ProviderTransport) backoff(attempt int) time.Duration {
+	d := t.MinBackoff << uint(attempt)
+	if d > t.MaxBackoff || d <= 0 {
+		d = t.MaxBackoff
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.rand == nil {
+		t.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return d/2 + time.Duration(t.rand.Int63n(int64(d/2)+1))
+}
+
+func (t *ProviderTransport) wait(ctx context.Context, d time.Duration) error {
+	if t.sleep != nil {
+		return t.sleep(ctx, d)
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// allow returns a *CircuitOpenError if host's circuit is open. Once the cooldown is over, one
+// request at a time is let through until one works.
+func (t *ProviderTransport) allow(host string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c := t.circuits[host]
+	if c == nil || c.failures < t.FailureThreshold {
+		return nil
+	}
+	if c.probing || time.Now().Before(c.openUntil) {
+		return &CircuitOpenError{Host: host, Until: c.openUntil}
+	}
+
+	c.probing = true
+	return nil
+}
+
+func (t *ProviderTransport) record(host string, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.circuits == nil {
+		t.circuits = map[string]*circuit{}
+	}
+	c := t.circuits[host]
+	if c == nil {
+		c = &circuit{}
+		t.circuits[host] = c
+	}
+
+	c.probing = false
+	if ok {
+		c.failures = 0
+		return
+	}
+
+	c.failures++
+	if c.failures >= t.FailureThreshold {
+		c.openUntil = time.Now().Add(t.Cooldown)
+		if t.Logger != nil {
+			t.Logger.Warn("circuit open", "host", host, "failures", c.failures, "until", c.openUntil)
+		}
+	}
+}
+
+func (t *ProviderTransport) release(host string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if c := t.circuits[host]; c != nil {
+		c.probing = false
+	}
+}
+
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", "GET", "HEAD", "OPTIONS":
+		return req.Body == nil || req.Body == http.NoBody
+	}
+	return false
+}
+
+// failing reports whether the service seems to be down or overloaded, rather than turning down
+// the request.
+func failing(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	httpErr, ok := err.(*HTTPError)
+	if !ok {
+		return true
+	}
+	switch httpErr.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	case http.StatusNotImplemented:
+		return false
+	}
+	return httpErr.StatusCode >= 500
+}
+
+// checkStatus closes a 4xx or 5xx response and returns it as an *HTTPError.
+func checkStatus(resp *http.Response) (*http.Response, error) {
+	if resp.StatusCode < 400 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 200))
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	return nil, &HTTPError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Body:       string(bytes.TrimSpace(body)),
+		RetryAfter: retryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// retryAfter reads a Retry-After header, which is either seconds or a date.
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,161 @@
+package cryptobill
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyServer answers with each of statuses in turn, then 200 "ok".
+func flakyServer(t *testing.T, statuses ...int) (*httptest.Server, *int) {
+	t.Helper()
+
+	var mu sync.Mutex
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		calls++
+		if calls <= len(statuses) {
+			if statuses[calls-1] == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "7")
+			}
+			w.WriteHeader(statuses[calls-1])
+			w.Write([]byte("<html>oops</html>"))
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+// testTransport doesn't wait between attempts, recording the waits instead.
+func testTransport() (*ProviderTransport, *[]time.Duration) {
+	var waits []time.Duration
+	t := NewProviderTransport(nil)
+	t.sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+	return t, &waits
+}
+
+func TestProviderTransportRetriesGet(t *testing.T) {
+	server, calls := flakyServer(t, 502, 429)
+	transport, waits := testTransport()
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok" || *calls != 3 {
+		t.Fatalf("got %q after %v calls, want ok after 3", body, *calls)
+	}
+
+	// The first wait is jittered backoff, the second is Retry-After.
+	if len(*waits) != 2 {
+		t.Fatalf("waited %v, want 2 waits", *waits)
+	}
+	if w := (*waits)[0]; w < transport.MinBackoff/2 || w > transport.MinBackoff {
+		t.Errorf("first wait %v, want between %v and %v", w, transport.MinBackoff/2, transport.MinBackoff)
+	}
+	if w := (*waits)[1]; w != 7*time.Second {
+		t.Errorf("second wait %v, want the 7s from Retry-After", w)
+	}
+}
+
+func TestProviderTransportStatusError(t *testing.T) {
+	server, calls := flakyServer(t, 500, 500, 500, 500)
+	transport, _ := testTransport()
+	client := &http.Client{Transport: transport}
+
+	_, err := client.Get(server.URL)
+	if err == nil || !strings.Contains(err.Error(), "500 Internal Server Error: <html>oops</html>") {
+		t.Fatalf("expected the status and page in the error, got %v", err)
+	}
+	if *calls != 1+transport.Retries {
+		t.Errorf("%v calls, want %v", *calls, 1+transport.Retries)
+	}
+
+	// Turning down the request isn't worth retrying.
+	server, calls = flakyServer(t, 404)
+	_, err = client.Get(server.URL)
+	if err == nil || *calls != 1 {
+		t.Errorf("got %v after %v calls, want a 404 error after 1", err, *calls)
+	}
+}
+
+func TestProviderTransportNeverRetriesPost(t *testing.T) {
+	server, calls := flakyServer(t, 503)
+	transport, _ := testTransport()
+	client := &http.Client{Transport: transport}
+
+	_, err := client.Post(server.URL+"/tran/add", "application/json", strings.NewReader("{}"))
+	if err == nil {
+		t.Fatal("expected the 503 to be returned")
+	}
+	if *calls != 1 {
+		t.Errorf("POST was made %v times, want once", *calls)
+	}
+}
+
+func TestProviderTransportLongRetryAfter(t *testing.T) {
+	server, calls := flakyServer(t, 429)
+	transport, waits := testTransport()
+	transport.MaxRetryAfter = time.Second
+	client := &http.Client{Transport: transport}
+
+	_, err := client.Get(server.URL)
+	if err == nil || *calls != 1 || len(*waits) != 0 {
+		t.Errorf("got %v after %v calls and waits %v, want to give up at once", err, *calls, *waits)
+	}
+}
+
+func TestProviderTransportCircuit(t *testing.T) {
+	server, calls := flakyServer(t, 500, 500, 500)
+	transport, _ := testTransport()
+	transport.Retries = 0
+	transport.FailureThreshold = 2
+	transport.Cooldown = 50 * time.Millisecond
+	client := &http.Client{Transport: transport}
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.Get(server.URL); err == nil {
+			t.Fatal("expected a 500")
+		}
+	}
+
+	_, err := client.Get(server.URL)
+	if !strings.Contains(err.Error(), "has been failing") || *calls != 2 {
+		t.Fatalf("got %v after %v calls, want the circuit open after 2", err, *calls)
+	}
+
+	// After the cooldown one request is let through. It fails, so the circuit opens again.
+	time.Sleep(transport.Cooldown)
+	if _, err := client.Get(server.URL); err == nil || *calls != 3 {
+		t.Fatalf("got %v after %v calls, want a probe that fails", err, *calls)
+	}
+	if _, err := client.Get(server.URL); err == nil || *calls != 3 {
+		t.Fatalf("got %v after %v calls, want the circuit open again", err, *calls)
+	}
+
+	// The next probe works and closes it.
+	time.Sleep(transport.Cooldown)
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+}
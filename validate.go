@@ -0,0 +1,84 @@
+package cryptobill
+
+import (
+	"fmt"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"regexp"
+	"strings"
+)
+
+var (
+	billerCodeRE    = regexp.MustCompile(`^[0-9]{3,10}$`)
+	crnRE           = regexp.MustCompile(`^[0-9]{2,20}$`)
+	bsbRE           = regexp.MustCompile(`^[0-9]{3}-?[0-9]{3}$`)
+	accountNumberRE = regexp.MustCompile(`^[0-9]{1,9}$`)
+)
+
+// Validate checks the bill's fields are well formed. checkCRN also checks the BPAY reference has a
+// valid MOD10V01 (Luhn) check digit, which most but not all billers use.
+func (b *Bill) Validate(checkCRN bool) error {
+	var errs error
+	fail := func(format string, args ...interface{}) {
+		errs = multierror.Append(errs, errors.Errorf(format, args...))
+	}
+
+	if strings.TrimSpace(b.Name) == "" {
+		fail("missing name")
+	}
+
+	switch b.Type() {
+	case "BPAY":
+		if b.EFT != (EFT{}) {
+			fail("has both BPAY and EFT details")
+		}
+		if !billerCodeRE.MatchString(fmt.Sprint(b.BPAY.Code)) {
+			fail("biller code %v should be 3 to 10 digits", b.BPAY.Code)
+		}
+		if !crnRE.MatchString(b.BPAY.Account) {
+			fail("reference %q should be 2 to 20 digits", b.BPAY.Account)
+		} else if checkCRN && !luhnValid(b.BPAY.Account) {
+			fail("reference %q has a bad check digit", b.BPAY.Account)
+		}
+	case "EFT":
+		if !bsbRE.MatchString(b.EFT.BSB) {
+			fail("BSB %q should be 6 digits, e.g. 062-000", b.EFT.BSB)
+		}
+		if !accountNumberRE.MatchString(b.EFT.AccountNumber) {
+			fail("account number %q should be 1 to 9 digits", b.EFT.AccountNumber)
+		}
+		if strings.TrimSpace(b.EFT.AccountName) == "" {
+			fail("missing account name")
+		}
+	default:
+		fail("has neither BPAY nor EFT details")
+	}
+
+	if b.Schedule != nil {
+		err := b.Schedule.Validate()
+		if err != nil {
+			fail("schedule: %v", err)
+		}
+	}
+
+	return errs
+}
+
+// luhnValid checks the last digit of a string of digits is its Luhn check digit.
+func luhnValid(digits string) bool {
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+
+	return sum%10 == 0
+}
@@ -0,0 +1,511 @@
+package cryptobill
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The vault is an encrypted replacement for bills.json which also holds provider credentials.
+// Once vault.json exists in the config directory it is used instead of the plain bills file.
+//
+// The key is derived from a passphrase with Argon2id and the contents are sealed with AES-256-GCM,
+// with the KDF parameters authenticated as additional data so they can't be tampered with.
+
+const vaultVersion = 1
+
+// ErrVaultLocked is returned when the vault key is needed but there is no running agent and no way
+// to ask for the passphrase.
+var ErrVaultLocked = errors.New("vault is locked, run \"cryptobill vault unlock\"")
+
+// VaultData is the decrypted contents of the vault.
+type VaultData struct {
+	// Version of the bills schema, see BillsVersion.
+	Version int
+	Bills   Bills
+
+	// Auth details keyed by the service's short name, e.g. your PBC email address.
+	Credentials map[string]string
+}
+
+type vaultFile struct {
+	Version    int
+	KDF        kdfParams
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+type kdfParams struct {
+	Name    string
+	Salt    []byte
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+// The cost of deriving the key for a new or rekeyed vault. Vaults keep the parameters they were
+// made with.
+var defaultKDF = kdfParams{
+	Name:    "argon2id",
+	Time:    3,
+	Memory:  64 * 1024,
+	Threads: 4,
+}
+
+func newKDFParams() (kdfParams, error) {
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return kdfParams{}, errors.Wrap(err, "salt")
+	}
+
+	params := defaultKDF
+	params.Salt = salt
+	return params, nil
+}
+
+func (p kdfParams) deriveKey(passphrase string) ([]byte, error) {
+	if p.Name != "argon2id" {
+		return nil, errors.New("unsupported kdf: " + p.Name)
+	}
+
+	return argon2.IDKey([]byte(passphrase), p.Salt, p.Time, p.Memory, p.Threads, 32), nil
+}
+
+// additionalData binds the ciphertext to the header it was written with.
+func (vf *vaultFile) additionalData() ([]byte, error) {
+	return json.Marshal(struct {
+		Version int
+		KDF     kdfParams
+	}{vf.Version, vf.KDF})
+}
+
+// open decrypts the vault. The returned bool is set if the bills in it were migrated from an older
+// version, in which case it should be saved.
+func (vf *vaultFile) open(key []byte) (*VaultData, bool, error) {
+	if vf.Version != vaultVersion {
+		return nil, false, errors.Errorf("unsupported vault version %v", vf.Version)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	ad, err := vf.additionalData()
+	if err != nil {
+		return nil, false, errors.Wrap(err, "additional data")
+	}
+
+	plain, err := gcm.Open(nil, vf.Nonce, vf.Ciphertext, ad)
+	if err != nil {
+		return nil, false, errors.New("wrong passphrase or corrupted vault")
+	}
+
+	// The bills are migrated the same way as the plain bills file.
+	raw := struct {
+		Version     int
+		Bills       json.RawMessage
+		Credentials map[string]string
+	}{}
+	err = json.Unmarshal(plain, &raw)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "decode vault contents")
+	}
+	if raw.Version == 0 {
+		// Vaults made before bills were versioned already used the version 2 layout.
+		raw.Version = 2
+	}
+	if raw.Bills == nil {
+		raw.Bills = json.RawMessage("{}")
+	}
+
+	envelope, err := json.Marshal(struct {
+		Version int
+		Bills   json.RawMessage
+	}{raw.Version, raw.Bills})
+	if err != nil {
+		return nil, false, errors.Wrap(err, "encode vault bills")
+	}
+
+	doc, version, err := migrateBills(envelope)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "vault")
+	}
+
+	data := &VaultData{
+		Version:     BillsVersion,
+		Bills:       doc.Bills,
+		Credentials: raw.Credentials,
+	}
+	if data.Credentials == nil {
+		data.Credentials = map[string]string{}
+	}
+
+	return data, version != BillsVersion, nil
+}
+
+func (vf *vaultFile) seal(key []byte, data *VaultData) error {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+
+	plain, err := json.Marshal(data)
+	if err != nil {
+		return errors.Wrap(err, "encode vault contents")
+	}
+
+	vf.Version = vaultVersion
+	vf.Nonce = make([]byte, gcm.NonceSize())
+	_, err = rand.Read(vf.Nonce)
+	if err != nil {
+		return errors.Wrap(err, "nonce")
+	}
+
+	ad, err := vf.additionalData()
+	if err != nil {
+		return errors.Wrap(err, "additional data")
+	}
+
+	vf.Ciphertext = gcm.Seal(nil, vf.Nonce, plain, ad)
+	return nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "aes")
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "gcm")
+	}
+
+	return gcm, nil
+}
+
+func readVaultFile(path string) (*vaultFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read "+path)
+	}
+
+	vf := &vaultFile{}
+	err = json.Unmarshal(data, vf)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode json from "+path)
+	}
+
+	return vf, nil
+}
+
+func writeVaultFile(path string, vf *vaultFile) error {
+	data, err := json.MarshalIndent(vf, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encode vault")
+	}
+
+	err = backupFile(path)
+	if err != nil {
+		return errors.Wrap(err, "backup vault")
+	}
+
+	return writeFileAtomic(path, append(data, '\n'), 0600)
+}
+
+func (cb *CryptoBill) vaultPath() (string, error) {
+	dir, err := cb.configDir()
+	if err != nil {
+		return "", errors.Wrap(err, "config dir")
+	}
+
+	return filepath.Join(dir, "vault.json"), nil
+}
+
+// HasVault reports whether an encrypted vault has been created.
+func (cb *CryptoBill) HasVault() (bool, error) {
+	path, err := cb.vaultPath()
+	if err != nil {
+		return false, err
+	}
+
+	_, err = os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "stat "+path)
+	}
+
+	return true, nil
+}
+
+// InitVault creates the vault, moving any existing plain bills into it. The plain bills file and
+// its backups are removed afterwards since they would defeat the point.
+func (cb *CryptoBill) InitVault(passphrase string) error {
+	if passphrase == "" {
+		return errors.New("empty passphrase")
+	}
+
+	exists, err := cb.HasVault()
+	if err != nil {
+		return err
+	}
+	if exists {
+		return errors.New("vault already exists")
+	}
+
+	billsPath, err := cb.billsPath()
+	if err != nil {
+		return errors.Wrap(err, "bills path")
+	}
+
+	unlockBills, err := lockPath(billsPath)
+	if err != nil {
+		return errors.Wrap(err, "lock bills")
+	}
+	defer unlockBills()
+
+	bills, _, err := loadBills(billsPath)
+	if err != nil {
+		return errors.Wrap(err, "load bills")
+	}
+
+	kdf, err := newKDFParams()
+	if err != nil {
+		return err
+	}
+
+	key, err := kdf.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+
+	vf := &vaultFile{KDF: kdf}
+	err = vf.seal(key, &VaultData{Version: BillsVersion, Bills: bills, Credentials: map[string]string{}})
+	if err != nil {
+		return errors.Wrap(err, "seal vault")
+	}
+
+	path, err := cb.vaultPath()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockPath(path)
+	if err != nil {
+		return errors.Wrap(err, "lock vault")
+	}
+	defer unlock()
+
+	err = writeVaultFile(path, vf)
+	if err != nil {
+		return errors.Wrap(err, "write vault")
+	}
+
+	err = os.Remove(billsPath)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "remove plain bills")
+	}
+
+	return removeBackups(billsPath)
+}
+
+// UnlockVault checks the passphrase against the vault and returns the derived key, which can be
+// handed to an agent with ServeAgent.
+func (cb *CryptoBill) UnlockVault(passphrase string) ([]byte, error) {
+	path, err := cb.vaultPath()
+	if err != nil {
+		return nil, err
+	}
+
+	vf, err := readVaultFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := vf.KDF.deriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	_, _, err = vf.open(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// RekeyVault re-encrypts the vault under a new passphrase with a fresh salt. Any running agent
+// holds the old key, so it is stopped.
+func (cb *CryptoBill) RekeyVault(oldPassphrase, newPassphrase string) error {
+	if newPassphrase == "" {
+		return errors.New("empty passphrase")
+	}
+
+	path, err := cb.vaultPath()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockPath(path)
+	if err != nil {
+		return errors.Wrap(err, "lock vault")
+	}
+	defer unlock()
+
+	vf, err := readVaultFile(path)
+	if err != nil {
+		return err
+	}
+
+	oldKey, err := vf.KDF.deriveKey(oldPassphrase)
+	if err != nil {
+		return err
+	}
+
+	data, _, err := vf.open(oldKey)
+	if err != nil {
+		return err
+	}
+
+	kdf, err := newKDFParams()
+	if err != nil {
+		return err
+	}
+
+	key, err := kdf.deriveKey(newPassphrase)
+	if err != nil {
+		return err
+	}
+
+	vf = &vaultFile{KDF: kdf}
+	err = vf.seal(key, data)
+	if err != nil {
+		return errors.Wrap(err, "seal vault")
+	}
+
+	err = writeVaultFile(path, vf)
+	if err != nil {
+		return errors.Wrap(err, "write vault")
+	}
+
+	// The backups were encrypted with the old passphrase, which may be why it's being changed.
+	err = removeBackups(path)
+	if err != nil {
+		return err
+	}
+
+	return cb.LockVault()
+}
+
+// vaultKey finds the key for vf, first from a running agent, then by asking for the passphrase.
+func (cb *CryptoBill) vaultKey(vf *vaultFile) ([]byte, error) {
+	socket, err := cb.AgentSocket()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := agentKey(socket)
+	if err == nil {
+		return key, nil
+	}
+
+	if cb.Passphrase == nil {
+		return nil, ErrVaultLocked
+	}
+
+	passphrase, err := cb.Passphrase("Vault passphrase: ")
+	if err != nil {
+		return nil, errors.Wrap(err, "passphrase")
+	}
+
+	return vf.KDF.deriveKey(passphrase)
+}
+
+// withVault decrypts the vault under lock and passes it to fn. If save is set, or the bills in it
+// were migrated, the vault is sealed and written back afterwards.
+func (cb *CryptoBill) withVault(save bool, fn func(*VaultData) error) error {
+	path, err := cb.vaultPath()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockPath(path)
+	if err != nil {
+		return errors.Wrap(err, "lock vault")
+	}
+	defer unlock()
+
+	vf, err := readVaultFile(path)
+	if err != nil {
+		return err
+	}
+
+	key, err := cb.vaultKey(vf)
+	if err != nil {
+		return err
+	}
+
+	data, migrated, err := vf.open(key)
+	if err != nil {
+		return err
+	}
+
+	err = fn(data)
+	if err != nil || !save && !migrated {
+		return err
+	}
+
+	err = vf.seal(key, data)
+	if err != nil {
+		return errors.Wrap(err, "seal vault")
+	}
+
+	return writeVaultFile(path, vf)
+}
+
+// SetCredential stores the auth details for a service in the vault, or removes them if auth is
+// empty.
+func (cb *CryptoBill) SetCredential(service, auth string) error {
+	s, err := cb.Service(service)
+	if err != nil {
+		return err
+	}
+
+	return cb.withVault(true, func(data *VaultData) error {
+		if auth == "" {
+			delete(data.Credentials, s.ShortName())
+		} else {
+			data.Credentials[s.ShortName()] = auth
+		}
+		return nil
+	})
+}
+
+// Credential returns the auth details stored in the vault for a service. An empty string is
+// returned without error when there is no vault.
+func (cb *CryptoBill) Credential(service string) (string, error) {
+	exists, err := cb.HasVault()
+	if err != nil || !exists {
+		return "", err
+	}
+
+	var auth string
+	err = cb.withVault(false, func(data *VaultData) error {
+		auth = data.Credentials[strings.ToUpper(service)]
+		return nil
+	})
+
+	return auth, err
+}
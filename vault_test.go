@@ -0,0 +1,224 @@
+package cryptobill
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// vaultCryptoBill has a config dir and agent socket of its own, and a key that's quick to derive.
+func vaultCryptoBill(t *testing.T) *CryptoBill {
+	t.Helper()
+
+	kdf := defaultKDF
+	t.Cleanup(func() { defaultKDF = kdf })
+	defaultKDF = kdfParams{Name: "argon2id", Time: 1, Memory: 64, Threads: 1}
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	t.Setenv(BillsPathEnv, "")
+
+	return testCryptoBill(t, WithConfigDir(t.TempDir()))
+}
+
+func testVaultData() *VaultData {
+	return &VaultData{
+		Version:     BillsVersion,
+		Bills:       Bills{"rent": {Name: "rent", BPAY: BPAY{Code: 23796, Account: "1234567897"}}},
+		Credentials: map[string]string{"PBC": "me@example.com"},
+	}
+}
+
+func TestVaultSealOpen(t *testing.T) {
+	vaultCryptoBill(t)
+	kdf, err := newKDFParams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := kdf.deriveKey("correct horse")
+
+	sealed := func() *vaultFile {
+		vf := &vaultFile{KDF: kdf}
+		err := vf.seal(key, testVaultData())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return vf
+	}
+
+	data, migrated, err := sealed().open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated || data.Bills["rent"].BPAY.Code != 23796 || data.Credentials["PBC"] != "me@example.com" {
+		t.Errorf("got %+v, migrated %v", data, migrated)
+	}
+
+	wrong, _ := kdf.deriveKey("wrong horse")
+	if _, _, err := sealed().open(wrong); err == nil {
+		t.Error("expected the wrong passphrase to fail")
+	}
+
+	vf := sealed()
+	vf.Ciphertext[0] ^= 1
+	if _, _, err := vf.open(key); err == nil {
+		t.Error("expected tampered ciphertext to fail")
+	}
+
+	// Weakening the KDF in the header is caught, since it's authenticated with the contents.
+	vf = sealed()
+	vf.KDF.Time++
+	if _, _, err := vf.open(key); err == nil {
+		t.Error("expected tampered kdf params to fail")
+	}
+}
+
+func TestVaultInitRekey(t *testing.T) {
+	cb := vaultCryptoBill(t)
+	err := cb.AddBill(&Bill{Name: "rent", BPAY: BPAY{Code: 23796, Account: "1234567897"}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cb.AddBill(&Bill{Name: "water", BPAY: BPAY{Code: 1, Account: "0"}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cb.InitVault("old")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The plain bills and their backups are gone.
+	billsPath, _ := cb.billsPath()
+	if _, err := os.Stat(billsPath); !os.IsNotExist(err) {
+		t.Errorf("plain bills still there: %v", err)
+	}
+	if backups, _ := listBackups(billsPath); len(backups) != 0 {
+		t.Errorf("plain backups still there: %v", backups)
+	}
+
+	if _, err := cb.LoadBills(); errors.Cause(err) != ErrVaultLocked {
+		t.Errorf("expected ErrVaultLocked without a passphrase, got %v", err)
+	}
+
+	passphrase := "old"
+	cb.Passphrase = func(string) (string, error) { return passphrase, nil }
+	bills, err := cb.LoadBills()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bills) != 2 || bills["rent"].BPAY.Code != 23796 {
+		t.Errorf("got bills %v", bills)
+	}
+
+	// Saving again makes an encrypted backup, which rekeying removes.
+	err = cb.RemoveBill("water")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vaultPath, _ := cb.vaultPath()
+	if backups, _ := listBackups(vaultPath); len(backups) == 0 {
+		t.Error("expected a backup of the vault")
+	}
+
+	if _, err := cb.UnlockVault("new"); err == nil {
+		t.Error("expected the wrong passphrase to fail")
+	}
+	err = cb.RekeyVault("old", "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backups, _ := listBackups(vaultPath); len(backups) != 0 {
+		t.Errorf("backups under the old passphrase still there: %v", backups)
+	}
+	if _, err := cb.UnlockVault("old"); err == nil {
+		t.Error("expected the old passphrase to fail after rekeying")
+	}
+
+	passphrase = "new"
+	bills, err = cb.LoadBills()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bills) != 1 || bills["rent"] == nil {
+		t.Errorf("got bills %v after rekeying", bills)
+	}
+}
+
+// serveAgent runs an agent for cb's vault in the background and waits for it to answer.
+func serveAgent(t *testing.T, cb *CryptoBill, key []byte, timeout time.Duration) chan error {
+	t.Helper()
+
+	socket, err := cb.AgentSocket()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeAgent(socket, append([]byte(nil), key...), timeout)
+	}()
+
+	for i := 0; !cb.AgentRunning(); i++ {
+		if i == 100 {
+			t.Fatal("agent didn't start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return done
+}
+
+func TestVaultAgent(t *testing.T) {
+	cb := vaultCryptoBill(t)
+	err := cb.InitVault("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := cb.UnlockVault("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// With an agent running, no passphrase is needed.
+	done := serveAgent(t, cb, key, time.Minute)
+	err = cb.SetCredential("PBC", "me@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := cb.Credential("pbc")
+	if err != nil || auth != "me@example.com" {
+		t.Errorf("got credential %q, %v", auth, err)
+	}
+
+	err = cb.LockVault()
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent didn't stop when locked")
+	}
+	if _, err := cb.Credential("pbc"); errors.Cause(err) != ErrVaultLocked {
+		t.Errorf("expected ErrVaultLocked after locking, got %v", err)
+	}
+
+	// The agent stops by itself once the timeout passes, and cleans up its socket.
+	done = serveAgent(t, cb, key, 200*time.Millisecond)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent didn't expire")
+	}
+	if cb.AgentRunning() {
+		t.Error("agent still answering after expiring")
+	}
+	socket, _ := cb.AgentSocket()
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("socket %v left behind: %v", filepath.Base(socket), err)
+	}
+}
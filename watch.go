@@ -0,0 +1,216 @@
+package cryptobill
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AlertRule fires when a quote's markup over the reference price is at or below Under percent.
+// Empty fields match anything.
+type AlertRule struct {
+	// Quote the amount of this scheduled bill instead of the watch amount.
+	Bill string
+
+	Crypto  Currency
+	Service string
+	Under   float64
+}
+
+// ParseAlertRule reads a rule like "bill=rates crypto=BTC under=3". under is required.
+func ParseAlertRule(s string) (*AlertRule, error) {
+	rule := &AlertRule{}
+	under := false
+
+	for _, field := range strings.Fields(s) {
+		bits := strings.SplitN(field, "=", 2)
+		if len(bits) != 2 {
+			return nil, errors.Errorf("rule %q: %q should look like key=value", s, field)
+		}
+
+		key, value := strings.ToLower(bits[0]), bits[1]
+		switch key {
+		case "bill":
+			rule.Bill = value
+		case "crypto":
+			crypto, err := NewCurrencyFromString(value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "rule %q", s)
+			}
+			rule.Crypto = crypto
+		case "service":
+			rule.Service = strings.ToUpper(value)
+		case "under":
+			percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+			if err != nil {
+				return nil, errors.Errorf("rule %q: under should be a percentage", s)
+			}
+			rule.Under = percent
+			under = true
+		default:
+			return nil, errors.Errorf("rule %q: unknown key %q, use bill, crypto, service or under", s, key)
+		}
+	}
+
+	if !under {
+		return nil, errors.Errorf("rule %q: needs under=<percent>", s)
+	}
+
+	return rule, nil
+}
+
+func (r *AlertRule) String() string {
+	crypto := "any"
+	if r.Crypto != "" {
+		crypto = string(r.Crypto)
+	}
+	s := fmt.Sprintf("%v route under %g%%", crypto, r.Under)
+
+	if r.Service != "" {
+		s += " with " + r.Service
+	}
+	if r.Bill != "" {
+		s += " for bill '" + r.Bill + "'"
+	}
+	return s
+}
+
+// Matches reports whether the rule covers the route, ignoring the markup.
+func (r *AlertRule) Matches(bill string, q QuoteResult) bool {
+	if r.Bill != bill {
+		return false
+	}
+	if r.Crypto != "" && r.Crypto != q.Pair.Crypto {
+		return false
+	}
+	if r.Service != "" && !strings.EqualFold(r.Service, q.Service.ShortName()) {
+		return false
+	}
+	return true
+}
+
+// Markup is how much more a quote costs than buying the crypto at the reference price.
+type Markup struct {
+	Quote QuoteResult
+
+	// Fiat price of one unit of the crypto.
+	Reference Amount
+
+	Percent float64
+}
+
+func NewMarkup(q QuoteResult, reference Amount) Markup {
+	return Markup{
+		Quote:     q,
+		Reference: reference,
+		Percent:   float64(reference*q.Conversion.Crypto/q.Conversion.Fiat)*100 - 100,
+	}
+}
+
+// Alert is a rule being met by a route.
+type Alert struct {
+	Rule   *AlertRule
+	Bill   string
+	Markup Markup
+	At     time.Time
+}
+
+func (a *Alert) String() string {
+	q := a.Markup.Quote
+	return fmt.Sprintf("%v: %v %v costs %5.5f %v (%.3f%% markup) to pay %.2f %v",
+		a.Rule,
+		q.Service.ShortName(),
+		q.Pair.Crypto,
+		q.Conversion.Crypto,
+		q.Pair.Crypto,
+		a.Markup.Percent,
+		q.Conversion.Fiat,
+		q.Pair.Fiat,
+	)
+}
+
+// RouteStats tracks the markup of one service and coin over a watch.
+type RouteStats struct {
+	Bill    string
+	Service string
+	Crypto  Currency
+
+	Last, Best, Worst float64
+	Seen              int
+}
+
+// Watch tracks markups across quotes and works out which rules have started being met. A rule
+// only alerts again for a route after the route has gone back over the limit.
+type Watch struct {
+	Rules []*AlertRule
+
+	stats  map[string]*RouteStats
+	firing map[string]bool
+}
+
+func NewWatch(rules []*AlertRule) *Watch {
+	return &Watch{
+		Rules:  rules,
+		stats:  map[string]*RouteStats{},
+		firing: map[string]bool{},
+	}
+}
+
+// Observe records a round of markups for a bill (or "" for the watch amount) and returns the
+// alerts that have just started firing.
+func (w *Watch) Observe(bill string, markups []Markup, now time.Time) []Alert {
+	var alerts []Alert
+
+	for _, m := range markups {
+		q := m.Quote
+		key := bill + " " + q.Service.ShortName() + " " + string(q.Pair.Crypto)
+
+		stats, ok := w.stats[key]
+		if !ok {
+			stats = &RouteStats{Bill: bill, Service: q.Service.ShortName(), Crypto: q.Pair.Crypto, Best: m.Percent, Worst: m.Percent}
+			w.stats[key] = stats
+		}
+		stats.Last = m.Percent
+		stats.Seen++
+		if m.Percent < stats.Best {
+			stats.Best = m.Percent
+		}
+		if m.Percent > stats.Worst {
+			stats.Worst = m.Percent
+		}
+
+		for i, rule := range w.Rules {
+			if !rule.Matches(bill, q) {
+				continue
+			}
+
+			ruleKey := fmt.Sprint(i, " ", key)
+			met := m.Percent <= rule.Under
+			if met && !w.firing[ruleKey] {
+				alerts = append(alerts, Alert{Rule: rule, Bill: bill, Markup: m, At: now})
+			}
+			w.firing[ruleKey] = met
+		}
+	}
+
+	return alerts
+}
+
+// Stats returns every route seen so far, cheapest first.
+func (w *Watch) Stats() []RouteStats {
+	var stats []RouteStats
+	for _, s := range w.stats {
+		stats = append(stats, *s)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Bill != stats[j].Bill {
+			return stats[i].Bill < stats[j].Bill
+		}
+		return stats[i].Last < stats[j].Last
+	})
+	return stats
+}